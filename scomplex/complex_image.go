@@ -11,6 +11,25 @@ import (
 	. "github.com/Causticity/sipp/simage"
 )
 
+// A SippComplexImage is an image of complex values, such as a gradient. Both
+// ComplexImage and ComplexInt32Image implement it, so that clients such as
+// histograms can accept either representation.
+type SippComplexImage interface {
+	// Bounds returns the rectangle defining the bounds of the image.
+	Bounds() image.Rectangle
+	// MaxModulus returns the maximum modulus value that occurs in the image.
+	MaxModulus() float64
+	// Extremes returns the extreme real and imaginary values that occur in
+	// the image, as float64s regardless of the pixel representation.
+	Extremes() (minRe, maxRe, minIm, maxIm float64)
+	// Render renders the real and imaginary parts of the image as separate
+	// 8-bit grayscale images.
+	Render() (SippImage, SippImage)
+	// Values returns the pixel values in row order as complex128s. The slice
+	// may be the image's own storage, so it must not be modified.
+	Values() []complex128
+}
+
 // A ComplexImage is an image where each pixel is a Go complex128.
 type ComplexImage struct {
 	// The "pixel" data.
@@ -33,6 +52,27 @@ func FromComplexArray(cpx []complex128, width int) (dst *ComplexImage) {
 	return
 }
 
+// Bounds returns the rectangle defining the bounds of the image.
+func (comp *ComplexImage) Bounds() image.Rectangle {
+	return comp.Rect
+}
+
+// MaxModulus returns the maximum modulus value that occurs in the image.
+func (comp *ComplexImage) MaxModulus() float64 {
+	return comp.MaxMod
+}
+
+// Extremes returns the extreme real and imaginary values that occur in the
+// image.
+func (comp *ComplexImage) Extremes() (minRe, maxRe, minIm, maxIm float64) {
+	return comp.MinRe, comp.MaxRe, comp.MinIm, comp.MaxIm
+}
+
+// Values returns the pixel values, which are the image's own storage.
+func (comp *ComplexImage) Values() []complex128 {
+	return comp.Pix
+}
+
 func (comp *ComplexImage) SetScaling() {
 	comp.MinRe = math.MaxFloat64
 	comp.MinIm = math.MaxFloat64
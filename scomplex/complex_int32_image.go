@@ -60,6 +60,32 @@ func FromComplexInt32Array(cpx []ComplexInt32, width int) (dst *ComplexInt32Imag
 	return
 }
 
+// Bounds returns the rectangle defining the bounds of the image.
+func (comp *ComplexInt32Image) Bounds() image.Rectangle {
+	return comp.Rect
+}
+
+// MaxModulus returns the maximum modulus value that occurs in the image.
+func (comp *ComplexInt32Image) MaxModulus() float64 {
+	return comp.MaxMod
+}
+
+// Extremes returns the extreme real and imaginary values that occur in the
+// image, converted to float64s.
+func (comp *ComplexInt32Image) Extremes() (minRe, maxRe, minIm, maxIm float64) {
+	return float64(comp.MinRe), float64(comp.MaxRe),
+		float64(comp.MinIm), float64(comp.MaxIm)
+}
+
+// Values returns a new slice of the pixel values converted to complex128s.
+func (comp *ComplexInt32Image) Values() []complex128 {
+	vals := make([]complex128, len(comp.Pix))
+	for i, pix := range comp.Pix {
+		vals[i] = complex(float64(pix.Re), float64(pix.Im))
+	}
+	return vals
+}
+
 // ToShiftedInt32Complex converts the input image into a ComplexInt32Image,
 // multiplying each pixel by (-1)^(x+y), in order for a subsequent FFT to be
 // centred properly.
@@ -0,0 +1,315 @@
+// Copyright Raul Vera 2015-2021
+
+// Package sedge provides an implementation of the Canny edge detector for the
+// sipp package, built on the finite-difference gradients of package sgrad.
+//
+// With a 2x2 gradient kernel, the edge images are one pixel narrower and
+// shorter than the source, as for the gradient, and pixel x, y lies at the
+// corner shared by source pixels x, y to x+1, y+1. With the 3x3 Sobel and
+// Scharr operators, they are the same size as the source, and pixel x, y lies
+// at the centre of source pixel x, y.
+package sedge
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	. "github.com/Causticity/sipp/sgrad"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// A ThresholdMode selects how the hysteresis thresholds are given.
+type ThresholdMode int
+
+const (
+	// Auto selects the thresholds from the distribution of non-zero
+	// gradient magnitudes: the high threshold is exceeded by 30% of the
+	// non-zero gradient pixels and the low one is 0.4 times the high one, so
+	// that large flat areas don't pull the thresholds down to 0. Low and High
+	// are ignored.
+	Auto ThresholdMode = iota
+	// Absolute thresholds are gradient magnitudes, in grey levels per pixel.
+	Absolute
+	// Percentile thresholds are percentiles, from 0 to 100, of the gradient
+	// magnitudes.
+	Percentile
+)
+
+// An Operator selects how the gradient is computed.
+type Operator int
+
+const (
+	// KernelOperator applies the 2x2 Kernel of the options, as by
+	// sgrad.FdgradKernel.
+	KernelOperator Operator = iota
+	// SobelOperator applies the 3x3 Sobel operator at each pixel.
+	SobelOperator
+	// ScharrOperator applies the 3x3 Scharr operator at each pixel, which
+	// is closer to rotationally symmetric than Sobel.
+	ScharrOperator
+)
+
+// The centre weights of the smoothing across the difference for the 3x3
+// operators, whose outer weights are 1.
+const (
+	sobelCentre  = 2.0
+	scharrCentre = 10.0 / 3
+)
+
+// The fraction of non-zero gradient pixels below the automatic high threshold,
+// and the
+// ratio of the automatic low threshold to the high one.
+const (
+	autoNonEdgeFraction = 0.7
+	autoLowRatio        = 0.4
+)
+
+// CannyOptions control the edge detector. The zero value smooths with a
+// standard deviation of 0, uses the default 2x2 gradient kernel and selects
+// the thresholds automatically.
+type CannyOptions struct {
+	// The standard deviation, in pixels, of the Gaussian with which the
+	// image is smoothed before the gradient is computed, as by
+	// FloatImage.GaussianSmooth.
+	Sigma float64
+	// The gradient operator.
+	Operator Operator
+	// The kernel for KernelOperator. If it is the zero kernel,
+	// sgrad.DefaultKernel is used. It is ignored by the other operators.
+	Kernel SippGradKernel
+	// How Low and High are interpreted.
+	Mode ThresholdMode
+	// The hysteresis thresholds. Edges start at pixels whose gradient
+	// magnitude is at least High and continue through connected pixels whose
+	// magnitude is at least Low.
+	Low, High float64
+	// If Orientation is true, the orientation of the edges is also returned.
+	Orientation bool
+}
+
+// SippEdges holds the result of the Canny edge detector.
+type SippEdges struct {
+	// The edge pixels, 255 on edges and 0 elsewhere.
+	Edges *SippGray
+	// The gradient magnitude after smoothing, in image coordinates, whatever
+	// the kernel.
+	Magnitude *FloatImage
+	// If requested, the direction of the gradient in radians, from -pi to pi,
+	// at each edge pixel, and 0 elsewhere. The gradient is normal to the edge
+	// and points towards increasing values. The angle is measured from the x
+	// axis towards the y axis, which points down the image.
+	Orientation *FloatImage
+	// The absolute thresholds used.
+	Low, High float64
+}
+
+// SetGradient sets the Operator and Kernel of the options to the named
+// gradient: diag for sgrad.DefaultKernel, axis for sgrad.AxisKernel, sobel or
+// scharr.
+func (opts *CannyOptions) SetGradient(name string) error {
+	switch name {
+	case "diag":
+		opts.Operator, opts.Kernel = KernelOperator, DefaultKernel()
+	case "axis":
+		opts.Operator, opts.Kernel = KernelOperator, AxisKernel()
+	case "sobel":
+		opts.Operator, opts.Kernel = SobelOperator, SippGradKernel{}
+	case "scharr":
+		opts.Operator, opts.Kernel = ScharrOperator, SippGradKernel{}
+	default:
+		return fmt.Errorf("Unknown Canny gradient %q; use diag, axis, sobel "+
+			"or scharr", name)
+	}
+	return nil
+}
+
+// Canny detects the edges in the image with the given options. It returns an
+// error if the smoothing, the thresholds or the operator are invalid.
+func Canny(src SippImage, opts CannyOptions) (*SippEdges, error) {
+	if !(opts.Sigma >= 0) || math.IsInf(opts.Sigma, 1) {
+		return nil, errors.New("Canny smoothing must be finite and non-negative!")
+	}
+	switch opts.Mode {
+	case Auto:
+	case Absolute:
+		if opts.Low < 0 || opts.High < opts.Low {
+			return nil, errors.New("Canny thresholds must satisfy 0 <= low <= high!")
+		}
+	case Percentile:
+		if opts.Low < 0 || opts.High < opts.Low || opts.High > 100 {
+			return nil, errors.New("Canny percentiles must satisfy 0 <= low <= high <= 100!")
+		}
+	default:
+		return nil, errors.New("Unknown Canny threshold mode!")
+	}
+	smoothed := ToFloat(src).GaussianSmooth(opts.Sigma)
+	var grad *ComplexImage
+	frame := func(val complex128) (float64, float64) {
+		return real(val), imag(val)
+	}
+	switch opts.Operator {
+	case KernelOperator:
+		kern := opts.Kernel
+		if kern == (SippGradKernel{}) {
+			kern = DefaultKernel()
+		}
+		grad = FdgradKernel(smoothed, kern)
+		frame = kern.ImageFrame
+	case SobelOperator:
+		grad = centralGrad(smoothed, sobelCentre)
+	case ScharrOperator:
+		grad = centralGrad(smoothed, scharrCentre)
+	default:
+		return nil, errors.New("Unknown Canny gradient operator!")
+	}
+	width, height := grad.Rect.Dx(), grad.Rect.Dy()
+
+	res := &SippEdges{Magnitude: NewFloatImage(grad.Rect)}
+	angles := make([]float64, len(grad.Pix))
+	for i, val := range grad.Pix {
+		gx, gy := frame(val)
+		res.Magnitude.Pix[i] = math.Hypot(gx, gy)
+		angles[i] = math.Atan2(gy, gx)
+	}
+	res.Magnitude.SetScaling()
+	mag := res.Magnitude.Pix
+
+	switch opts.Mode {
+	case Auto:
+		var nonZero []float64
+		for _, m := range mag {
+			if m != 0 {
+				nonZero = append(nonZero, m)
+			}
+		}
+		if len(nonZero) > 0 {
+			res.High = percentile(nonZero, 100*autoNonEdgeFraction)
+		}
+		res.Low = autoLowRatio * res.High
+	case Absolute:
+		res.Low, res.High = opts.Low, opts.High
+	case Percentile:
+		res.Low, res.High = percentile(mag, opts.Low), percentile(mag, opts.High)
+	}
+
+	// Non-maximum suppression keeps the pixels whose magnitude is a maximum
+	// along the gradient direction, quantised to a multiple of 45 degrees.
+	// Pixels beyond the edges count as 0. A pixel must exceed one neighbour
+	// and equal or exceed the other, so that a plateau gives a thin edge.
+	at := func(x, y int) float64 {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return 0
+		}
+		return mag[y*width+x]
+	}
+	steps := [4][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}}
+	thin := make([]bool, len(mag))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			if mag[i] == 0 || mag[i] < res.Low {
+				continue
+			}
+			sector := int(math.Floor(angles[i]/(math.Pi/4)+0.5)) & 3
+			dx, dy := steps[sector][0], steps[sector][1]
+			if mag[i] > at(x+dx, y+dy) && mag[i] >= at(x-dx, y-dy) {
+				thin[i] = true
+			}
+		}
+	}
+
+	// Hysteresis follows 8-connected paths of thin pixels above the low
+	// threshold from each pixel above the high threshold.
+	res.Edges = new(SippGray)
+	res.Edges.Gray = image.NewGray(grad.Rect)
+	edges := res.Edges.Pix()
+	var stack []int
+	for i, ok := range thin {
+		if ok && mag[i] >= res.High && edges[i] == 0 {
+			edges[i] = 255
+			stack = append(stack, i)
+		}
+		for len(stack) > 0 {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := j%width, j/width
+			for ny := y - 1; ny <= y+1; ny++ {
+				for nx := x - 1; nx <= x+1; nx++ {
+					if nx < 0 || ny < 0 || nx >= width || ny >= height {
+						continue
+					}
+					k := ny*width + nx
+					if thin[k] && edges[k] == 0 {
+						edges[k] = 255
+						stack = append(stack, k)
+					}
+				}
+			}
+		}
+	}
+
+	if opts.Orientation {
+		res.Orientation = NewFloatImage(grad.Rect)
+		for i, edge := range edges {
+			if edge != 0 {
+				res.Orientation.Pix[i] = angles[i]
+			}
+		}
+		res.Orientation.Min, res.Orientation.Max = -math.Pi, math.Pi
+	}
+	return res, nil
+}
+
+// centralGrad applies a 3x3 operator at each pixel of the image: the central
+// difference along each axis, smoothed across it with weights 1, centre, 1.
+// The result is the same size as the image, with the gradient along x in the
+// real part and along y in the imaginary part, in grey levels per pixel.
+// Pixels beyond the edges repeat the nearest edge pixel.
+func centralGrad(src *FloatImage, centre float64) *ComplexImage {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	at := func(x, y int) float64 {
+		return src.Pix[clamp(y, height)*width+clamp(x, width)]
+	}
+	// Each difference spans two pixels, and the smoothing weights sum to
+	// centre+2.
+	scale := 1 / (2 * (centre + 2))
+	pix := make([]complex128, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gx := at(x+1, y-1) - at(x-1, y-1) +
+				centre*(at(x+1, y)-at(x-1, y)) +
+				at(x+1, y+1) - at(x-1, y+1)
+			gy := at(x-1, y+1) - at(x-1, y-1) +
+				centre*(at(x, y+1)-at(x, y-1)) +
+				at(x+1, y+1) - at(x+1, y-1)
+			pix[y*width+x] = complex(gx*scale, gy*scale)
+		}
+	}
+	return FromComplexArray(pix, width)
+}
+
+// clamp returns the nearest index from 0 to n-1.
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// percentile returns the value below which the given percentage of the values
+// lie, taking the nearest value rather than interpolating.
+func percentile(vals []float64, pct float64) float64 {
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+	i := int(math.Round(pct / 100 * float64(len(sorted)-1)))
+	return sorted[i]
+}
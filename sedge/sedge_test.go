@@ -0,0 +1,176 @@
+// Copyright Raul Vera 2021
+
+// Tests for package sedge.
+
+package sedge
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+import (
+	. "github.com/Causticity/sipp/sgrad"
+	. "github.com/Causticity/sipp/simage"
+	. "github.com/Causticity/sipp/sipptesting"
+)
+
+// step returns a size x size image that is 0 left of column edge and 200 from
+// it on.
+func step(size, edge int) *SippGray {
+	im := new(SippGray)
+	im.Gray = image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := edge; x < size; x++ {
+			im.Gray.Pix[y*size+x] = 200
+		}
+	}
+	return im
+}
+
+func TestCanny(t *testing.T) {
+	const size, edge = 10, 5
+	src := step(size, edge)
+	for _, opts := range []CannyOptions{
+		{Orientation: true},
+		{Kernel: AxisKernel(), Orientation: true},
+		{Sigma: 1, Orientation: true},
+		{Sigma: 1, Mode: Absolute, Low: 20, High: 40, Orientation: true},
+		{Mode: Percentile, Low: 50, High: 95, Orientation: true},
+	} {
+		res, err := Canny(src, opts)
+		if err != nil {
+			t.Fatalf("Error: options %+v failed: %v", opts, err)
+		}
+		if res.Edges.Bounds() != image.Rect(0, 0, size-1, size-1) {
+			t.Errorf("Error: edge image bounds %v", res.Edges.Bounds())
+		}
+		// The edge is a single column, between the source columns either
+		// side of the step, with the gradient pointing along x.
+		for y := 0; y < size-1; y++ {
+			for x := 0; x < size-1; x++ {
+				want := uint8(0)
+				if x == edge-1 {
+					want = 255
+				}
+				if got := res.Edges.Gray.Pix[y*(size-1)+x]; got != want {
+					t.Errorf("Error: options %+v edge at %d,%d is %d, expected %d",
+						opts, x, y, got, want)
+				}
+				if orient := res.Orientation.Val(x, y); math.Abs(orient) > 1e-12 {
+					t.Errorf("Error: options %+v orientation at %d,%d is %v",
+						opts, x, y, orient)
+				}
+			}
+		}
+		if res.High < res.Low {
+			t.Errorf("Error: options %+v thresholds %v, %v", opts, res.Low, res.High)
+		}
+	}
+
+	// The 3x3 operators give an edge image the size of the source, with the
+	// edge on the first column of the plateau of their central differences,
+	// which measure the step of 200 over two pixels.
+	for _, op := range []Operator{SobelOperator, ScharrOperator} {
+		res, err := Canny(src, CannyOptions{Operator: op, Orientation: true})
+		if err != nil {
+			t.Fatalf("Error: operator %v failed: %v", op, err)
+		}
+		if res.Edges.Bounds() != src.Bounds() {
+			t.Errorf("Error: operator %v edge image bounds %v", op, res.Edges.Bounds())
+		}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				want := uint8(0)
+				if x == edge {
+					want = 255
+				}
+				if got := res.Edges.Gray.Pix[y*size+x]; got != want {
+					t.Errorf("Error: operator %v edge at %d,%d is %d, expected %d",
+						op, x, y, got, want)
+				}
+				if orient := res.Orientation.Val(x, y); math.Abs(orient) > 1e-12 {
+					t.Errorf("Error: operator %v orientation at %d,%d is %v",
+						op, x, y, orient)
+				}
+			}
+			if mag := res.Magnitude.Val(edge, y); math.Abs(mag-100) > 1e-12 {
+				t.Errorf("Error: operator %v magnitude at %d,%d is %v, expected 100",
+					op, edge, y, mag)
+			}
+		}
+	}
+
+	// A pixel above the low threshold survives only if it is connected to
+	// one above the high threshold.
+	res, _ := Canny(src, CannyOptions{Mode: Absolute, Low: 100, High: 300})
+	for _, val := range res.Edges.Pix() {
+		if val != 0 {
+			t.Fatal("Error: edges found with an unreachable high threshold")
+		}
+	}
+	if res.Orientation != nil {
+		t.Error("Error: orientation computed when not requested")
+	}
+
+	// The automatic thresholds follow the gradient magnitudes.
+	res, _ = Canny(SgrayCosxCosyTiny, CannyOptions{Sigma: 1})
+	if res.High <= 0 || res.Low != autoLowRatio*res.High {
+		t.Errorf("Error: automatic thresholds %v, %v", res.Low, res.High)
+	}
+
+	// On a mostly flat image, the automatic thresholds come from the non-zero
+	// magnitudes, so a faint bump isn't an edge.
+	flat := step(20, 15)
+	flat.Gray.Pix[3*20+3] = 1
+	res, _ = Canny(flat, CannyOptions{})
+	if res.High <= 0 {
+		t.Errorf("Error: automatic high threshold %v on a mostly flat image", res.High)
+	}
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 6; x++ {
+			if res.Edges.Gray.Pix[y*19+x] != 0 {
+				t.Errorf("Error: faint bump gives an edge at %d,%d", x, y)
+			}
+		}
+	}
+
+	for _, opts := range []CannyOptions{
+		{Sigma: -1},
+		{Sigma: math.NaN()},
+		{Sigma: math.Inf(1)},
+		{Mode: Absolute, Low: 10, High: 5},
+		{Mode: Percentile, Low: 10, High: 101},
+		{Mode: ThresholdMode(7)},
+		{Operator: Operator(7)},
+	} {
+		if _, err := Canny(src, opts); err == nil {
+			t.Errorf("Error: options %+v didn't fail", opts)
+		}
+	}
+
+	for _, test := range []struct {
+		name string
+		op   Operator
+		kern SippGradKernel
+	}{
+		{"diag", KernelOperator, DefaultKernel()},
+		{"axis", KernelOperator, AxisKernel()},
+		{"sobel", SobelOperator, SippGradKernel{}},
+		{"scharr", ScharrOperator, SippGradKernel{}},
+	} {
+		opts := CannyOptions{Kernel: AxisKernel()}
+		if err := opts.SetGradient(test.name); err != nil {
+			t.Errorf("Error: gradient %s failed: %v", test.name, err)
+		}
+		if opts.Operator != test.op || opts.Kernel != test.kern {
+			t.Errorf("Error: gradient %s gave operator %v, kernel %v",
+				test.name, opts.Operator, opts.Kernel)
+		}
+	}
+	var opts CannyOptions
+	if err := opts.SetGradient("prewitt"); err == nil {
+		t.Error("Error: unknown gradient didn't fail")
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright Raul Vera 2015-2021
+
+package sentropy
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	. "github.com/Causticity/sipp/shist"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// BootstrapOptions control the resampling done by BootstrapEntropy and
+// BootstrapDelentropy.
+type BootstrapOptions struct {
+	// Iterations is the number of bootstrap resamples, at least 2.
+	Iterations int
+	// BlockSize selects the resampling method. If it is 0 or 1, individual
+	// pixels are resampled independently. Otherwise, square blocks of pixels
+	// this many on a side are resampled from anywhere in the image (a moving
+	// block bootstrap), which preserves the correlation between neighbouring
+	// pixels. Use a block size at least as large as the typical correlation
+	// length in the image.
+	BlockSize int
+	// Seed is the seed for the random numbers used, so that the results are
+	// reproducible.
+	Seed int64
+	// Level is the confidence level of the percentile interval, strictly
+	// between 0 and 1, e.g. 0.95.
+	Level float64
+}
+
+// Validate returns an error if the options are invalid for resampling an
+// image of the given size. BootstrapEntropy resamples the image itself and
+// BootstrapDelentropy its gradient, which is one pixel smaller in each
+// direction.
+func (opts BootstrapOptions) Validate(width, height int) error {
+	if opts.Iterations < 2 {
+		return errors.New("Bootstrap needs at least 2 iterations!")
+	}
+	if !(opts.Level > 0 && opts.Level < 1) {
+		return errors.New("Bootstrap confidence level must be between 0 and 1!")
+	}
+	if opts.BlockSize > width || opts.BlockSize > height {
+		return errors.New("Bootstrap block size is larger than the image!")
+	}
+	return nil
+}
+
+// DefaultBootstrapOptions returns a pixel bootstrap with 1000 iterations and a
+// 95% interval.
+func DefaultBootstrapOptions() BootstrapOptions {
+	return BootstrapOptions{Iterations: 1000, Level: 0.95}
+}
+
+// A BootstrapResult holds the statistics of the bootstrap replicates of an
+// entropy value, all in bits.
+type BootstrapResult struct {
+	// The value computed from the original, unresampled pixels.
+	Estimate float64
+	// The mean and standard deviation of the replicates. The latter is the
+	// bootstrap estimate of the standard error of Estimate.
+	Mean, StdErr float64
+	// The percentile confidence interval at the requested level.
+	Lower, Upper float64
+	// The replicates themselves, sorted in increasing order.
+	Replicates []float64
+}
+
+// BootstrapEntropy resamples the pixels of the image to estimate the
+// uncertainty of its conventional entropy.
+func BootstrapEntropy(im SippImage, opts BootstrapOptions) *BootstrapResult {
+	rect := im.Bounds()
+	width, height := rect.Dx(), rect.Dy()
+	ids := make([]int, 0, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			ids = append(ids, int(im.IntVal(x, y)))
+		}
+	}
+	numIds := GreyHistSize8BPP
+	if im.Bpp() == 16 {
+		numIds = GreyHistSize16BPP
+	}
+	return bootstrap(ids, width, height, numIds, opts)
+}
+
+// BootstrapDelentropy resamples the pixels of the gradient image to estimate
+// the uncertainty of its delentropy. The gradient is binned in the same way as
+// by shist.Hist, so Estimate is the same as the Delentropy of its histogram.
+func BootstrapDelentropy(grad SippComplexImage, opts BootstrapOptions) *BootstrapResult {
+	// Number the occupied bins densely, so that each resample can be counted
+	// in a slice.
+	pts := gradPoints(grad)
+	ids := make([]int, len(pts))
+	binIds := make(map[[2]int]int)
+	for i, pt := range pts {
+		bin := [2]int{int(math.Floor(pt[0])), int(math.Floor(pt[1]))}
+		id, ok := binIds[bin]
+		if !ok {
+			id = len(binIds)
+			binIds[bin] = id
+		}
+		ids[i] = id
+	}
+	rect := grad.Bounds()
+	return bootstrap(ids, rect.Dx(), rect.Dy(), len(binIds), opts)
+}
+
+// bootstrap resamples an image of bin ids, each in [0, numIds), computing the
+// entropy of the histogram of each resample.
+func bootstrap(ids []int, width, height, numIds int, opts BootstrapOptions) *BootstrapResult {
+	if err := opts.Validate(width, height); err != nil {
+		panic(err.Error())
+	}
+	block := opts.BlockSize
+	if block < 1 {
+		block = 1
+	}
+	res := new(BootstrapResult)
+	counts := make([]uint32, numIds)
+	for _, id := range ids {
+		counts[id]++
+	}
+	res.Estimate = countsEntropy(counts, float64(len(ids)))
+
+	// Enough blocks are drawn to cover the image. The top-left corner of each
+	// is chosen uniformly from all the positions where it fits.
+	blocksAcross := (width + block - 1) / block
+	blocksDown := (height + block - 1) / block
+	numBlocks := blocksAcross * blocksDown
+	xPositions := width - block + 1
+	yPositions := height - block + 1
+	total := numBlocks * block * block
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	res.Replicates = make([]float64, opts.Iterations)
+	for iter := range res.Replicates {
+		for i := range counts {
+			counts[i] = 0
+		}
+		for b := 0; b < numBlocks; b++ {
+			x0 := rnd.Intn(xPositions)
+			y0 := rnd.Intn(yPositions)
+			for y := y0; y < y0+block; y++ {
+				for _, id := range ids[y*width+x0 : y*width+x0+block] {
+					counts[id]++
+				}
+			}
+		}
+		res.Replicates[iter] = countsEntropy(counts, float64(total))
+	}
+
+	var sum float64
+	for _, r := range res.Replicates {
+		sum += r
+	}
+	res.Mean = sum / float64(len(res.Replicates))
+	var sumSq float64
+	for _, r := range res.Replicates {
+		sumSq += (r - res.Mean) * (r - res.Mean)
+	}
+	res.StdErr = math.Sqrt(sumSq / float64(len(res.Replicates)-1))
+	sort.Float64s(res.Replicates)
+	tail := (1 - opts.Level) / 2
+	res.Lower = quantile(res.Replicates, tail)
+	res.Upper = quantile(res.Replicates, 1-tail)
+	return res
+}
+
+// countsEntropy returns the Shannon entropy in bits of a histogram with the
+// given total.
+func countsEntropy(counts []uint32, total float64) (ent float64) {
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / total
+			ent -= p * math.Log2(p)
+		}
+	}
+	return
+}
+
+// quantile returns the q quantile of the sorted values, interpolating linearly
+// between the closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	if lo >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(lo)
+	return sorted[lo] + frac*(sorted[lo+1]-sorted[lo])
+}
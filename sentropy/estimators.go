@@ -0,0 +1,365 @@
+// Copyright Raul Vera 2015-2021
+
+package sentropy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	. "github.com/Causticity/sipp/shist"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// An Estimator estimates the delentropy, in bits, of the gradient from which
+// the given histogram was computed. Different estimators make different
+// assumptions about the underlying distribution of gradient values, so they
+// can be compared on the same gradient to assess how sensitive a result is to
+// binning. An estimator returns an error if its options are invalid or it
+// can't produce an estimate from the given histogram. As for Delentropy, an
+// empty histogram, such as that of a fully masked image, has delentropy 0.
+type Estimator interface {
+	Delentropy(hist SippHist) (float64, error)
+}
+
+// PlugInEstimator is the conventional estimator, computing the entropy
+// directly from the relative frequencies of the histogram bins. It gives the
+// same value as the Delentropy field of a SippDelentropy.
+type PlugInEstimator struct{}
+
+func (est PlugInEstimator) Delentropy(hist SippHist) (float64, error) {
+	return shannon(hist.Bins(), histTotal(hist), math.Log2), nil
+}
+
+// MillerMadowEstimator adds the Miller-Madow bias correction, (m-1)/2N nats,
+// to the plug-in estimate, where m is the number of occupied bins and N is the
+// number of pixels. The plug-in estimator is biased low, particularly when
+// the number of occupied bins is not small compared to the number of pixels.
+type MillerMadowEstimator struct{}
+
+func (est MillerMadowEstimator) Delentropy(hist SippHist) (float64, error) {
+	bins := hist.Bins()
+	total := histTotal(hist)
+	if total == 0 {
+		return 0, nil
+	}
+	var occupied uint32
+	for _, bin := range bins {
+		occupied += bin.Num
+	}
+	correction := float64(occupied-1) / (2.0 * total) / math.Ln2
+	return shannon(bins, total, math.Log2) + correction, nil
+}
+
+// KernelEstimator computes the plug-in entropy of the histogram after
+// smoothing it with a Gaussian kernel, which is a kernel-density estimate of
+// the gradient distribution evaluated at the bin centres. Sigma is the
+// standard deviation of the kernel in bins; a Sigma of 0 applies no smoothing.
+//
+// Smoothing spreads each occupied bin over the support of the kernel, so the
+// smoothed histogram can have many more bins than the original. An error is
+// returned if it could have more than maxSmoothedBins.
+type KernelEstimator struct {
+	Sigma float64
+}
+
+// maxSmoothedBins is the largest number of bins that KernelEstimator will
+// smooth a histogram into, about 200MB of sparse histogram.
+const maxSmoothedBins = 1 << 22
+
+func (est KernelEstimator) Delentropy(hist SippHist) (float64, error) {
+	if !(est.Sigma >= 0) || math.IsInf(est.Sigma, 1) {
+		return 0, errors.New("Kernel standard deviation must be finite and non-negative!")
+	}
+	// Rebin the gradient exactly as the flat histogram does, but sparsely,
+	// so that gradients of any excursion can be smoothed.
+	counts := make(map[[2]int]float64)
+	lo := [2]int{math.MaxInt32, math.MaxInt32}
+	hi := [2]int{math.MinInt32, math.MinInt32}
+	for _, pt := range histPoints(hist) {
+		bin := [2]int{int(math.Floor(pt[0])), int(math.Floor(pt[1]))}
+		counts[bin]++
+		for axis := range bin {
+			if bin[axis] < lo[axis] {
+				lo[axis] = bin[axis]
+			}
+			if bin[axis] > hi[axis] {
+				hi[axis] = bin[axis]
+			}
+		}
+	}
+	if est.Sigma > 0 && len(counts) > 0 {
+		kern := GaussianKernel(est.Sigma)
+		// The smoothed bins lie within the kernel's support of an occupied
+		// bin, and within its radius of the bounding box of the occupied
+		// bins.
+		side := float64(len(kern))
+		spread := float64(len(counts)) * side * side
+		radius := len(kern) / 2
+		box := float64(hi[0]-lo[0]+1+2*radius) * float64(hi[1]-lo[1]+1+2*radius)
+		if math.Min(spread, box) > maxSmoothedBins {
+			return 0, fmt.Errorf("Kernel standard deviation %v spreads the "+
+				"histogram over too many bins", est.Sigma)
+		}
+		counts = smoothAxis(counts, kern, 0)
+		counts = smoothAxis(counts, kern, 1)
+	}
+	var total float64
+	for _, c := range counts {
+		total += c
+	}
+	var ent float64
+	for _, c := range counts {
+		if c > 0 {
+			p := c / total
+			ent -= p * math.Log2(p)
+		}
+	}
+	return ent, nil
+}
+
+// smoothAxis convolves the sparse 2D histogram with the 1D kernel along the
+// given axis, 0 for real and 1 for imaginary, returning a new histogram.
+func smoothAxis(counts map[[2]int]float64, kern []float64, axis int) map[[2]int]float64 {
+	radius := len(kern) / 2
+	smoothed := make(map[[2]int]float64, len(counts)*len(kern))
+	for bin, c := range counts {
+		for i, w := range kern {
+			dst := bin
+			dst[axis] += i - radius
+			smoothed[dst] += c * w
+		}
+	}
+	return smoothed
+}
+
+// KNNEstimator is the Kozachenko-Leonenko k-nearest-neighbour estimator of
+// the differential entropy of the gradient, computed directly from the
+// gradient pixel values without any binning. K is the rank of the neighbour
+// used, and must be at least 1.
+//
+// Gradients of integer images take integer values, so many pixels coincide
+// and have neighbour distances of 0. If Dequantise is true, uniform noise in
+// [0, 1) is added to each component first, spreading each pixel over the same
+// unit square that it is binned into by the histogram, so that the estimate is
+// directly comparable with the plug-in estimate. The noise is generated from
+// Seed, so the results are reproducible. If Dequantise is false, an error is
+// returned if any pixel's Kth neighbour coincides with it, as the estimate
+// takes the log of the distance.
+type KNNEstimator struct {
+	K          int
+	Dequantise bool
+	Seed       int64
+}
+
+// unitDiskArea is the volume of the unit ball in the two dimensions of the
+// complex plane.
+const unitDiskArea = math.Pi
+
+func (est KNNEstimator) Delentropy(hist SippHist) (float64, error) {
+	if est.K < 1 {
+		return 0, errors.New("KNN estimator K must be at least 1!")
+	}
+	pts := histPoints(hist)
+	if len(pts) == 0 {
+		return 0, nil
+	}
+	if len(pts) <= est.K {
+		return 0, fmt.Errorf("KNN estimator needs more than K = %d pixels, "+
+			"but has %d", est.K, len(pts))
+	}
+	if est.Dequantise {
+		rnd := rand.New(rand.NewSource(est.Seed))
+		for i := range pts {
+			pts[i][0] += rnd.Float64()
+			pts[i][1] += rnd.Float64()
+		}
+	}
+	tree := newKDTree(pts)
+	var sumLogDist float64
+	for i := range pts {
+		distSq := tree.kthNeighbourDistSq(i, est.K)
+		if distSq == 0 {
+			return 0, errors.New("KNN estimator found coincident gradient " +
+				"values; dequantise them first")
+		}
+		// log of the distance is half the log of its square
+		sumLogDist += 0.5 * math.Log(distSq)
+	}
+	// H = psi(N) - psi(K) + log(V) + (d/N) sum(log(dist)), with d = 2
+	n := len(pts)
+	ent := digamma(n) - digamma(est.K) + math.Log(unitDiskArea) +
+		2.0*sumLogDist/float64(n)
+	return ent / math.Ln2, nil
+}
+
+// digamma returns the digamma function of the positive integer n, which is
+// the harmonic number H(n-1) less the Euler-Mascheroni constant.
+func digamma(n int) float64 {
+	const eulerGamma = 0.57721566490153286061
+	var sum float64
+	for j := n - 1; j >= 1; j-- {
+		sum += 1.0 / float64(j)
+	}
+	return sum - eulerGamma
+}
+
+// gradPoints returns the gradient pixel values as points in the plane. A new
+// slice is always returned, so it can be modified freely.
+func gradPoints(grad SippComplexImage) [][2]float64 {
+	vals := grad.Values()
+	pts := make([][2]float64, len(vals))
+	for i, val := range vals {
+		pts[i] = [2]float64{real(val), imag(val)}
+	}
+	return pts
+}
+
+// histPoints returns the points for the gradient pixels counted in the
+// histogram, omitting any excluded by a mask.
+func histPoints(hist SippHist) [][2]float64 {
+	pts := gradPoints(hist.Grad())
+	if hist.Total() == len(pts) {
+		return pts
+	}
+	width := hist.Grad().Bounds().Dx()
+	included := pts[:0]
+	for i, pt := range pts {
+		if hist.BinForPixel(i%width, i/width) >= 0 {
+			included = append(included, pt)
+		}
+	}
+	return included
+}
+
+// A kdTree is a 2D tree of points for nearest-neighbour searches. It is stored
+// implicitly in a permutation of the point indices: each subtree occupies a
+// contiguous range whose median element is the splitting point, with the
+// splitting axis alternating between levels.
+type kdTree struct {
+	pts [][2]float64
+	idx []int
+}
+
+// Subtrees no larger than this are searched exhaustively.
+const kdLeafSize = 8
+
+func newKDTree(pts [][2]float64) *kdTree {
+	tree := &kdTree{pts, make([]int, len(pts))}
+	for i := range tree.idx {
+		tree.idx[i] = i
+	}
+	tree.build(0, len(pts), 0)
+	return tree
+}
+
+func (tree *kdTree) build(lo, hi, axis int) {
+	if hi-lo <= kdLeafSize {
+		return
+	}
+	mid := (lo + hi) / 2
+	tree.selectNth(lo, hi, mid, axis)
+	tree.build(lo, mid, 1-axis)
+	tree.build(mid+1, hi, 1-axis)
+}
+
+// selectNth partially orders idx[lo:hi] so that the element at n is the one
+// that would be there if the range were sorted on the given axis, with no
+// larger elements before it and no smaller ones after it.
+func (tree *kdTree) selectNth(lo, hi, n, axis int) {
+	idx := tree.idx
+	for hi-lo > 1 {
+		// Partition around the median of three to avoid quadratic behaviour
+		// on already ordered input.
+		mid := (lo + hi) / 2
+		a, b, c := tree.pts[idx[lo]][axis], tree.pts[idx[mid]][axis], tree.pts[idx[hi-1]][axis]
+		pivot := math.Max(math.Min(a, b), math.Min(math.Max(a, b), c))
+		i, j := lo, hi-1
+		for i <= j {
+			for tree.pts[idx[i]][axis] < pivot {
+				i++
+			}
+			for tree.pts[idx[j]][axis] > pivot {
+				j--
+			}
+			if i <= j {
+				idx[i], idx[j] = idx[j], idx[i]
+				i++
+				j--
+			}
+		}
+		if n <= j {
+			hi = j + 1
+		} else if n >= i {
+			lo = i
+		} else {
+			return
+		}
+	}
+}
+
+// kthNeighbourDistSq returns the squared distance from point i to its kth
+// nearest other point.
+func (tree *kdTree) kthNeighbourDistSq(i, k int) float64 {
+	best := make([]float64, 0, k)
+	tree.search(0, len(tree.idx), 0, i, &best, k)
+	return best[k-1]
+}
+
+// search updates best, the sorted squared distances of the nearest k points to
+// point self found so far, from the subtree occupying idx[lo:hi].
+func (tree *kdTree) search(lo, hi, axis, self int, best *[]float64, k int) {
+	q := tree.pts[self]
+	if hi-lo <= kdLeafSize {
+		for _, j := range tree.idx[lo:hi] {
+			if j != self {
+				insertBest(best, k, distSq(q, tree.pts[j]))
+			}
+		}
+		return
+	}
+	mid := (lo + hi) / 2
+	j := tree.idx[mid]
+	if j != self {
+		insertBest(best, k, distSq(q, tree.pts[j]))
+	}
+	d := q[axis] - tree.pts[j][axis]
+	nearLo, nearHi, farLo, farHi := lo, mid, mid+1, hi
+	if d > 0 {
+		nearLo, nearHi, farLo, farHi = mid+1, hi, lo, mid
+	}
+	tree.search(nearLo, nearHi, 1-axis, self, best, k)
+	if len(*best) < k || d*d <= (*best)[k-1] {
+		tree.search(farLo, farHi, 1-axis, self, best, k)
+	}
+}
+
+// insertBest inserts a squared distance into the sorted slice of the k best,
+// discarding the largest if the slice is already full.
+func insertBest(best *[]float64, k int, dsq float64) {
+	b := *best
+	if len(b) == k {
+		if dsq >= b[k-1] {
+			return
+		}
+		b = b[:k-1]
+	}
+	pos := len(b)
+	b = append(b, dsq)
+	for pos > 0 && b[pos-1] > dsq {
+		b[pos] = b[pos-1]
+		pos--
+	}
+	b[pos] = dsq
+	*best = b
+}
+
+func distSq(a, b [2]float64) float64 {
+	dx := a[0] - b[0]
+	dy := a[1] - b[1]
+	return dx*dx + dy*dy
+}
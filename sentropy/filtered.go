@@ -0,0 +1,121 @@
+// Copyright Raul Vera 2015-2021
+
+package sentropy
+
+import (
+	"image"
+	"math"
+	"math/cmplx"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	. "github.com/Causticity/sipp/shist"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// DelentropyOptions select which gradient values contribute to a filtered
+// delentropy, and how much. The zero value includes everything, giving the
+// same value as Delentropy.
+//
+// In images with flat backgrounds, the bin at the centre of the histogram,
+// for zero gradient, dominates the delentropy, which then mostly measures how
+// much of the image is flat rather than the texture of the rest.
+type DelentropyOptions struct {
+	// If ExcludeZero is true, pixels whose gradient falls in the central
+	// histogram bin are excluded.
+	ExcludeZero bool
+	// Pixels whose gradient modulus is less than NoiseFloor are excluded.
+	NoiseFloor float64
+	// If RadialWeight is true, the count in each bin is weighted by the
+	// distance of the bin from the centre of the histogram before the
+	// probabilities are computed, as when rendering a suppressed histogram.
+	// This down-weights small gradients smoothly and excludes the central bin
+	// entirely.
+	RadialWeight bool
+	// If Mask is not nil, only the gradient pixels it selects are included, as
+	// for shist.HistMasked. Pixels it excludes are not counted in Excluded.
+	Mask SippImage
+}
+
+// SippFilteredDelentropy holds the result of FilteredDelentropy.
+type SippFilteredDelentropy struct {
+	// The delentropy, in bits and without a pair factor, of the included
+	// pixels.
+	Delentropy float64
+	// The histogram of the included pixels. Without RadialWeight, Delentropy
+	// is its delentropy, so it can be passed to anything that takes a
+	// SippHist, such as the generalised delentropies and the delentropy
+	// images, for values consistent with Delentropy.
+	Hist SippHist
+	// The number of gradient pixels included and excluded.
+	Included, Excluded int
+}
+
+// FilteredDelentropy computes the delentropy of the given gradient image,
+// binned in the same way as by shist.Hist, using only the gradient values
+// selected by the options. The probabilities are those of the included
+// pixels, so they still sum to 1. If no pixels are included, or if all the
+// included pixels have zero weight, the delentropy is 0.
+//
+// The excluded pixels are masked out of the histogram, as by shist.HistMasked.
+// RadialWeight gives the central bin a weight of 0, so its pixels are
+// excluded too, but the weights of the other bins can't be expressed as
+// counts, so the histogram is unweighted.
+func FilteredDelentropy(grad SippComplexImage, opts DelentropyOptions) *SippFilteredDelentropy {
+	if opts.NoiseFloor < 0 || math.IsNaN(opts.NoiseFloor) {
+		panic("Delentropy noise floor must be non-negative!")
+	}
+	res := new(SippFilteredDelentropy)
+	rect := grad.Bounds()
+	gmask := new(SippGray)
+	gmask.Gray = image.NewGray(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	gmaskPix := gmask.Pix()
+	included := MaskIncluded(opts.Mask, rect.Size())
+	for i, pt := range grad.Values() {
+		if included != nil && !included[i] {
+			continue
+		}
+		if ((opts.ExcludeZero || opts.RadialWeight) && binOf(pt) == [2]int{0, 0}) ||
+			cmplx.Abs(pt) < opts.NoiseFloor {
+			res.Excluded++
+			continue
+		}
+		res.Included++
+		gmaskPix[i] = 1
+	}
+	res.Hist = HistMasked(grad, gmask)
+	if !opts.RadialWeight {
+		res.Delentropy = Delentropy(res.Hist).Delentropy
+		return res
+	}
+
+	counts := make(map[[2]int]float64)
+	for i, pt := range grad.Values() {
+		if gmaskPix[i] != 0 {
+			counts[binOf(pt)]++
+		}
+	}
+	var total float64
+	for bin, count := range counts {
+		count *= math.Hypot(float64(bin[0]), float64(bin[1]))
+		counts[bin] = count
+		total += count
+	}
+	if total == 0 {
+		return res
+	}
+	for _, count := range counts {
+		if count > 0 {
+			p := count / total
+			res.Delentropy -= p * math.Log2(p)
+		}
+	}
+	return res
+}
+
+// binOf returns the position, relative to the centre, of the histogram bin of
+// the given gradient value, as binned by shist.Hist.
+func binOf(pt complex128) [2]int {
+	return [2]int{int(math.Floor(real(pt))), int(math.Floor(imag(pt)))}
+}
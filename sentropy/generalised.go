@@ -0,0 +1,127 @@
+// Copyright Raul Vera 2015-2021
+
+package sentropy
+
+import (
+	"math"
+)
+
+import (
+	. "github.com/Causticity/sipp/shist"
+)
+
+// Generalised entropies of order alpha (Rényi) or q (Tsallis). Both reduce to
+// the Shannon entropy at order 1. Orders below 1 weight rare values more
+// heavily, and orders above 1 weight common values more heavily.
+//
+// The conventional entropy versions are computed from the 1D histogram returned
+// by shist.GreyHist, and the delentropy versions from the compact slice of
+// BinPairs returned by SippHist.Bins, exactly as Entropy and Delentropy do.
+// As for those, an empty histogram, such as that of a fully masked image, has
+// entropy 0 at every order.
+
+// RenyiEntropy returns the Rényi entropy of order alpha, in bits, of the given
+// 1D histogram. The order must be non-negative; it may be +Inf, giving the
+// min-entropy. Order 0 gives the Hartley entropy, the log of the number of
+// values that occur, and order 1 gives the Shannon entropy.
+func RenyiEntropy(hist []uint32, alpha float64) float64 {
+	bins, total := greyBins(hist)
+	return renyi(bins, total, alpha)
+}
+
+// TsallisEntropy returns the Tsallis entropy of order q of the given 1D
+// histogram. Tsallis entropy is dimensionless; in the limit as q approaches 1
+// it is the Shannon entropy in nats, which is what is returned for q = 1.
+func TsallisEntropy(hist []uint32, q float64) float64 {
+	bins, total := greyBins(hist)
+	return tsallis(bins, total, q)
+}
+
+// RenyiDelentropy returns the Rényi delentropy of order alpha, in bits, of the
+// given gradient histogram. See RenyiEntropy for the permitted orders. Order 1
+// gives the same value as the Delentropy field of a SippDelentropy.
+func RenyiDelentropy(hist SippHist, alpha float64) float64 {
+	return renyi(hist.Bins(), histTotal(hist), alpha)
+}
+
+// TsallisDelentropy returns the Tsallis delentropy of order q of the given
+// gradient histogram. See TsallisEntropy. Unlike the other delentropies, it
+// is not meant to be scaled by a pair factor: Tsallis entropy is not
+// additive, so the entropy of the pair of gradient components doesn't split
+// into a sum of their entropies.
+func TsallisDelentropy(hist SippHist, q float64) float64 {
+	return tsallis(hist.Bins(), histTotal(hist), q)
+}
+
+// greyBins converts a 1D histogram into the same compact form as returned by
+// SippHist.Bins, where each occurring bin value is paired with the number of
+// times it occurs, and also returns the total of all the bins. As the order of
+// the pairs doesn't matter for the sums below, each non-zero bin simply gets
+// its own pair.
+func greyBins(hist []uint32) (bins []BinPair, total float64) {
+	for _, val := range hist {
+		if val != 0 {
+			bins = append(bins, BinPair{val, 1})
+			total += float64(val)
+		}
+	}
+	return
+}
+
+// histTotal returns the total of all the bins of a gradient histogram, which
+// is the number of gradient pixels counted.
+func histTotal(hist SippHist) float64 {
+	return float64(hist.Total())
+}
+
+// shannon returns the Shannon entropy of the given bins using the given log
+// function, which determines the units.
+func shannon(bins []BinPair, total float64, log func(float64) float64) (ent float64) {
+	for _, bin := range bins {
+		p := float64(bin.BinVal) / total
+		ent -= p * log(p) * float64(bin.Num)
+	}
+	return
+}
+
+// powerSum returns the sum of p^order over all the occurring bins, where p is
+// the probability of each bin.
+func powerSum(bins []BinPair, total, order float64) (sum float64) {
+	for _, bin := range bins {
+		sum += math.Pow(float64(bin.BinVal)/total, order) * float64(bin.Num)
+	}
+	return
+}
+
+func renyi(bins []BinPair, total, alpha float64) float64 {
+	switch {
+	case alpha < 0 || math.IsNaN(alpha):
+		panic("Rényi entropy order must be non-negative!")
+	case total == 0:
+		return 0
+	case alpha == 1:
+		return shannon(bins, total, math.Log2)
+	case math.IsInf(alpha, 1):
+		var max uint32
+		for _, bin := range bins {
+			if bin.BinVal > max {
+				max = bin.BinVal
+			}
+		}
+		return -math.Log2(float64(max) / total)
+	}
+	return math.Log2(powerSum(bins, total, alpha)) / (1 - alpha)
+}
+
+func tsallis(bins []BinPair, total, q float64) float64 {
+	if math.IsNaN(q) || math.IsInf(q, 0) {
+		panic("Tsallis entropy order must be finite!")
+	}
+	if total == 0 {
+		return 0
+	}
+	if q == 1 {
+		return shannon(bins, total, math.Log)
+	}
+	return (1 - powerSum(bins, total, q)) / (q - 1)
+}
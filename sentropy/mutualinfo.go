@@ -0,0 +1,55 @@
+// Copyright Raul Vera 2015-2021
+
+package sentropy
+
+import (
+	. "github.com/Causticity/sipp/shist"
+)
+
+// SippMutualInfo holds the information-theoretic measures of the relationship
+// between two images A and B, computed from their joint histogram. All values
+// are in bits, apart from the normalised ones, which are ratios.
+type SippMutualInfo struct {
+	// A reference to the joint histogram.
+	Hist *SippJointHist
+	// The entropies of the binned values of each image alone.
+	EntropyA, EntropyB float64
+	// The joint entropy H(A,B).
+	JointEntropy float64
+	// The conditional entropies H(A|B) and H(B|A), the information remaining
+	// in one image once the other is known.
+	CondEntropyAB, CondEntropyBA float64
+	// The mutual information I(A;B) = H(A) + H(B) - H(A,B).
+	MutualInfo float64
+	// The normalised mutual information (H(A) + H(B)) / H(A,B), which ranges
+	// from 1 for independent images to 2 for images that determine each
+	// other. It is 1 if the joint entropy is 0.
+	NormMutualInfo float64
+	// The symmetric uncertainty 2 I(A;B) / (H(A) + H(B)), which ranges from
+	// 0 for independent images to 1 for images that determine each other. It
+	// is 0 if both images are constant.
+	SymUncertainty float64
+}
+
+// MutualInformation computes the joint, conditional and mutual information
+// measures of two images from their joint histogram.
+func MutualInformation(hist *SippJointHist) (mi *SippMutualInfo) {
+	mi = new(SippMutualInfo)
+	mi.Hist = hist
+	total := float64(hist.Total)
+	mi.JointEntropy = countsEntropy(hist.Bin, total)
+	histA, histB := hist.Marginals()
+	mi.EntropyA = countsEntropy(histA, total)
+	mi.EntropyB = countsEntropy(histB, total)
+	mi.CondEntropyAB = mi.JointEntropy - mi.EntropyB
+	mi.CondEntropyBA = mi.JointEntropy - mi.EntropyA
+	mi.MutualInfo = mi.EntropyA + mi.EntropyB - mi.JointEntropy
+	mi.NormMutualInfo = 1
+	if mi.JointEntropy > 0 {
+		mi.NormMutualInfo = (mi.EntropyA + mi.EntropyB) / mi.JointEntropy
+	}
+	if mi.EntropyA+mi.EntropyB > 0 {
+		mi.SymUncertainty = 2 * mi.MutualInfo / (mi.EntropyA + mi.EntropyB)
+	}
+	return
+}
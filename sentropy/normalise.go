@@ -0,0 +1,205 @@
+// Copyright Raul Vera 2015-2021
+
+package sentropy
+
+import (
+	"fmt"
+	"math"
+)
+
+import (
+	. "github.com/Causticity/sipp/shist"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// The entropy values computed by this package are all in bits and, for
+// delentropy, without any factor for the pair of gradient components at each
+// pixel. A Normalisation converts such a raw value to the value to report.
+//
+// Normalisation is a scaling, so it can be applied equally to Rényi entropies
+// and to the statistics of bootstrap replicates. Tsallis entropies are neither
+// logarithmic nor additive, so neither the unit nor the pair factor, which
+// splits the entropy of a pair of components additively, applies to them.
+
+// A Unit is a unit of information, determined by the base of the logarithm.
+type Unit int
+
+const (
+	// Bits, using log base 2.
+	Bits Unit = iota
+	// Nats, using the natural log.
+	Nats
+	// Dits, also called bans or hartleys, using log base 10.
+	Dits
+)
+
+var unitNames = []string{"bits", "nats", "dits"}
+
+func (u Unit) String() string {
+	if u < 0 || int(u) >= len(unitNames) {
+		return fmt.Sprintf("Unit(%d)", int(u))
+	}
+	return unitNames[u]
+}
+
+// ParseUnit returns the Unit with the given name, as returned by String.
+func ParseUnit(name string) (Unit, error) {
+	for i, unitName := range unitNames {
+		if name == unitName {
+			return Unit(i), nil
+		}
+	}
+	return Bits, fmt.Errorf("Unknown entropy unit %q; use bits, nats or dits", name)
+}
+
+// perBit returns the number of these units in one bit.
+func (u Unit) perBit() float64 {
+	switch u {
+	case Bits:
+		return 1
+	case Nats:
+		return math.Ln2
+	case Dits:
+		return math.Log10(2)
+	}
+	panic("Unknown entropy unit!")
+}
+
+// LarkinPairFactor is the pair factor used by Larkin's original definition of
+// delentropy, which halves the entropy of the gradient histogram because each
+// gradient value is a pair of components, each a difference of pixel values.
+const LarkinPairFactor = 0.5
+
+// A Normalisation specifies how raw entropy values are reported.
+type Normalisation struct {
+	// Unit is the unit of the reported values. It is ignored if Relative is
+	// true.
+	Unit Unit
+	// PairFactor multiplies delentropy values, but not conventional entropy
+	// values. It is ignored if Relative is true.
+	PairFactor float64
+	// If Relative is true, values are reported as a fraction of the maximum
+	// achievable entropy for the histogram, i.e. the entropy of a uniform
+	// distribution over as many bins as can be occupied. That is the smaller
+	// of the number of bins in the histogram and the number of pixels. Both
+	// the unit and the pair factor cancel out of such a ratio, so relative
+	// values are dimensionless and lie between 0 and 1.
+	Relative bool
+}
+
+// DefaultNormalisation returns the normalisation in bits with Larkin's pair
+// factor, which reproduces the delentropy values reported by earlier versions
+// of the sipp program.
+func DefaultNormalisation() Normalisation {
+	return Normalisation{Unit: Bits, PairFactor: LarkinPairFactor}
+}
+
+// EntropyFactor returns the factor by which to multiply an entropy in bits of
+// the given image, or of a sample of the same size, to normalise it.
+func (n Normalisation) EntropyFactor(im SippImage) float64 {
+	numBins := GreyHistSize8BPP
+	if im.Bpp() == 16 {
+		numBins = GreyHistSize16BPP
+	}
+	rect := im.Bounds()
+	return n.factor(1, numBins, rect.Dx()*rect.Dy())
+}
+
+// GreyHistFactor returns the factor by which to multiply an entropy in bits
+// computed from the given 1D histogram, as returned by shist.GreyHist or
+// shist.GreyHistMasked, to normalise it. For a masked histogram, the maximum
+// entropy allows for the number of pixels actually counted. It applies equally
+// to any other 1D histogram of counts, such as the orientation histogram
+// returned by SippPolarHist.AngularHist.
+func (n Normalisation) GreyHistFactor(hist []uint32) float64 {
+	var total uint32
+	for _, binVal := range hist {
+		total += binVal
+	}
+	return n.factor(1, len(hist), int(total))
+}
+
+// DelentropyFactor returns the factor by which to multiply a delentropy in
+// bits computed from the given histogram, or from its gradient, to normalise
+// it.
+func (n Normalisation) DelentropyFactor(hist SippHist) float64 {
+	width, height := hist.Size()
+	return n.factor(n.PairFactor, width*height, int(histTotal(hist)))
+}
+
+// DistributionFactor returns the factor by which to multiply the entropy in
+// bits of any distribution over the given number of outcomes, such as a
+// spectral entropy, to normalise it. The pair factor does not apply.
+func (n Normalisation) DistributionFactor(numOutcomes int) float64 {
+	return n.factor(1, numOutcomes, numOutcomes)
+}
+
+func (n Normalisation) factor(pairFactor float64, numBins, numPixels int) float64 {
+	if !n.Relative {
+		return pairFactor * n.Unit.perBit()
+	}
+	maxEnt := math.Log2(float64(numBins))
+	if numPixels < numBins {
+		maxEnt = math.Log2(float64(numPixels))
+	}
+	if maxEnt <= 0 {
+		// Only one bin can be occupied, so the entropy can only be 0.
+		return 0
+	}
+	return 1 / maxEnt
+}
+
+// Normalised returns the entropy of the image with the given normalisation.
+func (ent *SippEntropy) Normalised(n Normalisation) float64 {
+	return ent.Entropy * n.GreyHistFactor(ent.Hist)
+}
+
+// Normalised returns the delentropy of the histogram with the given
+// normalisation.
+func (dent *SippDelentropy) Normalised(n Normalisation) float64 {
+	return dent.Delentropy * n.DelentropyFactor(dent.hist)
+}
+
+// Normalised returns a copy of the mutual information measures with the given
+// normalisation. In relative mode, the entropy of each image and the
+// conditional entropy given the other are relative to the maximum entropy of
+// that image's bins, the joint entropy to that of the joint bins, and the
+// mutual information to that of the image with fewer bins, which bounds it.
+// The normalised mutual information and symmetric uncertainty are ratios, so
+// they are unchanged.
+func (mi *SippMutualInfo) Normalised(n Normalisation) *SippMutualInfo {
+	hist := mi.Hist
+	total := int(hist.Total)
+	factorA := n.factor(1, hist.BinsA, total)
+	factorB := n.factor(1, hist.BinsB, total)
+	minBins := hist.BinsA
+	if hist.BinsB < minBins {
+		minBins = hist.BinsB
+	}
+	norm := *mi
+	norm.EntropyA *= factorA
+	norm.EntropyB *= factorB
+	norm.JointEntropy *= n.factor(1, hist.BinsA*hist.BinsB, total)
+	norm.CondEntropyAB *= factorA
+	norm.CondEntropyBA *= factorB
+	norm.MutualInfo *= n.factor(1, minBins, total)
+	return &norm
+}
+
+// Scale returns a copy of the bootstrap result with all the values multiplied
+// by the given factor, such as one returned by EntropyFactor or
+// DelentropyFactor.
+func (res *BootstrapResult) Scale(factor float64) *BootstrapResult {
+	scaled := &BootstrapResult{
+		Estimate:   res.Estimate * factor,
+		Mean:       res.Mean * factor,
+		StdErr:     res.StdErr * math.Abs(factor),
+		Lower:      res.Lower * factor,
+		Upper:      res.Upper * factor,
+		Replicates: make([]float64, len(res.Replicates)),
+	}
+	for i, r := range res.Replicates {
+		scaled.Replicates[i] = r * factor
+	}
+	return scaled
+}
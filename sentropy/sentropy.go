@@ -19,6 +19,8 @@ import (
 type SippEntropy struct {
 	// A reference to the image.
 	Im SippImage
+	// The mask selecting the pixels included, or nil if all are.
+	Mask SippImage
 	// A reference to the 1D histogram
 	Hist []uint32
 	// The entropy for each bin value that actually occurred.
@@ -26,16 +28,27 @@ type SippEntropy struct {
 	// The largest entropy value of any bin.
 	MaxBinEntropy float64
 	// The entropy for the image, i.e. the sum of the entropies for all
-	// the pixels.
+	// the pixels, in bits. See Normalised for other units.
 	Entropy float64
 }
 
 // Entropy returns a SippEntropy structure for the given image.
 func Entropy(im SippImage) (ent *SippEntropy) {
+	return EntropyMasked(im, nil)
+}
+
+// EntropyMasked returns a SippEntropy structure for only those pixels of the
+// given image selected by the mask, which must be the same size as the image.
+// A nil mask includes every pixel.
+func EntropyMasked(im SippImage, mask SippImage) (ent *SippEntropy) {
 	ent = new(SippEntropy)
 	ent.Im = im
-	ent.Hist = GreyHist(im)
-	total := float64(im.Bounds().Dx() * im.Bounds().Dy())
+	ent.Mask = mask
+	ent.Hist = GreyHistMasked(im, mask)
+	var total float64
+	for _, binVal := range ent.Hist {
+		total += float64(binVal)
+	}
 	normHist := make([]float64, len(ent.Hist))
 	var check float64
 	for i, binVal := range ent.Hist {
@@ -57,6 +70,7 @@ func Entropy(im SippImage) (ent *SippEntropy) {
 }
 
 // EntropyImage returns a greyscale image of the entropy for each pixel.
+// Pixels excluded by a mask are 0.
 func (ent *SippEntropy) EntropyImage() SippImage {
 	entIm := new(SippGray)
 	entIm.Gray = image.NewGray(ent.Im.Bounds())
@@ -70,8 +84,12 @@ func (ent *SippEntropy) EntropyImage() SippImage {
 	scale := 255.0 / ent.MaxBinEntropy
 	width := ent.Im.Bounds().Dx()
 	imPix := ent.Im.Pix()
+	included := MaskIncluded(ent.Mask, ent.Im.Bounds().Size())
 	for y := 0; y < ent.Im.Bounds().Dy(); y++ {
 		for x := 0; x < width; x++ {
+			if included != nil && !included[y*width+x] {
+				continue
+			}
 			index := ent.Im.PixOffset(x, y)
 			var val uint16 = uint16(imPix[index])
 			if is16 {
@@ -94,11 +112,14 @@ type SippDelentropy struct {
 	// The largest delentropy value of any bin.
 	maxBinDelentropy float64
 	// The delentropy for the image, i.e. the sum of the delentropies for all
-	// of the histogram bins.
+	// of the histogram bins, in bits and without a pair factor. See
+	// Normalised for Larkin's definition and other units.
 	Delentropy float64
 }
 
-// Delentropy returns a SippDelentropy structure for the given SippHist.
+// Delentropy returns a SippDelentropy structure for the given SippHist. If the
+// histogram was computed with a mask, by shist.HistMasked, the delentropy is
+// that of the masked region only.
 func Delentropy(hist SippHist) (dent *SippDelentropy) {
 	// Store the entropy values corresponding to the bin counts that actually
 	// occurred.
@@ -106,8 +127,7 @@ func Delentropy(hist SippHist) (dent *SippDelentropy) {
 	dent.hist = hist
 	bins := hist.Bins()
 	dent.binDelentropy = make([]float64, len(bins))
-	// Pix is a slice of complex128s, so it's length is the number of pixels.
-	numPixels := float64(len(hist.Grad().Pix))
+	numPixels := float64(hist.Total())
 
 	for i, bin := range bins {
 		p := float64(bin.BinVal) / numPixels
@@ -139,19 +159,36 @@ func (dent *SippDelentropy) HistDelentropyImage() SippImage {
 }
 
 // DelEntropyImage returns a greyscale image of the entropy for each gradient
-// pixel.
+// pixel. Pixels excluded by a mask are 0.
 func (dent *SippDelentropy) DelEntropyImage() SippImage {
 	// Make a greyscale image of the entropy for each bin.
 	dentGray := new(SippGray)
-	dentGray.Gray = image.NewGray(dent.hist.Grad().Rect)
+	dentGray.Gray = image.NewGray(dent.hist.Grad().Bounds())
 	dentGrayPix := dentGray.Pix()
 	// scale the entropy from (0-hist.maxBinDelentropy) to (0-255)
 	scale := 255.0 / dent.maxBinDelentropy
 	for y := 0; y < dentGray.Bounds().Dy(); y++ {
 		for x := 0; x < dentGray.Bounds().Dx(); x++ {
 			i := dentGray.PixOffset(x, y)
-			dentGrayPix[i] = uint8(dent.binDelentropy[dent.hist.BinForPixel(x, y)] * scale)
+			if bin := dent.hist.BinForPixel(x, y); bin >= 0 {
+				dentGrayPix[i] = uint8(dent.binDelentropy[bin] * scale)
+			}
 		}
 	}
 	return dentGray
 }
+
+// OrientationEntropy returns the entropy, in bits, of the orientations of the
+// non-zero gradients in the given polar histogram, independent of their
+// magnitudes. Zero gradients have no orientation and are not included.
+func OrientationEntropy(hist *SippPolarHist) float64 {
+	var total float64
+	angular := hist.AngularHist()
+	for _, val := range angular {
+		total += float64(val)
+	}
+	if total == 0 {
+		return 0
+	}
+	return countsEntropy(angular, total)
+}
@@ -7,8 +7,11 @@ package sentropy
 import (
 	"image"
 	_ "image/png"
+	"math"
+	"math/rand"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -191,3 +194,597 @@ func TestDelentropy(t *testing.T) {
 		}
 	}
 }
+
+func TestDelentropyInt32(t *testing.T) {
+	dent := Delentropy(Hist(FromComplexArray(CosxCosyTinyGrad, CosxCosyTinyStride-1)))
+	dent32 := Delentropy(Hist(FromComplexInt32Array(
+		toComplexInt32(CosxCosyTinyGrad), CosxCosyTinyStride-1)))
+	if !reflect.DeepEqual(dent32.binDelentropy, dent.binDelentropy) {
+		t.Errorf("Error: ComplexInt32 delentropy array incorrect. Expected %v, got %v",
+			dent.binDelentropy, dent32.binDelentropy)
+	}
+	if dent32.Delentropy != dent.Delentropy {
+		t.Errorf("Error: ComplexInt32 delentropy incorrect. Expected %v, got %v",
+			dent.Delentropy, dent32.Delentropy)
+	}
+	if !reflect.DeepEqual(dent32.DelEntropyImage().Pix(), dent.DelEntropyImage().Pix()) {
+		t.Error("Error: ComplexInt32 delentropy image differs from complex128 one")
+	}
+}
+
+// toComplexInt32 converts an integer-valued complex128 slice to ComplexInt32s.
+func toComplexInt32(cpx []complex128) []ComplexInt32 {
+	res := make([]ComplexInt32, len(cpx))
+	for i, c := range cpx {
+		res[i] = ComplexInt32{int32(real(c)), int32(imag(c))}
+	}
+	return res
+}
+
+func TestGeneralisedEntropy(t *testing.T) {
+	const epsilon = 1e-12
+	inf := math.Inf(1)
+	cosxCosyHist := Hist(FromComplexArray(CosxCosyTinyGrad, CosxCosyTinyStride-1))
+	// The cosxCosyTiny gradient histogram has 127 non-zero bins out of 361
+	// pixels, with a maximum of 8, and the sum of the squared bin values is
+	// 1369.
+	var tests = []struct {
+		name  string
+		ent   func(order float64) float64
+		order float64
+		exp   float64
+	}{
+		{"Rényi entropy order 0", func(a float64) float64 { return RenyiEntropy(GreyHist(Sgray), a) }, 0, 4},
+		{"Rényi entropy order 0.5", func(a float64) float64 { return RenyiEntropy(GreyHist(Sgray), a) }, 0.5, 4},
+		{"Rényi entropy order 1", func(a float64) float64 { return RenyiEntropy(GreyHist(Sgray), a) }, 1, smallPicEntropy},
+		{"Rényi entropy order 2", func(a float64) float64 { return RenyiEntropy(GreyHist(Sgray16), a) }, 2, 4},
+		{"Rényi entropy order inf", func(a float64) float64 { return RenyiEntropy(GreyHist(Sgray16), a) }, inf, 4},
+		{"Rényi entropy order 1 cosxCosyTiny", func(a float64) float64 { return RenyiEntropy(GreyHist(SgrayCosxCosyTiny), a) }, 1, cosxCosyTinyEntropy},
+		{"Tsallis entropy order 1", func(q float64) float64 { return TsallisEntropy(GreyHist(Sgray), q) }, 1, math.Log(16)},
+		{"Tsallis entropy order 2", func(q float64) float64 { return TsallisEntropy(GreyHist(Sgray), q) }, 2, 1 - 1.0/16},
+		{"Tsallis entropy order 0", func(q float64) float64 { return TsallisEntropy(GreyHist(Sgray), q) }, 0, 15},
+		{"Rényi delentropy order 0", func(a float64) float64 { return RenyiDelentropy(cosxCosyHist, a) }, 0, math.Log2(127)},
+		{"Rényi delentropy order 1", func(a float64) float64 { return RenyiDelentropy(cosxCosyHist, a) }, 1, expectedDelentropy},
+		{"Rényi delentropy order 2", func(a float64) float64 { return RenyiDelentropy(cosxCosyHist, a) }, 2, -math.Log2(1369.0 / (361 * 361))},
+		{"Rényi delentropy order inf", func(a float64) float64 { return RenyiDelentropy(cosxCosyHist, a) }, inf, -math.Log2(8.0 / 361)},
+		{"Tsallis delentropy order 2", func(q float64) float64 { return TsallisDelentropy(cosxCosyHist, q) }, 2, 1 - 1369.0/(361*361)},
+		{"Tsallis delentropy order 1", func(q float64) float64 { return TsallisDelentropy(cosxCosyHist, q) }, 1, expectedDelentropy * math.Ln2},
+	}
+	for _, test := range tests {
+		got := test.ent(test.order)
+		if math.Abs(got-test.exp) > epsilon {
+			t.Errorf("Error: %s incorrect. Expected %v, got %v", test.name, test.exp, got)
+		}
+	}
+
+	// An empty histogram, as of a fully masked image, has entropy 0 at every
+	// order rather than NaN or infinity.
+	emptyGrey := make([]uint32, 256)
+	emptyMask := new(SippGray)
+	emptyMask.Gray = image.NewGray(image.Rect(0, 0, CosxCosyTinyStride-1, CosxCosyTinyStride-1))
+	emptyHist := HistMasked(FromComplexArray(CosxCosyTinyGrad, CosxCosyTinyStride-1), emptyMask)
+	for _, order := range []float64{0, 0.5, 1, 2, inf} {
+		if got := RenyiEntropy(emptyGrey, order); got != 0 {
+			t.Errorf("Error: empty Rényi entropy of order %v is %v, expected 0", order, got)
+		}
+		if got := RenyiDelentropy(emptyHist, order); got != 0 {
+			t.Errorf("Error: empty Rényi delentropy of order %v is %v, expected 0", order, got)
+		}
+	}
+	for _, q := range []float64{0, 0.5, 1, 2} {
+		if got := TsallisEntropy(emptyGrey, q); got != 0 {
+			t.Errorf("Error: empty Tsallis entropy of order %v is %v, expected 0", q, got)
+		}
+		if got := TsallisDelentropy(emptyHist, q); got != 0 {
+			t.Errorf("Error: empty Tsallis delentropy of order %v is %v, expected 0", q, got)
+		}
+	}
+
+	// Rényi entropy is non-increasing in its order.
+	prev := inf
+	for _, alpha := range []float64{0, 0.25, 0.5, 0.99, 1, 1.01, 2, 5, inf} {
+		got := RenyiDelentropy(cosxCosyHist, alpha)
+		if got > prev+epsilon {
+			t.Errorf("Error: Rényi delentropy of order %v, %v, is larger than for the previous order, %v",
+				alpha, got, prev)
+		}
+		prev = got
+	}
+}
+
+// estimate returns the estimator's delentropy of the histogram, failing the
+// test if it returns an error.
+func estimate(t *testing.T, est Estimator, hist SippHist) float64 {
+	t.Helper()
+	dent, err := est.Delentropy(hist)
+	if err != nil {
+		t.Fatalf("Error: estimator %+v failed: %v", est, err)
+	}
+	return dent
+}
+
+func TestEstimators(t *testing.T) {
+	const epsilon = 1e-12
+	hist := Hist(FromComplexArray(CosxCosyTinyGrad, CosxCosyTinyStride-1))
+	plugIn := estimate(t, PlugInEstimator{}, hist)
+	if plugIn != expectedDelentropy {
+		t.Errorf("Error: plug-in estimate incorrect. Expected %v, got %v",
+			expectedDelentropy, plugIn)
+	}
+	// There are 127 occupied bins and 361 pixels.
+	mm := estimate(t, MillerMadowEstimator{}, hist)
+	expMM := expectedDelentropy + 126.0/(2*361)/math.Ln2
+	if math.Abs(mm-expMM) > epsilon {
+		t.Errorf("Error: Miller-Madow estimate incorrect. Expected %v, got %v",
+			expMM, mm)
+	}
+	kde := estimate(t, KernelEstimator{0}, hist)
+	if math.Abs(kde-expectedDelentropy) > epsilon {
+		t.Errorf("Error: unsmoothed kernel estimate incorrect. Expected %v, got %v",
+			expectedDelentropy, kde)
+	}
+	// Smoothing spreads the distribution, so can only increase the entropy.
+	prev := kde
+	for _, sigma := range []float64{0.5, 1, 2} {
+		kde = estimate(t, KernelEstimator{sigma}, hist)
+		if kde <= prev {
+			t.Errorf("Error: kernel estimate for sigma %v, %v, not larger than %v",
+				sigma, kde, prev)
+		}
+		prev = kde
+	}
+
+	// A kernel wider than the histogram can usefully be smoothed into is an
+	// error, as is a negative one.
+	wide := Hist(FromComplexArray([]complex128{0, 60000, 60000i, 60000 + 60000i}, 2))
+	for _, sigma := range []float64{-1, math.NaN(), 1000} {
+		if _, err := (KernelEstimator{sigma}).Delentropy(wide); err == nil {
+			t.Errorf("Error: kernel estimate with sigma %v didn't fail", sigma)
+		}
+	}
+}
+
+func TestKNNEstimator(t *testing.T) {
+	// Check the tree search against an exhaustive search, on points with
+	// plenty of duplicates.
+	rnd := rand.New(rand.NewSource(1))
+	pts := make([][2]float64, 500)
+	for i := range pts {
+		pts[i] = [2]float64{float64(rnd.Intn(20)), float64(rnd.Intn(20))}
+	}
+	tree := newKDTree(pts)
+	for _, k := range []int{1, 3, 10} {
+		for i, p := range pts {
+			dists := make([]float64, 0, len(pts)-1)
+			for j, q := range pts {
+				if j != i {
+					dists = append(dists, distSq(p, q))
+				}
+			}
+			sort.Float64s(dists)
+			got := tree.kthNeighbourDistSq(i, k)
+			if got != dists[k-1] {
+				t.Fatalf("Error: squared distance to neighbour %d of point %d incorrect. Expected %v, got %v",
+					k, i, dists[k-1], got)
+			}
+		}
+	}
+
+	// The differential entropy of a uniform distribution over a 64x64 square
+	// is 12 bits. Integer samples, dequantised, are uniform over the square.
+	const side = 64
+	cpx := make([]complex128, 128*128)
+	for i := range cpx {
+		cpx[i] = complex(float64(rnd.Intn(side)), float64(rnd.Intn(side)))
+	}
+	hist := Hist(FromComplexArray(cpx, 128))
+	est := KNNEstimator{K: 4, Dequantise: true, Seed: 1}
+	knn := estimate(t, est, hist)
+	if math.Abs(knn-12) > 0.1 {
+		t.Errorf("Error: KNN estimate of uniform distribution incorrect. Expected about 12, got %v", knn)
+	}
+	if again := estimate(t, est, hist); again != knn {
+		t.Errorf("Error: KNN estimate not reproducible: got %v then %v", knn, again)
+	}
+	// With only 4 pixels per bin the plug-in estimate is noticeably biased,
+	// but the bias-corrected estimate should agree.
+	mm := estimate(t, MillerMadowEstimator{}, hist)
+	if math.Abs(knn-mm) > 0.1 {
+		t.Errorf("Error: KNN estimate %v differs from Miller-Madow estimate %v", knn, mm)
+	}
+
+	// Coincident integer gradients need dequantising, and there must be more
+	// pixels than K.
+	for _, test := range []struct {
+		name string
+		est  KNNEstimator
+		hist SippHist
+	}{
+		{"coincident values", KNNEstimator{K: 4}, hist},
+		{"too few pixels", KNNEstimator{K: 4, Dequantise: true},
+			Hist(FromComplexArray([]complex128{1, 2, 3, 4}, 2))},
+		{"K of 0", KNNEstimator{Dequantise: true}, hist},
+	} {
+		if _, err := test.est.Delentropy(test.hist); err == nil {
+			t.Errorf("Error: KNN estimate with %s didn't fail", test.name)
+		}
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	const epsilon = 1e-12
+	grad := FromComplexArray(CosxCosyTinyGrad, CosxCosyTinyStride-1)
+	opts := BootstrapOptions{Iterations: 200, Seed: 1, Level: 0.9}
+	var tests = []struct {
+		name string
+		boot func(opts BootstrapOptions) *BootstrapResult
+		exp  float64
+	}{
+		{"entropy", func(opts BootstrapOptions) *BootstrapResult { return BootstrapEntropy(SgrayCosxCosyTiny, opts) }, cosxCosyTinyEntropy},
+		{"delentropy", func(opts BootstrapOptions) *BootstrapResult { return BootstrapDelentropy(grad, opts) }, expectedDelentropy},
+	}
+	for _, test := range tests {
+		for _, block := range []int{0, 4} {
+			opts.BlockSize = block
+			res := test.boot(opts)
+			if math.Abs(res.Estimate-test.exp) > epsilon {
+				t.Errorf("Error: bootstrap %s estimate incorrect. Expected %v, got %v",
+					test.name, test.exp, res.Estimate)
+			}
+			if len(res.Replicates) != opts.Iterations {
+				t.Errorf("Error: bootstrap %s has %d replicates, expected %d",
+					test.name, len(res.Replicates), opts.Iterations)
+			}
+			if !sort.Float64sAreSorted(res.Replicates) {
+				t.Errorf("Error: bootstrap %s replicates not sorted", test.name)
+			}
+			if !(res.Replicates[0] <= res.Lower && res.Lower <= res.Mean &&
+				res.Mean <= res.Upper && res.Upper <= res.Replicates[len(res.Replicates)-1]) {
+				t.Errorf("Error: bootstrap %s statistics out of order: min %v, lower %v, mean %v, upper %v, max %v",
+					test.name, res.Replicates[0], res.Lower, res.Mean, res.Upper,
+					res.Replicates[len(res.Replicates)-1])
+			}
+			if res.StdErr <= 0 {
+				t.Errorf("Error: bootstrap %s standard error %v not positive", test.name, res.StdErr)
+			}
+			again := test.boot(opts)
+			if !reflect.DeepEqual(again, res) {
+				t.Errorf("Error: bootstrap %s not reproducible with the same seed", test.name)
+			}
+		}
+	}
+
+	// A single block covering the whole image can only be placed one way, so
+	// every replicate is the original.
+	opts.BlockSize = CosxCosyTinyStride
+	res := BootstrapEntropy(SgrayCosxCosyTiny, opts)
+	if res.StdErr > epsilon || res.Lower != res.Estimate || res.Upper != res.Estimate {
+		t.Errorf("Error: whole-image block bootstrap should not vary, got standard error %v, interval [%v, %v]",
+			res.StdErr, res.Lower, res.Upper)
+	}
+
+	sorted := []float64{1, 2, 3, 4, 5}
+	for _, q := range []struct{ q, exp float64 }{{0, 1}, {0.5, 3}, {0.125, 1.5}, {1, 5}} {
+		if got := quantile(sorted, q.q); got != q.exp {
+			t.Errorf("Error: quantile %v incorrect. Expected %v, got %v", q.q, q.exp, got)
+		}
+	}
+
+	for _, bad := range []BootstrapOptions{{Iterations: 1, Level: 0.9},
+		{Iterations: 2, Level: 1}, {Iterations: 2, Level: 0.9, BlockSize: 20}} {
+		if bad.Validate(19, 19) == nil {
+			t.Errorf("Error: invalid bootstrap options %+v validated", bad)
+		}
+	}
+	if err := opts.Validate(CosxCosyTinyStride, CosxCosyTinyStride); err != nil {
+		t.Errorf("Error: valid bootstrap options failed to validate: %v", err)
+	}
+}
+
+func TestMutualInformation(t *testing.T) {
+	const epsilon = 1e-12
+	joint, err := JointHist(SgrayCosxCosyTiny, SgrayCosxCosyTiny, 256, 256)
+	if err != nil {
+		t.Fatalf("Error computing joint histogram: %v", err)
+	}
+	mi := MutualInformation(joint)
+	// An image determines itself completely.
+	var tests = []struct {
+		name     string
+		got, exp float64
+	}{
+		{"entropy of A", mi.EntropyA, cosxCosyTinyEntropy},
+		{"entropy of B", mi.EntropyB, cosxCosyTinyEntropy},
+		{"joint entropy", mi.JointEntropy, cosxCosyTinyEntropy},
+		{"conditional entropy H(A|B)", mi.CondEntropyAB, 0},
+		{"conditional entropy H(B|A)", mi.CondEntropyBA, 0},
+		{"mutual information", mi.MutualInfo, cosxCosyTinyEntropy},
+		{"normalised mutual information", mi.NormMutualInfo, 2},
+		{"symmetric uncertainty", mi.SymUncertainty, 1},
+	}
+	for _, test := range tests {
+		if math.Abs(test.got-test.exp) > epsilon {
+			t.Errorf("Error: %s of image with itself incorrect. Expected %v, got %v",
+				test.name, test.exp, test.got)
+		}
+	}
+
+	// In nats, the entropies scale and the ratios don't. Relative to the
+	// maximum, the 256 bins of each image bound its entropy, and the 400
+	// pixels bound the joint entropy.
+	nats := mi.Normalised(Normalisation{Unit: Nats})
+	rel := mi.Normalised(Normalisation{Relative: true})
+	tests = []struct {
+		name     string
+		got, exp float64
+	}{
+		{"mutual information in nats", nats.MutualInfo, cosxCosyTinyEntropy * math.Ln2},
+		{"joint entropy in nats", nats.JointEntropy, cosxCosyTinyEntropy * math.Ln2},
+		{"normalised mutual information in nats", nats.NormMutualInfo, 2},
+		{"relative entropy of A", rel.EntropyA, cosxCosyTinyEntropy / 8},
+		{"relative joint entropy", rel.JointEntropy, cosxCosyTinyEntropy / math.Log2(400)},
+		{"relative mutual information", rel.MutualInfo, cosxCosyTinyEntropy / 8},
+		{"relative symmetric uncertainty", rel.SymUncertainty, 1},
+	}
+	for _, test := range tests {
+		if math.Abs(test.got-test.exp) > epsilon {
+			t.Errorf("Error: %s incorrect. Expected %v, got %v", test.name,
+				test.exp, test.got)
+		}
+	}
+	if math.Abs(mi.MutualInfo-cosxCosyTinyEntropy) > epsilon {
+		t.Error("Error: normalising changed the original mutual information")
+	}
+
+	// Binned to 256 levels, all the values of the 16-bit image are 0, so it
+	// carries no information about the 8-bit one.
+	joint, err = JointHist(Sgray, Sgray16, 256, 256)
+	if err != nil {
+		t.Fatalf("Error computing joint histogram: %v", err)
+	}
+	mi = MutualInformation(joint)
+	tests = []struct {
+		name     string
+		got, exp float64
+	}{
+		{"entropy of A", mi.EntropyA, 4},
+		{"entropy of B", mi.EntropyB, 0},
+		{"joint entropy", mi.JointEntropy, 4},
+		{"conditional entropy H(A|B)", mi.CondEntropyAB, 4},
+		{"conditional entropy H(B|A)", mi.CondEntropyBA, 0},
+		{"mutual information", mi.MutualInfo, 0},
+		{"normalised mutual information", mi.NormMutualInfo, 1},
+		{"symmetric uncertainty", mi.SymUncertainty, 0},
+	}
+	for _, test := range tests {
+		if math.Abs(test.got-test.exp) > epsilon {
+			t.Errorf("Error: %s of independent images incorrect. Expected %v, got %v",
+				test.name, test.exp, test.got)
+		}
+	}
+}
+
+func TestOrientationEntropy(t *testing.T) {
+	// Four directions equally often, plus zeros, which don't count.
+	grad := FromComplexArray([]complex128{1, 1i, -1, -1i, 0, 0}, 6)
+	polar, err := PolarHist(grad, PolarOptions{RadialBins: 4, AngularBins: 8})
+	if err != nil {
+		t.Fatalf("Error computing polar histogram: %v", err)
+	}
+	if ent := OrientationEntropy(polar); ent != 2 {
+		t.Errorf("Error: orientation entropy incorrect. Expected 2, got %v", ent)
+	}
+	// The polar histogram works with Delentropy unchanged. The zero bin has
+	// 2 of the 6 pixels, and the four directions 1 each.
+	dent := Delentropy(polar)
+	exp := -(2.0/6)*math.Log2(2.0/6) - 4*(1.0/6)*math.Log2(1.0/6)
+	if math.Abs(dent.Delentropy-exp) > 1e-12 {
+		t.Errorf("Error: polar delentropy incorrect. Expected %v, got %v", exp, dent.Delentropy)
+	}
+	if dent.DelEntropyImage().Bounds() != grad.Rect {
+		t.Error("Error: polar delentropy image has incorrect bounds")
+	}
+
+	// All zeros have no orientation at all.
+	polar, err = PolarHist(FromComplexArray([]complex128{0, 0}, 2),
+		PolarOptions{RadialBins: 4, AngularBins: 8})
+	if err != nil {
+		t.Fatalf("Error computing polar histogram: %v", err)
+	}
+	if ent := OrientationEntropy(polar); ent != 0 {
+		t.Errorf("Error: orientation entropy of zero gradient incorrect. Expected 0, got %v", ent)
+	}
+}
+
+func TestNormalisation(t *testing.T) {
+	const eps = 1e-12
+	hist := Hist(FromComplexArray(CosxCosyTinyGrad, CosxCosyTinyStride-1))
+	dent := Delentropy(hist)
+	ent := Entropy(SgrayCosxCosyTiny)
+
+	// The default is Larkin's delentropy in bits.
+	def := DefaultNormalisation()
+	if dent.Normalised(def) != expectedDelentropy/2 {
+		t.Errorf("Error: default delentropy incorrect. Expected %v, got %v",
+			expectedDelentropy/2, dent.Normalised(def))
+	}
+	if ent.Normalised(def) != cosxCosyTinyEntropy {
+		t.Errorf("Error: default entropy incorrect. Expected %v, got %v",
+			cosxCosyTinyEntropy, ent.Normalised(def))
+	}
+
+	// Units.
+	nats := Normalisation{Unit: Nats, PairFactor: 1}
+	if d := dent.Normalised(nats); math.Abs(d-expectedDelentropy*math.Ln2) > eps {
+		t.Errorf("Error: delentropy in nats incorrect. Expected %v, got %v",
+			expectedDelentropy*math.Ln2, d)
+	}
+	dits := Normalisation{Unit: Dits}
+	exp := cosxCosyTinyEntropy * math.Log10(2)
+	if e := ent.Normalised(dits); math.Abs(e-exp) > eps {
+		t.Errorf("Error: entropy in dits incorrect. Expected %v, got %v", exp, e)
+	}
+	for _, name := range []string{"bits", "nats", "dits"} {
+		unit, err := ParseUnit(name)
+		if err != nil || unit.String() != name {
+			t.Errorf("Error: unit %s parsed as %v, %v", name, unit, err)
+		}
+	}
+	if _, err := ParseUnit("bytes"); err == nil {
+		t.Error("Error: parsing an unknown unit did not fail")
+	}
+
+	// Relative values. The 8-bit image has more pixels than grey levels, so
+	// its maximum is 8 bits, whatever the unit and pair factor.
+	rel := Normalisation{Unit: Nats, PairFactor: 3, Relative: true}
+	if e := ent.Normalised(rel); math.Abs(e-cosxCosyTinyEntropy/8) > eps {
+		t.Errorf("Error: relative entropy incorrect. Expected %v, got %v",
+			cosxCosyTinyEntropy/8, e)
+	}
+	// The gradient has fewer pixels than histogram bins.
+	width, height := hist.Size()
+	rect := hist.Grad().Bounds()
+	numPix := rect.Dx() * rect.Dy()
+	if numPix >= width*height {
+		t.Fatalf("Error: test gradient has %d pixels and %d bins", numPix, width*height)
+	}
+	exp = expectedDelentropy / math.Log2(float64(numPix))
+	if d := dent.Normalised(rel); math.Abs(d-exp) > eps {
+		t.Errorf("Error: relative delentropy incorrect. Expected %v, got %v", exp, d)
+	}
+	// A single bin can only have zero entropy.
+	single := Delentropy(Hist(FromComplexArray([]complex128{0, 0}, 2)))
+	if d := single.Normalised(rel); d != 0 {
+		t.Errorf("Error: relative delentropy of one bin is %v", d)
+	}
+	// Other distributions have no pair factor.
+	if f := rel.DistributionFactor(16); f != 0.25 {
+		t.Errorf("Error: relative distribution factor %v, expected 0.25", f)
+	}
+	if f := (Normalisation{Unit: Bits, PairFactor: 3}).DistributionFactor(16); f != 1 {
+		t.Errorf("Error: distribution factor %v, expected 1", f)
+	}
+
+	// Bootstrap results scale linearly.
+	res := &BootstrapResult{1, 2, 0.5, 1.5, 2.5, []float64{1.5, 2.5}}
+	scaled := res.Scale(2)
+	expRes := &BootstrapResult{2, 4, 1, 3, 5, []float64{3, 5}}
+	if !reflect.DeepEqual(scaled, expRes) {
+		t.Errorf("Error: scaled bootstrap result %v, expected %v", scaled, expRes)
+	}
+}
+
+func TestFilteredDelentropy(t *testing.T) {
+	const eps = 1e-12
+	grad := FromComplexArray(CosxCosyTinyGrad, CosxCosyTinyStride-1)
+	// With no options, the value is the plain delentropy.
+	plain := FilteredDelentropy(grad, DelentropyOptions{})
+	if math.Abs(plain.Delentropy-expectedDelentropy) > eps || plain.Excluded != 0 {
+		t.Errorf("Error: unfiltered delentropy %v with %d excluded, expected %v",
+			plain.Delentropy, plain.Excluded, expectedDelentropy)
+	}
+
+	// A flat background of zeros, plus four equally likely gradients.
+	flat := FromComplexArray([]complex128{
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0.5 + 0.5i, 1, 1i, -1, -1i, 2, 2, 2i,
+		2i, 0, 0, 0, 0, 0, 0, 0,
+	}, 8)
+	noDC := FilteredDelentropy(flat, DelentropyOptions{ExcludeZero: true})
+	// The 0.5+0.5i falls in the zero bin too.
+	if noDC.Excluded != 16 || noDC.Included != 8 {
+		t.Errorf("Error: excluding zero included %d, excluded %d; expected 8, 16",
+			noDC.Included, noDC.Excluded)
+	}
+	// Four bins with 1 each and two with 2 each, out of 8.
+	exp := -4*(1.0/8)*math.Log2(1.0/8) - 2*(2.0/8)*math.Log2(2.0/8)
+	if math.Abs(noDC.Delentropy-exp) > eps {
+		t.Errorf("Error: delentropy without zero bin %v, expected %v",
+			noDC.Delentropy, exp)
+	}
+	// A floor between 1 and 2 keeps only the four gradients of modulus 2.
+	floor := FilteredDelentropy(flat, DelentropyOptions{NoiseFloor: 1.5})
+	if floor.Included != 4 || math.Abs(floor.Delentropy-1) > eps {
+		t.Errorf("Error: floored delentropy %v with %d included, expected 1, 4",
+			floor.Delentropy, floor.Included)
+	}
+	// The histogram holds only the included pixels, so its delentropy is
+	// the filtered one.
+	if noDC.Hist.Total() != 8 ||
+		math.Abs(Delentropy(noDC.Hist).Delentropy-noDC.Delentropy) > eps ||
+		noDC.Hist.BinForPixel(0, 0) != -1 {
+		t.Errorf("Error: filtered histogram has %d pixels, expected 8",
+			noDC.Hist.Total())
+	}
+	// Radial weighting makes the modulus-2 bins twice as likely as the
+	// modulus-1 bins, and removes the zero bin.
+	radial := FilteredDelentropy(flat, DelentropyOptions{RadialWeight: true})
+	exp = -4*(1.0/12)*math.Log2(1.0/12) - 2*(4.0/12)*math.Log2(4.0/12)
+	if math.Abs(radial.Delentropy-exp) > eps || radial.Excluded != 16 {
+		t.Errorf("Error: radially weighted delentropy %v, expected %v",
+			radial.Delentropy, exp)
+	}
+	// All zeros leave nothing.
+	zeros := FilteredDelentropy(FromComplexArray([]complex128{0, 0}, 2),
+		DelentropyOptions{RadialWeight: true})
+	if zeros.Delentropy != 0 {
+		t.Errorf("Error: weighted delentropy of zeros is %v", zeros.Delentropy)
+	}
+}
+
+func TestMasked(t *testing.T) {
+	const eps = 1e-12
+	// Half of the 16 distinct values of the small picture.
+	mask := new(SippGray)
+	mask.Gray = image.NewGray(Sgray.Bounds())
+	for i := 0; i < 8; i++ {
+		mask.Pix()[i] = 255
+	}
+	ent := EntropyMasked(Sgray, mask)
+	if ent.Entropy != 3 {
+		t.Errorf("Error: masked entropy incorrect. Expected 3, got %v", ent.Entropy)
+	}
+	entPix := ent.EntropyImage().Pix()
+	for i, val := range entPix {
+		if (i < 8) != (val != 0) {
+			t.Errorf("Error: masked entropy image incorrect: %v", entPix)
+			break
+		}
+	}
+	rel := Normalisation{Relative: true}
+	if r := ent.Normalised(rel); math.Abs(r-1) > eps {
+		t.Errorf("Error: relative masked entropy %v, expected 1", r)
+	}
+
+	// The delentropy of a masked histogram is that of the included pixels.
+	grad := FromComplexArray([]complex128{1, 5, 2i, 1, 0, 2i}, 3)
+	gmask := new(SippGray)
+	gmask.Gray = image.NewGray(grad.Rect)
+	copy(gmask.Pix(), []uint8{1, 0, 1, 1, 0, 0})
+	hist := HistMasked(grad, gmask)
+	only := Hist(FromComplexArray([]complex128{1, 2i, 1}, 3))
+	exp := Delentropy(only).Delentropy
+	dent := Delentropy(hist)
+	if math.Abs(dent.Delentropy-exp) > eps {
+		t.Errorf("Error: masked delentropy %v, expected %v", dent.Delentropy, exp)
+	}
+	delPix := dent.DelEntropyImage().Pix()
+	if delPix[1] != 0 || delPix[4] != 0 || delPix[0] == 0 {
+		t.Errorf("Error: masked delentropy image incorrect: %v", delPix)
+	}
+	estimators := []Estimator{
+		MillerMadowEstimator{},
+		KernelEstimator{Sigma: 0},
+	}
+	for _, est := range estimators {
+		if d, e := estimate(t, est, hist), estimate(t, est, only); math.Abs(d-e) > eps {
+			t.Errorf("Error: masked %T delentropy %v, expected %v", est, d, e)
+		}
+	}
+	filtered := FilteredDelentropy(grad, DelentropyOptions{Mask: gmask})
+	if math.Abs(filtered.Delentropy-exp) > eps || filtered.Included != 3 ||
+		filtered.Excluded != 0 {
+		t.Errorf("Error: masked filtered delentropy %v, included %d, excluded %d",
+			filtered.Delentropy, filtered.Included, filtered.Excluded)
+	}
+}
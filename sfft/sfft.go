@@ -34,6 +34,26 @@ func FFT(src SippImage) (fft *FFTImage) {
 	return fft
 }
 
+// Transform computes the FFT of the complex image in place, without the
+// shift applied by FFT, so that the DC term is at the origin.
+func Transform(comp *ComplexImage) {
+	ft := sfft.NewFFT2(comp.Rect.Dy(), comp.Rect.Dx())
+	ft.FFT(comp.Pix)
+	comp.SetScaling()
+}
+
+// InverseTransform computes the inverse of Transform in place, including the
+// division by the number of pixels, so that the two are a round trip.
+func InverseTransform(comp *ComplexImage) {
+	ft := sfft.NewFFT2(comp.Rect.Dy(), comp.Rect.Dx())
+	ft.IFFT(comp.Pix)
+	scale := complex(1/float64(len(comp.Pix)), 0)
+	for i := range comp.Pix {
+		comp.Pix[i] *= scale
+	}
+	comp.SetScaling()
+}
+
 func LogSpectrum(fft *FFTImage) SippImage {
 	spect := new(SippGray)
 	spect.Gray = image.NewGray(fft.Rect)
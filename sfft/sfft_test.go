@@ -5,6 +5,8 @@
 package sfft
 
 import (
+	"image"
+	"math"
 	"reflect"
 	"testing"
 
@@ -12,6 +14,7 @@ import (
 )
 
 import (
+	. "github.com/Causticity/sipp/scomplex"
 	. "github.com/Causticity/sipp/sipptesting"
 )
 
@@ -251,3 +254,61 @@ func TestSfft(t *testing.T) {
 			cosxcosyTinySpect, spect)
 	}
 }
+
+func TestSpectralEntropy(t *testing.T) {
+	tol := 1e-12
+	fft := &FFTImage{ComplexImage{Rect: image.Rect(0, 0, 4, 4)}}
+	fft.Pix = make([]complex128, 16)
+	// The DC term, at the centre, is ignored.
+	fft.Pix[10] = 100
+	se := SpectralEntropy(fft, 2, 2)
+	if se.Entropy != 0 || se.RadialEntropy != 0 || se.AngularEntropy != 0 ||
+		se.Frequencies != 15 {
+		t.Errorf("Error: DC-only spectral entropies %v, %v, %v over %d "+
+			"frequencies", se.Entropy, se.RadialEntropy, se.AngularEntropy,
+			se.Frequencies)
+	}
+
+	// A horizontal frequency and its negative, and a vertical frequency at
+	// the Nyquist limit with twice the power.
+	fft.Pix[11] = 1
+	fft.Pix[9] = 1i
+	fft.Pix[2] = complex(math.Sqrt2, 0)
+	se = SpectralEntropy(fft, 2, 2)
+	expected := []struct {
+		name      string
+		got, want float64
+	}{
+		{"entropy", se.Entropy, 1.5},
+		{"radial entropy", se.RadialEntropy, 1},
+		{"angular entropy", se.AngularEntropy, 1},
+		{"radial power 0", se.RadialPower[0], 0.5},
+		{"radial power 1", se.RadialPower[1], 0.5},
+		{"angular power 0", se.AngularPower[0], 0.5},
+		{"radial resolved 0", se.RadialResolved[0], 1},
+		{"radial resolved 1", se.RadialResolved[1], 0},
+		{"angular resolved 0", se.AngularResolved[0], 1},
+		{"angular resolved 1", se.AngularResolved[1], 0},
+	}
+	for _, exp := range expected {
+		if math.Abs(exp.got-exp.want) > tol {
+			t.Errorf("Error: %s is %v, expected %v", exp.name, exp.got, exp.want)
+		}
+	}
+
+	// The profiles of a real spectrum are distributions.
+	se = SpectralEntropy(FFT(SgrayCosxCosyTiny), 8, 12)
+	var radSum, angSum float64
+	for _, p := range se.RadialPower {
+		radSum += p
+	}
+	for _, p := range se.AngularPower {
+		angSum += p
+	}
+	if math.Abs(radSum-1) > 1e-9 || math.Abs(angSum-1) > 1e-9 {
+		t.Errorf("Error: radial and angular power sum to %v and %v", radSum, angSum)
+	}
+	if se.Entropy <= 0 || se.Entropy > math.Log2(float64(se.Frequencies)) {
+		t.Errorf("Error: spectral entropy %v out of range", se.Entropy)
+	}
+}
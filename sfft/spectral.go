@@ -0,0 +1,146 @@
+// Copyright Raul Vera 2015-2021
+
+package sfft
+
+import (
+	"math"
+)
+
+// SippSpectralEntropy holds the spectral entropies of an image, computed from
+// its power spectrum, the squared modulus of its FFT. All the entropies are
+// in bits.
+//
+// The DC term, at the centre of the shifted spectrum, is excluded, as it
+// measures only the mean brightness of the image and would otherwise dominate
+// the distribution. The remaining power is normalised to sum to 1, so that it
+// can be treated as a probability distribution over spatial frequencies. For
+// an image of odd width or height, the shifted spectrum is offset by half a
+// bin, and the central term is excluded as the nearest to DC.
+//
+// The radial bins divide the frequency modulus, from 0 to that of the corners
+// of the spectrum, into equal intervals. The angular bins divide the frequency
+// orientation from 0 to pi into equal intervals; the power spectrum of a real
+// image is symmetric, so opposite orientations are the same.
+type SippSpectralEntropy struct {
+	// The entropy of the normalised power spectrum. It is high for noise,
+	// whose power is spread over all frequencies, and low for an image
+	// dominated by a few periodic components.
+	Entropy float64
+	// The fraction of the power in each radial bin, from the lowest
+	// frequencies.
+	RadialPower []float64
+	// The entropy of the radial power profile, measuring how widely the power
+	// is spread over scales.
+	RadialEntropy float64
+	// The entropy of the power within each radial bin, measuring how widely
+	// the power at each scale is spread over frequencies, and so over
+	// orientations. It is 0 for a bin with no power.
+	RadialResolved []float64
+	// The fraction of the power in each angular bin.
+	AngularPower []float64
+	// The entropy of the angular power profile, which is highest for an
+	// isotropic image.
+	AngularEntropy float64
+	// The entropy of the power within each angular bin, measuring how widely
+	// the power at each orientation is spread over frequencies, and so over
+	// scales. It is 0 for a bin with no power.
+	AngularResolved []float64
+	// The number of frequencies in the distribution, which is the number of
+	// pixels less the DC term.
+	Frequencies int
+}
+
+// SpectralEntropy computes the spectral entropies of the image whose
+// spectrum is given, with the given numbers of radial and angular bins. An
+// image with no power outside the DC term, such as a constant one, has all
+// entropies 0.
+func SpectralEntropy(fft *FFTImage, radialBins, angularBins int) *SippSpectralEntropy {
+	if radialBins < 1 || angularBins < 1 {
+		panic("Spectral entropy needs at least one radial and angular bin!")
+	}
+	width, height := fft.Rect.Dx(), fft.Rect.Dy()
+	cx, cy := float64(width)/2, float64(height)/2
+	dc := (height/2)*width + width/2
+	maxRad := math.Hypot(0.5, 0.5)
+
+	power := make([]float64, len(fft.Pix))
+	radBin := make([]int, len(fft.Pix))
+	angBin := make([]int, len(fft.Pix))
+	var total float64
+	for y := 0; y < height; y++ {
+		fy := (float64(y) - cy) / float64(height)
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			if i == dc {
+				continue
+			}
+			fx := (float64(x) - cx) / float64(width)
+			c := fft.Pix[i]
+			power[i] = real(c)*real(c) + imag(c)*imag(c)
+			total += power[i]
+			radBin[i] = binFor(math.Hypot(fx, fy)/maxRad, radialBins)
+			theta := math.Atan2(fy, fx)
+			if theta < 0 {
+				theta += math.Pi
+			}
+			if theta >= math.Pi {
+				theta -= math.Pi
+			}
+			angBin[i] = binFor(theta/math.Pi, angularBins)
+		}
+	}
+
+	se := &SippSpectralEntropy{
+		RadialPower:     make([]float64, radialBins),
+		RadialResolved:  make([]float64, radialBins),
+		AngularPower:    make([]float64, angularBins),
+		AngularResolved: make([]float64, angularBins),
+		Frequencies:     len(power) - 1,
+	}
+	if total == 0 {
+		return se
+	}
+	// The entropy within a bin is that of the power p_i of its frequencies
+	// normalised by the bin power P, which is -sum(p_i/P log2(p_i/P)) =
+	// log2(P) - sum(p_i log2(p_i))/P, so only the sums need be accumulated.
+	radPLogP := make([]float64, radialBins)
+	angPLogP := make([]float64, angularBins)
+	for i, pow := range power {
+		if i == dc || pow == 0 {
+			continue
+		}
+		p := pow / total
+		pLogP := p * math.Log2(p)
+		se.Entropy -= pLogP
+		se.RadialPower[radBin[i]] += p
+		se.AngularPower[angBin[i]] += p
+		radPLogP[radBin[i]] += pLogP
+		angPLogP[angBin[i]] += pLogP
+	}
+	se.RadialEntropy = profileEntropy(se.RadialPower, radPLogP, se.RadialResolved)
+	se.AngularEntropy = profileEntropy(se.AngularPower, angPLogP, se.AngularResolved)
+	return se
+}
+
+// binFor returns the bin for a value from 0 to 1 divided into the given
+// number of bins, putting 1 in the last bin.
+func binFor(val float64, bins int) int {
+	bin := int(val * float64(bins))
+	if bin >= bins {
+		bin = bins - 1
+	}
+	return bin
+}
+
+// profileEntropy returns the entropy of the given profile of probabilities,
+// and fills in the entropy within each bin from the sums of p log2(p) of the
+// probabilities in it.
+func profileEntropy(profile, pLogP, resolved []float64) (ent float64) {
+	for i, p := range profile {
+		if p > 0 {
+			ent -= p * math.Log2(p)
+			resolved[i] = math.Log2(p) - pLogP[i]/p
+		}
+	}
+	return
+}
@@ -0,0 +1,482 @@
+// Copyright Raul Vera 2015-2021
+
+// Package sfilter provides spatial filters for the sipp package: convolution
+// with any kernel, with a fast path for separable kernels, and Gaussian, box,
+// median and bilateral smoothing.
+//
+// The filters work on FloatImages, so that they can be chained without loss
+// of precision. Apply filters an 8 or 16-bit image and rounds the result back
+// to the same depth, so that it can be analysed like the original, for
+// example to study the effect of smoothing on delentropy.
+package sfilter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+import (
+	. "github.com/Causticity/sipp/simage"
+)
+
+// A Border selects how a filter treats the pixels beyond the edges of the
+// image.
+type Border int
+
+const (
+	// Reflect mirrors the image about its edges, repeating the edge pixels,
+	// so that the pixels beyond the left edge are 0, 1, 2 and so on.
+	Reflect Border = iota
+	// Clamp repeats the edge pixels.
+	Clamp
+	// Wrap treats the image as periodic.
+	Wrap
+	// Zero treats the pixels beyond the edges as 0.
+	Zero
+	// Crop computes only the pixels whose neighbourhood lies entirely within
+	// the image, so the result is smaller than the source by the radius of
+	// the neighbourhood on each side, as for the gradient.
+	Crop
+)
+
+var borderNames = []string{"reflect", "clamp", "wrap", "zero", "crop"}
+
+func (b Border) String() string {
+	if b < 0 || int(b) >= len(borderNames) {
+		return fmt.Sprintf("Border(%d)", int(b))
+	}
+	return borderNames[b]
+}
+
+// ParseBorder returns the Border with the given name, as returned by String.
+func ParseBorder(name string) (Border, error) {
+	for i, borderName := range borderNames {
+		if name == borderName {
+			return Border(i), nil
+		}
+	}
+	return Reflect, fmt.Errorf("Unknown border mode %q; use reflect, clamp, "+
+		"wrap, zero or crop", name)
+}
+
+// Index returns the index of the pixel to use for index i along an axis of n
+// pixels, and false if the pixel is 0. It panics for Crop if i is outside the
+// axis.
+func (b Border) Index(i, n int) (int, bool) {
+	if i >= 0 && i < n {
+		return i, true
+	}
+	switch b {
+	case Reflect:
+		i %= 2 * n
+		if i < 0 {
+			i += 2 * n
+		}
+		if i >= n {
+			i = 2*n - 1 - i
+		}
+		return i, true
+	case Clamp:
+		if i < 0 {
+			return 0, true
+		}
+		return n - 1, true
+	case Wrap:
+		i %= n
+		if i < 0 {
+			i += n
+		}
+		return i, true
+	case Zero:
+		return 0, false
+	}
+	panic("Pixel outside the image with a cropping border!")
+}
+
+// A neighbourhood is the region of the source used for each result pixel,
+// extending rx pixels left and right and ry pixels up and down.
+type neighbourhood struct {
+	src    *FloatImage
+	border Border
+	rx, ry int
+}
+
+// resultRect returns the bounds of the result.
+func (nb *neighbourhood) resultRect() image.Rectangle {
+	width, height := nb.src.Rect.Dx(), nb.src.Rect.Dy()
+	if nb.border == Crop {
+		width -= 2 * nb.rx
+		height -= 2 * nb.ry
+		if width < 1 || height < 1 {
+			panic("Image is smaller than the filter neighbourhood!")
+		}
+	}
+	return image.Rect(0, 0, width, height)
+}
+
+// origin returns the source position of result pixel x, y.
+func (nb *neighbourhood) origin(x, y int) (int, int) {
+	if nb.border == Crop {
+		return x + nb.rx, y + nb.ry
+	}
+	return x, y
+}
+
+// at returns the source pixel at x, y, applying the border mode.
+func (nb *neighbourhood) at(x, y int) float64 {
+	width, height := nb.src.Rect.Dx(), nb.src.Rect.Dy()
+	x, okx := nb.border.Index(x, width)
+	y, oky := nb.border.Index(y, height)
+	if !okx || !oky {
+		return 0
+	}
+	return nb.src.Pix[y*width+x]
+}
+
+// A Filter is a spatial filter.
+type Filter interface {
+	// Float filters a FloatImage, returning a new one.
+	Float(src *FloatImage) *FloatImage
+	// Extent returns how far the neighbourhood of each result pixel extends
+	// left and right, and up and down. A cropping border trims this many
+	// pixels from each side.
+	Extent() (rx, ry int)
+}
+
+// Apply filters an 8 or 16-bit image, rounding the result to the same depth.
+func Apply(src SippImage, filt Filter) SippImage {
+	return filt.Float(ToFloat(src)).Quantise(src.Bpp())
+}
+
+// A Kernel is a 2D array of weights for convolution, with its centre at
+// Width/2, Height/2.
+type Kernel struct {
+	Width, Height int
+	// The weights, in row-major order.
+	Weights []float64
+	// If the kernel is separable, the outer product of these.
+	col, row []float64
+}
+
+// NewKernel returns a kernel with the given weights in row-major order. The
+// width and height must be odd. If the kernel is separable, convolution uses
+// the faster separable path.
+func NewKernel(width, height int, weights []float64) *Kernel {
+	if width < 1 || height < 1 || width%2 == 0 || height%2 == 0 ||
+		len(weights) != width*height {
+		panic("Kernel dimensions must be odd and match the weights!")
+	}
+	kern := &Kernel{Width: width, Height: height, Weights: weights}
+	kern.factorise()
+	return kern
+}
+
+// SeparableKernel returns the kernel that is the outer product of the given
+// column and row kernels, whose lengths must be odd.
+func SeparableKernel(col, row []float64) *Kernel {
+	if len(col)%2 == 0 || len(row)%2 == 0 {
+		panic("Kernel dimensions must be odd!")
+	}
+	kern := &Kernel{Width: len(row), Height: len(col), col: col, row: row}
+	kern.Weights = make([]float64, 0, len(col)*len(row))
+	for _, c := range col {
+		for _, r := range row {
+			kern.Weights = append(kern.Weights, c*r)
+		}
+	}
+	return kern
+}
+
+// separableTolerance is the relative tolerance for treating a kernel as
+// separable.
+const separableTolerance = 1e-12
+
+// factorise sets the row and column factors if the kernel has rank 1.
+func (kern *Kernel) factorise() {
+	// Find the largest weight; its row and column are the factors, scaled.
+	maxI := 0
+	for i, w := range kern.Weights {
+		if math.Abs(w) > math.Abs(kern.Weights[maxI]) {
+			maxI = i
+		}
+	}
+	pivot := kern.Weights[maxI]
+	if pivot == 0 {
+		return
+	}
+	pr, pc := maxI/kern.Width, maxI%kern.Width
+	col := make([]float64, kern.Height)
+	row := make([]float64, kern.Width)
+	for j := range col {
+		col[j] = kern.Weights[j*kern.Width+pc] / pivot
+	}
+	copy(row, kern.Weights[pr*kern.Width:(pr+1)*kern.Width])
+	for j, c := range col {
+		for i, r := range row {
+			if math.Abs(c*r-kern.Weights[j*kern.Width+i]) >
+				separableTolerance*math.Abs(pivot) {
+				return
+			}
+		}
+	}
+	kern.col, kern.row = col, row
+}
+
+// Separable returns true if the kernel is separable.
+func (kern *Kernel) Separable() bool {
+	return kern.row != nil
+}
+
+// Convolution convolves an image with a kernel.
+type Convolution struct {
+	Kernel *Kernel
+	Border Border
+}
+
+// Float convolves the image with the kernel, so that result pixel x, y is the
+// sum over the kernel of Weights[j*Width+i] times the source pixel at
+// x+Width/2-i, y+Height/2-j. A separable kernel is applied as a row pass and
+// then a column pass.
+func (conv Convolution) Float(src *FloatImage) *FloatImage {
+	kern := conv.Kernel
+	if kern.Separable() {
+		tmp := convolve(src, conv.Border, kern.Width, 1, func(i, j int) float64 {
+			return kern.row[i]
+		})
+		return convolve(tmp, conv.Border, 1, kern.Height, func(i, j int) float64 {
+			return kern.col[j]
+		})
+	}
+	return convolve(src, conv.Border, kern.Width, kern.Height, func(i, j int) float64 {
+		return kern.Weights[j*kern.Width+i]
+	})
+}
+
+// Extent returns half the kernel width and height.
+func (conv Convolution) Extent() (rx, ry int) {
+	return conv.Kernel.Width / 2, conv.Kernel.Height / 2
+}
+
+// convolve convolves the image with a kernel of the given dimensions whose
+// weights are given by the function.
+func convolve(src *FloatImage, border Border, width, height int,
+	weight func(i, j int) float64) *FloatImage {
+	nb := &neighbourhood{src: src, border: border, rx: width / 2, ry: height / 2}
+	rect := nb.resultRect()
+	res := NewFloatImage(rect)
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			sx, sy := nb.origin(x, y)
+			var sum float64
+			for j := 0; j < height; j++ {
+				for i := 0; i < width; i++ {
+					if w := weight(i, j); w != 0 {
+						sum += w * nb.at(sx+nb.rx-i, sy+nb.ry-j)
+					}
+				}
+			}
+			res.Pix[y*rect.Dx()+x] = sum
+		}
+	}
+	res.SetScaling()
+	return res
+}
+
+// Gaussian smooths an image with a Gaussian of the given standard deviation
+// in pixels, truncated at 3 standard deviations.
+type Gaussian struct {
+	Sigma  float64
+	Border Border
+}
+
+// Float smooths the image.
+func (g Gaussian) Float(src *FloatImage) *FloatImage {
+	kern := GaussianKernel(g.Sigma)
+	return Convolution{SeparableKernel(kern, kern), g.Border}.Float(src)
+}
+
+// Extent returns the radius of the truncated Gaussian.
+func (g Gaussian) Extent() (rx, ry int) {
+	r := len(GaussianKernel(g.Sigma)) / 2
+	return r, r
+}
+
+// Box replaces each pixel by the mean of the square of side 2 Radius + 1
+// centred on it.
+type Box struct {
+	Radius int
+	Border Border
+}
+
+// Float smooths the image.
+func (b Box) Float(src *FloatImage) *FloatImage {
+	if b.Radius < 0 {
+		panic("Filter radius must be non-negative!")
+	}
+	side := 2*b.Radius + 1
+	kern := make([]float64, side)
+	for i := range kern {
+		kern[i] = 1 / float64(side)
+	}
+	return Convolution{SeparableKernel(kern, kern), b.Border}.Float(src)
+}
+
+// Extent returns the radius of the square.
+func (b Box) Extent() (rx, ry int) {
+	return b.Radius, b.Radius
+}
+
+// Median replaces each pixel by the median of the square of side
+// 2 Radius + 1 centred on it. It removes impulse noise while preserving
+// edges.
+type Median struct {
+	Radius int
+	Border Border
+}
+
+// Float filters the image.
+func (m Median) Float(src *FloatImage) *FloatImage {
+	if m.Radius < 0 {
+		panic("Filter radius must be non-negative!")
+	}
+	nb := &neighbourhood{src: src, border: m.Border, rx: m.Radius, ry: m.Radius}
+	rect := nb.resultRect()
+	res := NewFloatImage(rect)
+	side := 2*m.Radius + 1
+	window := make([]float64, side*side)
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			sx, sy := nb.origin(x, y)
+			k := 0
+			for j := -m.Radius; j <= m.Radius; j++ {
+				for i := -m.Radius; i <= m.Radius; i++ {
+					window[k] = nb.at(sx+i, sy+j)
+					k++
+				}
+			}
+			sort.Float64s(window)
+			res.Pix[y*rect.Dx()+x] = window[len(window)/2]
+		}
+	}
+	res.SetScaling()
+	return res
+}
+
+// Extent returns the radius of the square.
+func (m Median) Extent() (rx, ry int) {
+	return m.Radius, m.Radius
+}
+
+// Bilateral smooths an image while preserving edges, by weighting each
+// neighbour by a Gaussian of its distance, with standard deviation Sigma in
+// pixels, and by a Gaussian of its difference in value from the centre
+// pixel, with standard deviation RangeSigma in grey levels. The neighbourhood
+// extends to 3 Sigma.
+type Bilateral struct {
+	Sigma, RangeSigma float64
+	Border            Border
+}
+
+// Float smooths the image.
+func (bl Bilateral) Float(src *FloatImage) *FloatImage {
+	if bl.Sigma < 0 || bl.RangeSigma <= 0 {
+		panic("Bilateral filter standard deviations must be positive!")
+	}
+	radius, _ := bl.Extent()
+	side := 2*radius + 1
+	spatial := make([]float64, side*side)
+	for j := -radius; j <= radius; j++ {
+		for i := -radius; i <= radius; i++ {
+			w := 1.0
+			if bl.Sigma > 0 {
+				w = math.Exp(-float64(i*i+j*j) / (2 * bl.Sigma * bl.Sigma))
+			}
+			spatial[(j+radius)*side+i+radius] = w
+		}
+	}
+	nb := &neighbourhood{src: src, border: bl.Border, rx: radius, ry: radius}
+	rect := nb.resultRect()
+	res := NewFloatImage(rect)
+	rangeDenom := 2 * bl.RangeSigma * bl.RangeSigma
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			sx, sy := nb.origin(x, y)
+			centre := nb.at(sx, sy)
+			var sum, norm float64
+			for j := -radius; j <= radius; j++ {
+				for i := -radius; i <= radius; i++ {
+					val := nb.at(sx+i, sy+j)
+					diff := val - centre
+					w := spatial[(j+radius)*side+i+radius] *
+						math.Exp(-diff*diff/rangeDenom)
+					sum += w * val
+					norm += w
+				}
+			}
+			res.Pix[y*rect.Dx()+x] = sum / norm
+		}
+	}
+	res.SetScaling()
+	return res
+}
+
+// Extent returns the radius of the neighbourhood, 3 Sigma rounded up.
+func (bl Bilateral) Extent() (rx, ry int) {
+	r := int(math.Ceil(3 * bl.Sigma))
+	return r, r
+}
+
+// ParseFilter parses a filter specification of the form name:parameters,
+// one of gauss:sigma, box:radius, median:radius or
+// bilateral:sigma,rangesigma, and returns the filter with the given border
+// mode.
+func ParseFilter(spec string, border Border) (Filter, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("Filter must be given as name:parameters")
+	}
+	var params []float64
+	for _, field := range strings.Split(parts[1], ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter parameter %q: %v", field, err)
+		}
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return nil, fmt.Errorf("Filter parameter %q is not finite", field)
+		}
+		if val < 0 {
+			return nil, fmt.Errorf("Filter parameter %q is negative", field)
+		}
+		params = append(params, val)
+	}
+	numParams := 1
+	if parts[0] == "bilateral" {
+		numParams = 2
+	}
+	if len(params) != numParams {
+		return nil, fmt.Errorf("Filter %q takes %d parameters", parts[0], numParams)
+	}
+	if (parts[0] == "box" || parts[0] == "median") && params[0] != math.Trunc(params[0]) {
+		return nil, fmt.Errorf("Filter %q takes an integer radius", parts[0])
+	}
+	switch parts[0] {
+	case "gauss":
+		return Gaussian{params[0], border}, nil
+	case "box":
+		return Box{int(params[0]), border}, nil
+	case "median":
+		return Median{int(params[0]), border}, nil
+	case "bilateral":
+		if params[1] == 0 {
+			return nil, errors.New("Bilateral range sigma must be positive")
+		}
+		return Bilateral{params[0], params[1], border}, nil
+	}
+	return nil, fmt.Errorf("Unknown filter %q; use gauss, box, median or "+
+		"bilateral", parts[0])
+}
@@ -0,0 +1,188 @@
+// Copyright Raul Vera 2021
+
+// Tests for package sfilter.
+
+package sfilter
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+import (
+	. "github.com/Causticity/sipp/simage"
+	. "github.com/Causticity/sipp/sipptesting"
+)
+
+const tolerance = 1e-9
+
+func TestBorder(t *testing.T) {
+	expected := map[Border][]int{
+		Reflect: {1, 0, 0, 1, 2, 3, 3, 2},
+		Clamp:   {0, 0, 0, 1, 2, 3, 3, 3},
+		Wrap:    {2, 3, 0, 1, 2, 3, 0, 1},
+	}
+	for border, exp := range expected {
+		for i := -2; i < 6; i++ {
+			if got, ok := border.Index(i, 4); !ok || got != exp[i+2] {
+				t.Errorf("Error: %v index %d is %d, expected %d", border, i, got, exp[i+2])
+			}
+		}
+	}
+	if _, ok := Zero.Index(-1, 4); ok {
+		t.Error("Error: zero border returned a pixel outside the image")
+	}
+	if b, err := ParseBorder("wrap"); err != nil || b != Wrap {
+		t.Errorf("Error: parsed wrap as %v, %v", b, err)
+	}
+	if _, err := ParseBorder("mirror"); err == nil {
+		t.Error("Error: parsing an unknown border didn't fail")
+	}
+}
+
+func TestConvolution(t *testing.T) {
+	src := ToFloat(SgrayCosxCosyTiny)
+
+	// A kernel with a single off-centre weight shifts the image, in the
+	// direction of convolution rather than correlation.
+	shift := NewKernel(3, 1, []float64{1, 0, 0})
+	res := Convolution{shift, Clamp}.Float(src)
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			want := src.Val(int(math.Min(float64(x+1), 19)), y)
+			if res.Val(x, y) != want {
+				t.Fatalf("Error: shifted pixel %d,%d is %v, expected %v", x, y,
+					res.Val(x, y), want)
+			}
+		}
+	}
+
+	// A separable kernel is detected, and gives the same result as the
+	// general path.
+	weights := []float64{
+		1, 2, 1,
+		2, 4, 2,
+		3, 6, 3,
+	}
+	kern := NewKernel(3, 3, weights)
+	if !kern.Separable() {
+		t.Error("Error: separable kernel not detected")
+	}
+	if NewKernel(3, 3, []float64{1, 0, 0, 0, 1, 0, 0, 0, 2}).Separable() {
+		t.Error("Error: non-separable kernel detected as separable")
+	}
+	for _, border := range []Border{Reflect, Clamp, Wrap, Zero, Crop} {
+		fast := Convolution{kern, border}.Float(src)
+		slow := convolve(src, border, 3, 3, func(i, j int) float64 {
+			return weights[j*3+i]
+		})
+		if fast.Rect != slow.Rect {
+			t.Errorf("Error: %v separable bounds %v, expected %v", border,
+				fast.Rect, slow.Rect)
+			continue
+		}
+		for i := range fast.Pix {
+			if math.Abs(fast.Pix[i]-slow.Pix[i]) > tolerance {
+				t.Errorf("Error: %v separable pixel %d is %v, expected %v",
+					border, i, fast.Pix[i], slow.Pix[i])
+				break
+			}
+		}
+	}
+	if res := (Convolution{kern, Crop}).Float(src); res.Rect != image.Rect(0, 0, 18, 18) {
+		t.Errorf("Error: cropped bounds %v", res.Rect)
+	}
+}
+
+func TestSmoothing(t *testing.T) {
+	// Smoothing preserves a constant image except with a zero border.
+	flat := FromFloatArray([]float64{7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7}, 4)
+	for _, filt := range []Filter{
+		Gaussian{1.5, Reflect},
+		Box{1, Clamp},
+		Median{1, Wrap},
+		Bilateral{1, 10, Reflect},
+	} {
+		for i, val := range filt.Float(flat).Pix {
+			if math.Abs(val-7) > tolerance {
+				t.Errorf("Error: %T pixel %d of a constant image is %v", filt, i, val)
+				break
+			}
+		}
+	}
+	if corner := (Box{1, Zero}).Float(flat).Pix[0]; math.Abs(corner-7*4/9.0) > tolerance {
+		t.Errorf("Error: zero-bordered box corner is %v, expected %v", corner, 7*4/9.0)
+	}
+
+	// The median removes an impulse, and the bilateral filter, unlike the
+	// Gaussian, preserves a step.
+	impulse := FromFloatArray([]float64{
+		1, 1, 1, 1,
+		1, 9, 1, 1,
+		1, 1, 1, 1,
+	}, 4)
+	for i, val := range (Median{1, Reflect}).Float(impulse).Pix {
+		if val != 1 {
+			t.Errorf("Error: median pixel %d is %v, expected 1", i, val)
+		}
+	}
+	step := FromFloatArray([]float64{
+		0, 0, 0, 100, 100, 100,
+		0, 0, 0, 100, 100, 100,
+	}, 6)
+	bil := Bilateral{2, 5, Reflect}.Float(step)
+	gauss := Gaussian{2, Reflect}.Float(step)
+	if math.Abs(bil.Val(2, 0)) > 1e-6 || math.Abs(bil.Val(3, 0)-100) > 1e-6 {
+		t.Errorf("Error: bilateral step is %v, %v", bil.Val(2, 0), bil.Val(3, 0))
+	}
+	if gauss.Val(2, 0) < 10 {
+		t.Errorf("Error: Gaussian step is %v, %v", gauss.Val(2, 0), gauss.Val(3, 0))
+	}
+
+	// Apply keeps the depth of the source.
+	if res := Apply(Sgray16, Gaussian{1, Reflect}); res.Bpp() != 16 ||
+		res.Bounds() != Sgray16.Bounds() {
+		t.Errorf("Error: filtered 16-bit image has depth %d, bounds %v",
+			res.Bpp(), res.Bounds())
+	}
+	if res := Apply(Sgray, Median{0, Crop}); res.IntVal(1, 2) != Sgray.IntVal(1, 2) {
+		t.Error("Error: median of radius 0 changed the image")
+	}
+
+	// A cropping border trims the extent of each filter from each side.
+	big := FromFloatArray(make([]float64, 20*20), 20)
+	for _, filt := range []Filter{
+		Gaussian{1.5, Crop},
+		Box{2, Crop},
+		Median{1, Crop},
+		Bilateral{1, 10, Crop},
+		Convolution{NewKernel(3, 5, make([]float64, 15)), Crop},
+	} {
+		rx, ry := filt.Extent()
+		if size := filt.Float(big).Rect.Size(); size != image.Pt(20-2*rx, 20-2*ry) {
+			t.Errorf("Error: %T with extent %d, %d cropped to %v", filt, rx, ry, size)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	good := map[string]Filter{
+		"gauss:1.5":      Gaussian{1.5, Crop},
+		"box:2":          Box{2, Crop},
+		"median:1":       Median{1, Crop},
+		"bilateral:2,20": Bilateral{2, 20, Crop},
+	}
+	for spec, want := range good {
+		if filt, err := ParseFilter(spec, Crop); err != nil || filt != want {
+			t.Errorf("Error: parsed %q as %v, %v", spec, filt, err)
+		}
+	}
+	for _, spec := range []string{"gauss", "gauss:x", "box:1.5", "median:-1",
+		"bilateral:2", "bilateral:2,0", "sharpen:1", "gauss:nan", "gauss:inf",
+		"bilateral:2,Inf"} {
+		if _, err := ParseFilter(spec, Reflect); err == nil {
+			t.Errorf("Error: parsing %q didn't fail", spec)
+		}
+	}
+}
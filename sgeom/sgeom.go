@@ -0,0 +1,474 @@
+// Copyright Raul Vera 2015-2021
+
+// Package sgeom provides geometric transforms for the sipp package: flips,
+// transposition, rotation by multiples of 90 degrees and by any angle,
+// cropping and padding. All of them return images of the same bit depth as
+// their sources. Except for rotation by an angle that isn't a multiple of 90
+// degrees, they move pixels without changing their values.
+//
+// Delentropy computed with the 2x2 gradient kernel is not invariant under
+// rotation, and these transforms allow that to be measured directly.
+//
+// Angles are in degrees, anticlockwise as the image is displayed, with y
+// pointing down.
+package sgeom
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+import (
+	"github.com/Causticity/sipp/sfilter"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// newImage returns a new image of the given size and depth.
+func newImage(width, height, bpp int) SippImage {
+	rect := image.Rect(0, 0, width, height)
+	if bpp == 8 {
+		return &SippGray{Gray: image.NewGray(rect)}
+	}
+	return &SippGray16{Gray16: image.NewGray16(rect)}
+}
+
+// remap returns a new image of the given size and of the source's depth, in
+// which pixel x, y is copied from the source pixel at the position returned
+// by the function, or is 0 if the function returns false.
+func remap(src SippImage, width, height int,
+	srcPos func(x, y int) (sx, sy int, ok bool)) SippImage {
+	dst := newImage(width, height, src.Bpp())
+	bytes := src.Bpp() / 8
+	srcPix, dstPix := src.Pix(), dst.Pix()
+	origin := src.Bounds().Min
+	i := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if sx, sy, ok := srcPos(x, y); ok {
+				j := src.PixOffset(origin.X+sx, origin.Y+sy)
+				copy(dstPix[i:i+bytes], srcPix[j:j+bytes])
+			}
+			i += bytes
+		}
+	}
+	return dst
+}
+
+// FlipHorizontal returns the image mirrored left to right.
+func FlipHorizontal(src SippImage) SippImage {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	return remap(src, width, height, func(x, y int) (int, int, bool) {
+		return width - 1 - x, y, true
+	})
+}
+
+// FlipVertical returns the image mirrored top to bottom.
+func FlipVertical(src SippImage) SippImage {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	return remap(src, width, height, func(x, y int) (int, int, bool) {
+		return x, height - 1 - y, true
+	})
+}
+
+// Transpose returns the image reflected about its leading diagonal, so that
+// pixel x, y becomes pixel y, x.
+func Transpose(src SippImage) SippImage {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	return remap(src, height, width, func(x, y int) (int, int, bool) {
+		return y, x, true
+	})
+}
+
+// Rotate90 returns the image rotated anticlockwise by the given number of
+// quarter turns, which may be negative for clockwise turns.
+func Rotate90(src SippImage, turns int) SippImage {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	switch (turns%4 + 4) % 4 {
+	case 1:
+		return remap(src, height, width, func(x, y int) (int, int, bool) {
+			return width - 1 - y, x, true
+		})
+	case 2:
+		return remap(src, width, height, func(x, y int) (int, int, bool) {
+			return width - 1 - x, height - 1 - y, true
+		})
+	case 3:
+		return remap(src, height, width, func(x, y int) (int, int, bool) {
+			return y, height - 1 - x, true
+		})
+	}
+	return remap(src, width, height, func(x, y int) (int, int, bool) {
+		return x, y, true
+	})
+}
+
+// Crop returns the part of the image within the given rectangle, relative to
+// the top-left corner of the image. Returns an error if the rectangle is
+// empty or extends beyond the image.
+func Crop(src SippImage, rect image.Rectangle) (SippImage, error) {
+	bounds := image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy())
+	if rect.Empty() || !rect.In(bounds) {
+		return nil, fmt.Errorf("Crop rectangle %v is empty or outside the "+
+			"image bounds %v", rect, bounds)
+	}
+	return remap(src, rect.Dx(), rect.Dy(), func(x, y int) (int, int, bool) {
+		return rect.Min.X + x, rect.Min.Y + y, true
+	}), nil
+}
+
+// Pad returns the image extended by the given numbers of pixels on each side,
+// filled according to the border mode. Returns an error if any number is
+// negative or the border mode is sfilter.Crop.
+func Pad(src SippImage, left, top, right, bottom int,
+	border sfilter.Border) (SippImage, error) {
+	if left < 0 || top < 0 || right < 0 || bottom < 0 {
+		return nil, errors.New("Padding must be non-negative!")
+	}
+	if border == sfilter.Crop {
+		return nil, errors.New("Can't pad with a cropping border!")
+	}
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	return remap(src, width+left+right, height+top+bottom,
+		func(x, y int) (int, int, bool) {
+			sx, okx := border.Index(x-left, width)
+			sy, oky := border.Index(y-top, height)
+			return sx, sy, okx && oky
+		}), nil
+}
+
+// An Interpolation selects how values between pixels are computed.
+type Interpolation int
+
+const (
+	// Nearest takes the value of the nearest pixel.
+	Nearest Interpolation = iota
+	// Bilinear interpolates linearly between the 4 nearest pixels.
+	Bilinear
+	// Bicubic interpolates with the cubic convolution kernel of Keys, with
+	// a = -0.5, over the 16 nearest pixels. It is sharper than bilinear
+	// interpolation, but may overshoot at edges, where the results are
+	// clipped to the range of the depth.
+	Bicubic
+)
+
+var interpolationNames = []string{"nearest", "bilinear", "bicubic"}
+
+func (interp Interpolation) String() string {
+	if interp < 0 || int(interp) >= len(interpolationNames) {
+		return fmt.Sprintf("Interpolation(%d)", int(interp))
+	}
+	return interpolationNames[interp]
+}
+
+// ParseInterpolation returns the Interpolation with the given name, as
+// returned by String.
+func ParseInterpolation(name string) (Interpolation, error) {
+	for i, interpName := range interpolationNames {
+		if name == interpName {
+			return Interpolation(i), nil
+		}
+	}
+	return Nearest, fmt.Errorf("Unknown interpolation %q; use nearest, "+
+		"bilinear or bicubic", name)
+}
+
+// cubic returns the weight of Keys' cubic convolution kernel at distance d.
+func cubic(d float64) float64 {
+	const a = -0.5
+	d = math.Abs(d)
+	switch {
+	case d < 1:
+		return ((a+2)*d-(a+3))*d*d + 1
+	case d < 2:
+		return ((a*d-5*a)*d+8*a)*d - 4*a
+	}
+	return 0
+}
+
+// A tap is a source pixel that an interpolated value is taken from, with its
+// weight.
+type tap struct {
+	x, y   int
+	weight float64
+}
+
+// taps appends to ts the source pixels, with non-zero weights, that the
+// interpolation takes the value at sx, sy from, and returns the result.
+func (interp Interpolation) taps(sx, sy float64, ts []tap) []tap {
+	switch interp {
+	case Nearest:
+		return append(ts, tap{int(math.Round(sx)), int(math.Round(sy)), 1})
+	case Bilinear:
+		x0, y0 := math.Floor(sx), math.Floor(sy)
+		fx, fy := sx-x0, sy-y0
+		ix, iy := int(x0), int(y0)
+		for j, wy := range [2]float64{1 - fy, fy} {
+			for i, wx := range [2]float64{1 - fx, fx} {
+				if w := wx * wy; w != 0 {
+					ts = append(ts, tap{ix + i, iy + j, w})
+				}
+			}
+		}
+		return ts
+	case Bicubic:
+		x0, y0 := math.Floor(sx), math.Floor(sy)
+		ix, iy := int(x0), int(y0)
+		for j := -1; j <= 2; j++ {
+			wy := cubic(sy - y0 - float64(j))
+			if wy == 0 {
+				continue
+			}
+			for i := -1; i <= 2; i++ {
+				if wx := cubic(sx - x0 - float64(i)); wx != 0 {
+					ts = append(ts, tap{ix + i, iy + j, wx * wy})
+				}
+			}
+		}
+		return ts
+	}
+	panic("Unknown interpolation!")
+}
+
+// rotation returns a function giving the source position, in an image of the
+// given size, of each destination pixel of the image rotated anticlockwise by
+// the given angle in degrees about its centre.
+func rotation(width, height int, degrees float64) func(x, y int) (sx, sy float64) {
+	rad := degrees * math.Pi / 180
+	sin, cos := math.Sincos(rad)
+	// Snap the multiples of 90 degrees, so that they move whole pixels.
+	sin, cos = math.Round(sin*1e12)/1e12, math.Round(cos*1e12)/1e12
+	cx, cy := float64(width-1)/2, float64(height-1)/2
+	return func(x, y int) (sx, sy float64) {
+		// Rotate the destination position clockwise to find the source.
+		dx, dy := float64(x)-cx, float64(y)-cy
+		return cx + dx*cos - dy*sin, cy + dx*sin + dy*cos
+	}
+}
+
+// Rotate returns the image rotated anticlockwise by the given angle in
+// degrees about its centre, at the same size. Pixels whose source lies beyond
+// the edges of the image are taken from the border mode, and the values are
+// interpolated as given. Multiples of 90 degrees on a square image give
+// exactly the result of Rotate90. It panics if the border mode is
+// sfilter.Crop.
+func Rotate(src SippImage, degrees float64, interp Interpolation,
+	border sfilter.Border) SippImage {
+	if border == sfilter.Crop {
+		panic("Can't rotate with a cropping border!")
+	}
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	flt := ToFloat(src)
+	// The value at integer source coordinates, applying the border mode.
+	at := func(x, y int) float64 {
+		sx, okx := border.Index(x, width)
+		sy, oky := border.Index(y, height)
+		if !okx || !oky {
+			return 0
+		}
+		return flt.Pix[sy*width+sx]
+	}
+	source := rotation(width, height, degrees)
+	res := NewFloatImage(image.Rect(0, 0, width, height))
+	var ts []tap
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := source(x, y)
+			var val float64
+			ts = interp.taps(sx, sy, ts[:0])
+			for _, t := range ts {
+				val += t.weight * at(t.x, t.y)
+			}
+			res.Pix[y*width+x] = val
+		}
+	}
+	res.SetScaling()
+	return res.Quantise(src.Bpp())
+}
+
+// RotateMask rotates a mask for an image as Rotate rotates the image. A pixel
+// of the result is included, with the value 255, only if every source pixel
+// that the interpolation takes its value from is included, so that a rotated
+// value never mixes in excluded ones. Source pixels beyond the edges are
+// found with the border mode, and those that the border mode leaves without
+// a value are excluded. Included pixels of the mask are non-zero. It panics
+// if the border mode is sfilter.Crop.
+func RotateMask(mask SippImage, degrees float64, interp Interpolation,
+	border sfilter.Border) SippImage {
+	if border == sfilter.Crop {
+		panic("Can't rotate with a cropping border!")
+	}
+	width, height := mask.Bounds().Dx(), mask.Bounds().Dy()
+	included := func(x, y int) bool {
+		sx, okx := border.Index(x, width)
+		sy, oky := border.Index(y, height)
+		return okx && oky && mask.IntVal(sx, sy) != 0
+	}
+	source := rotation(width, height, degrees)
+	res := newImage(width, height, 8)
+	resPix := res.Pix()
+	var ts []tap
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := source(x, y)
+			ts = interp.taps(sx, sy, ts[:0])
+			resPix[y*width+x] = 255
+			for _, t := range ts {
+				if !included(t.x, t.y) {
+					resPix[y*width+x] = 0
+					break
+				}
+			}
+		}
+	}
+	return res
+}
+
+// InscribedRect returns the largest axis-aligned rectangle, centred in an
+// image of the given size, that lies entirely within the image rotated by
+// the given angle about its centre, less a margin of one pixel on each side
+// for the interpolation. Measurements over this rectangle of images rotated
+// by different angles cover only pixels that came from within the source.
+func InscribedRect(width, height int, degrees float64) image.Rectangle {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	sin, cos = math.Abs(sin), math.Abs(cos)
+	w, h := float64(width), float64(height)
+	long, short := w, h
+	if h > w {
+		long, short = h, w
+	}
+	var rw, rh float64
+	if short <= 2*sin*cos*long || math.Abs(sin-cos) < 1e-10 {
+		// The rectangle touches the long sides of the rotated image only, at
+		// its half-width, in a corner of the rectangle.
+		half := short / 2
+		if w >= h {
+			rw, rh = half/sin, half/cos
+		} else {
+			rw, rh = half/cos, half/sin
+		}
+	} else {
+		// The rectangle touches all four sides of the rotated image.
+		cos2 := cos*cos - sin*sin
+		rw, rh = (w*cos-h*sin)/cos2, (h*cos-w*sin)/cos2
+	}
+	rectW := int(math.Min(rw, w)+1e-9) - 2
+	rectH := int(math.Min(rh, h)+1e-9) - 2
+	if rectW < 1 || rectH < 1 {
+		return image.Rectangle{}
+	}
+	x0, y0 := (width-rectW)/2, (height-rectH)/2
+	return image.Rect(x0, y0, x0+rectW, y0+rectH)
+}
+
+// A Transform is a geometric transform of an image.
+type Transform func(src SippImage) (SippImage, error)
+
+// The number of parameters of each transform.
+var transformParams = map[string]int{"flip": 1, "transpose": 0, "rot90": 1,
+	"rotate": 1, "crop": 4, "pad": 4}
+
+// ParseTransforms parses a sequence of transforms separated by +, to be
+// applied in turn, and returns the transform that applies them all. Each is
+// one of flip:h, flip:v, transpose, rot90:turns, rotate:degrees,
+// crop:x,y,width,height or pad:left,top,right,bottom, such as
+// crop:0,0,256,256+rotate:30. Rotation uses the given interpolation, and
+// rotation and padding the given border mode.
+func ParseTransforms(spec string, interp Interpolation,
+	border sfilter.Border) (Transform, error) {
+	var transforms []Transform
+	for _, tSpec := range strings.Split(spec, "+") {
+		parts := strings.SplitN(tSpec, ":", 2)
+		name := parts[0]
+		var fields []string
+		if len(parts) == 2 {
+			fields = strings.Split(parts[1], ",")
+		}
+		num, ok := transformParams[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown transform %q; use flip, "+
+				"transpose, rot90, rotate, crop or pad", name)
+		}
+		if len(fields) != num {
+			return nil, fmt.Errorf("Transform %q takes %d parameters", name, num)
+		}
+		if name == "flip" {
+			switch fields[0] {
+			case "h":
+				transforms = append(transforms, wrap(FlipHorizontal))
+			case "v":
+				transforms = append(transforms, wrap(FlipVertical))
+			default:
+				return nil, errors.New("Transform \"flip\" takes h or v")
+			}
+			continue
+		}
+		var params []float64
+		for _, field := range fields {
+			val, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid transform parameter %q: %v",
+					field, err)
+			}
+			if math.IsNaN(val) || math.IsInf(val, 0) {
+				return nil, fmt.Errorf("Transform %q takes finite parameters", name)
+			}
+			if name != "rotate" && val != math.Trunc(val) {
+				return nil, fmt.Errorf("Transform %q takes integers", name)
+			}
+			params = append(params, val)
+		}
+		switch name {
+		case "transpose":
+			transforms = append(transforms, wrap(Transpose))
+		case "rot90":
+			turns := int(params[0])
+			transforms = append(transforms, wrap(func(src SippImage) SippImage {
+				return Rotate90(src, turns)
+			}))
+		case "rotate":
+			if border == sfilter.Crop {
+				return nil, errors.New("Can't rotate with a cropping border!")
+			}
+			degrees := params[0]
+			transforms = append(transforms, wrap(func(src SippImage) SippImage {
+				return Rotate(src, degrees, interp, border)
+			}))
+		case "crop":
+			if params[2] < 1 || params[3] < 1 {
+				return nil, errors.New("Transform \"crop\" takes a positive " +
+					"width and height")
+			}
+			rect := image.Rect(int(params[0]), int(params[1]),
+				int(params[0]+params[2]), int(params[1]+params[3]))
+			transforms = append(transforms, func(src SippImage) (SippImage, error) {
+				return Crop(src, rect)
+			})
+		case "pad":
+			transforms = append(transforms, func(src SippImage) (SippImage, error) {
+				return Pad(src, int(params[0]), int(params[1]), int(params[2]),
+					int(params[3]), border)
+			})
+		}
+	}
+	return func(src SippImage) (SippImage, error) {
+		for _, transform := range transforms {
+			var err error
+			src, err = transform(src)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return src, nil
+	}, nil
+}
+
+// wrap returns a Transform for a transform that can't fail.
+func wrap(transform func(SippImage) SippImage) Transform {
+	return func(src SippImage) (SippImage, error) {
+		return transform(src), nil
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright Raul Vera 2021
+
+// Tests for package sgeom.
+
+package sgeom
+
+import (
+	"image"
+	"testing"
+)
+
+import (
+	"github.com/Causticity/sipp/sfilter"
+	. "github.com/Causticity/sipp/simage"
+	. "github.com/Causticity/sipp/sipptesting"
+)
+
+// sameImage reports the first pixel at which the images differ by more than
+// the tolerance.
+func sameImage(t *testing.T, name string, got, want SippImage, tol int32) {
+	t.Helper()
+	if got.Bounds().Size() != want.Bounds().Size() || got.Bpp() != want.Bpp() {
+		t.Fatalf("Error: %s is %v at %d bits, expected %v at %d bits", name,
+			got.Bounds(), got.Bpp(), want.Bounds(), want.Bpp())
+	}
+	size := want.Bounds().Size()
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			diff := got.IntVal(x, y) - want.IntVal(x, y)
+			if diff > tol || diff < -tol {
+				t.Fatalf("Error: %s pixel %d,%d is %d, expected %d", name, x, y,
+					got.IntVal(x, y), want.IntVal(x, y))
+			}
+		}
+	}
+}
+
+func TestFlipRotate90(t *testing.T) {
+	for _, src := range []SippImage{Sgray, Sgray16} {
+		flipped := FlipHorizontal(src)
+		if flipped.Bpp() != src.Bpp() || flipped.IntVal(0, 1) != src.IntVal(3, 1) {
+			t.Errorf("Error: flipped pixel 0,1 is %d, expected %d",
+				flipped.IntVal(0, 1), src.IntVal(3, 1))
+		}
+		if FlipVertical(src).IntVal(1, 0) != src.IntVal(1, 3) {
+			t.Error("Error: vertical flip moved the wrong pixel")
+		}
+		if Transpose(src).IntVal(1, 2) != src.IntVal(2, 1) {
+			t.Error("Error: transposition moved the wrong pixel")
+		}
+		sameImage(t, "quarter turn", Rotate90(src, 1), FlipVertical(Transpose(src)), 0)
+		sameImage(t, "half turn", Rotate90(src, 2), FlipVertical(FlipHorizontal(src)), 0)
+		sameImage(t, "clockwise turn", Rotate90(src, -1), Rotate90(src, 3), 0)
+		sameImage(t, "full turn", Rotate90(src, 4), src, 0)
+	}
+
+	// A non-square image changes shape, and the top-right corner moves to
+	// the top left.
+	src, _ := Crop(SgrayCosxCosyTiny, image.Rect(0, 0, 20, 7))
+	rot := Rotate90(src, 1)
+	if rot.Bounds().Dx() != 7 || rot.Bounds().Dy() != 20 || rot.IntVal(0, 0) != src.IntVal(19, 0) {
+		t.Errorf("Error: rotated image is %v with pixel 0,0 %d, expected %d",
+			rot.Bounds(), rot.IntVal(0, 0), src.IntVal(19, 0))
+	}
+}
+
+func TestCropPad(t *testing.T) {
+	crop, err := Crop(Sgray16, image.Rect(1, 2, 3, 4))
+	if err != nil {
+		t.Fatal("Error cropping:", err)
+	}
+	if crop.Bounds().Size() != image.Pt(2, 2) || crop.IntVal(1, 0) != Sgray16.IntVal(2, 2) {
+		t.Errorf("Error: crop is %v with pixel 1,0 %d", crop.Bounds(), crop.IntVal(1, 0))
+	}
+	for _, rect := range []image.Rectangle{image.Rect(2, 2, 2, 3), image.Rect(1, 1, 5, 2)} {
+		if _, err := Crop(Sgray, rect); err == nil {
+			t.Errorf("Error: cropping to %v didn't fail", rect)
+		}
+	}
+
+	pad, err := Pad(Sgray, 2, 1, 0, 3, sfilter.Reflect)
+	if err != nil {
+		t.Fatal("Error padding:", err)
+	}
+	if pad.Bounds().Size() != image.Pt(6, 8) {
+		t.Fatalf("Error: padded image is %v", pad.Bounds())
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 6; x++ {
+			sx, _ := sfilter.Reflect.Index(x-2, 4)
+			sy, _ := sfilter.Reflect.Index(y-1, 4)
+			if pad.IntVal(x, y) != Sgray.IntVal(sx, sy) {
+				t.Fatalf("Error: padded pixel %d,%d is %d, expected %d", x, y,
+					pad.IntVal(x, y), Sgray.IntVal(sx, sy))
+			}
+		}
+	}
+	pad, _ = Pad(Sgray16, 1, 1, 1, 1, sfilter.Zero)
+	if pad.Bpp() != 16 || pad.IntVal(0, 0) != 0 || pad.IntVal(1, 1) != Sgray16.IntVal(0, 0) {
+		t.Errorf("Error: zero padding gave %d and %d", pad.IntVal(0, 0), pad.IntVal(1, 1))
+	}
+	if _, err := Pad(Sgray, 1, -1, 0, 0, sfilter.Zero); err == nil {
+		t.Error("Error: negative padding didn't fail")
+	}
+	if _, err := Pad(Sgray, 1, 1, 1, 1, sfilter.Crop); err == nil {
+		t.Error("Error: padding with a cropping border didn't fail")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	for _, interp := range []Interpolation{Nearest, Bilinear, Bicubic} {
+		for _, src := range []SippImage{SgrayCosxCosyTiny, Sgray16} {
+			sameImage(t, "zero rotation", Rotate(src, 0, interp, sfilter.Zero), src, 0)
+			sameImage(t, "right-angle rotation", Rotate(src, 90, interp, sfilter.Zero),
+				Rotate90(src, 1), 0)
+			sameImage(t, "straight-angle rotation", Rotate(src, -180, interp, sfilter.Zero),
+				Rotate90(src, 2), 0)
+		}
+	}
+
+	// Rotating a smooth image and back again nearly recovers it within the
+	// inscribed rectangle.
+	src := SgrayCosxCosyTiny
+	rect := InscribedRect(20, 20, 30)
+	for _, interp := range []Interpolation{Bilinear, Bicubic} {
+		back := Rotate(Rotate(src, 30, interp, sfilter.Reflect), -30, interp, sfilter.Reflect)
+		want, _ := Crop(src, rect)
+		got, _ := Crop(back, rect)
+		sameImage(t, "rotated back "+interp.String(), got, want, 24)
+	}
+
+	// A rotated mask excludes every pixel whose value takes any weight from
+	// an excluded pixel, and nothing else at right angles.
+	spot := &SippGray{Gray: image.NewGray(image.Rect(0, 0, 20, 20))}
+	spot.Gray.Pix[10*20+10] = 255
+	mask := &SippGray{Gray: image.NewGray(image.Rect(0, 0, 20, 20))}
+	for i := range mask.Gray.Pix {
+		mask.Gray.Pix[i] = 1
+	}
+	mask.Gray.Pix[10*20+10] = 0
+	for _, interp := range []Interpolation{Nearest, Bilinear, Bicubic} {
+		for _, degrees := range []float64{0, 90} {
+			rotMask := RotateMask(mask, degrees, interp, sfilter.Reflect)
+			rotSpot := Rotate(spot, degrees, interp, sfilter.Reflect)
+			for i, val := range rotMask.Pix() {
+				if (val == 0) != (rotSpot.Pix()[i] != 0) {
+					t.Errorf("Error: %s mask rotated by %v degrees is %d at %d",
+						interp, degrees, val, i)
+				}
+			}
+		}
+		rotMask := RotateMask(mask, 30, interp, sfilter.Reflect)
+		rotSpot := Rotate(spot, 30, interp, sfilter.Reflect)
+		excluded := 0
+		for i, val := range rotMask.Pix() {
+			if val == 0 {
+				excluded++
+			} else if rotSpot.Pix()[i] != 0 {
+				t.Errorf("Error: %s mask rotated by 30 degrees includes %d",
+					interp, i)
+			}
+		}
+		if excluded == 0 {
+			t.Errorf("Error: %s mask rotated by 30 degrees excludes nothing", interp)
+		}
+		// The corners come from beyond the edges, which have no value with
+		// a zero border.
+		if RotateMask(mask, 30, interp, sfilter.Zero).Pix()[0] != 0 {
+			t.Errorf("Error: %s mask rotated with a zero border includes the corner",
+				interp)
+		}
+	}
+
+	expected := map[float64]image.Rectangle{
+		0:   image.Rect(1, 1, 99, 49),
+		90:  image.Rect(26, 1, 74, 49),
+		180: image.Rect(1, 1, 99, 49),
+	}
+	for degrees, want := range expected {
+		if got := InscribedRect(100, 50, degrees); got != want {
+			t.Errorf("Error: inscribed rectangle at %v degrees is %v, expected %v",
+				degrees, got, want)
+		}
+	}
+	// The inscribed square of a square rotated by 45 degrees has sides of
+	// 1/sqrt 2 of the square's, here 70.7 pixels, less the margins.
+	if side := InscribedRect(100, 100, 45).Dx(); side != 68 {
+		t.Errorf("Error: inscribed square at 45 degrees has side %d", side)
+	}
+}
+
+func TestParseTransforms(t *testing.T) {
+	transform, err := ParseTransforms("crop:1,1,3,2+rot90:1+flip:h", Bilinear, sfilter.Zero)
+	if err != nil {
+		t.Fatal("Error parsing transforms:", err)
+	}
+	got, err := transform(Sgray)
+	if err != nil {
+		t.Fatal("Error applying transforms:", err)
+	}
+	crop, _ := Crop(Sgray, image.Rect(1, 1, 4, 3))
+	sameImage(t, "parsed transforms", got, FlipHorizontal(Rotate90(crop, 1)), 0)
+	small, _ := Crop(Sgray16, image.Rect(0, 0, 2, 2))
+	if _, err := transform(small); err == nil {
+		t.Error("Error: cropping beyond a small image didn't fail")
+	}
+	for _, spec := range []string{"flip:d", "transpose:1", "rot90:1.5", "crop:1,2",
+		"crop:10,10,-5,-5", "crop:0,0,0,2", "rotate:NaN", "pad:Inf,0,0,0",
+		"shear:10", "rotate"} {
+		if _, err := ParseTransforms(spec, Nearest, sfilter.Zero); err == nil {
+			t.Errorf("Error: parsing %q didn't fail", spec)
+		}
+	}
+	if _, err := ParseTransforms("rotate:10", Nearest, sfilter.Crop); err == nil {
+		t.Error("Error: rotating with a cropping border didn't fail")
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright Raul Vera 2015-2021
+
+package sgrad
+
+import (
+	"image"
+	"math"
+	"math/cmplx"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	"github.com/Causticity/sipp/sfft"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// The functions below integrate a gradient computed with the default kernel
+// back to an image, by finding the image whose gradient is closest to it in
+// the least-squares sense, which is the solution of a Poisson equation. A
+// gradient that has been edited, such as by filtering or thresholding it, is
+// generally no longer the gradient of any image, and the result is then the
+// best approximation.
+//
+// The default kernel compares each pixel only with its diagonal neighbours,
+// so it never compares pixels on the two interleaved lattices of pixels with
+// x+y even and with x+y odd. The image is therefore determined only up to a
+// constant on each lattice, and is returned with zero mean on each. Use
+// MatchLatticeMeans to restore the means of a reference image.
+
+// IntegrateFFT integrates the gradient assuming that the image is periodic,
+// with a period of the size of the gradient, so that the last row and column
+// of the image, which are returned, repeat the first. The Poisson equation is
+// then solved directly by FFT. The result is exact for the gradient of a
+// periodic image; for any other image the mismatch at the edges is spread
+// over the whole image.
+//
+// If the gradient has an odd width or height, the wrap-around connects the two
+// lattices, and the result has zero mean over the whole image instead.
+func IntegrateFFT(grad SippComplexImage) *FloatImage {
+	vals := grad.Values()
+	width, height := grad.Bounds().Dx(), grad.Bounds().Dy()
+	per := solvePeriodic(vals, width, height)
+	res := NewFloatImage(image.Rect(0, 0, width+1, height+1))
+	for y := 0; y <= height; y++ {
+		for x := 0; x <= width; x++ {
+			res.Pix[y*(width+1)+x] = per[(y%height)*width+x%width]
+		}
+	}
+	if width%2 == 1 || height%2 == 1 {
+		var mean float64
+		for _, val := range res.Pix {
+			mean += val
+		}
+		mean /= float64(len(res.Pix))
+		for i := range res.Pix {
+			res.Pix[i] -= mean
+		}
+		res.SetScaling()
+	} else {
+		setLatticeMeans(res, 0, 0)
+	}
+	return res
+}
+
+// IntegrateDCT integrates the gradient with Neumann boundary conditions,
+// which make no assumption about the image beyond its edges. The result is
+// exact for the gradient of any image.
+//
+// The image is extended by reflecting it about its last row and column, which
+// makes it periodic. The gradient of the extension can be found from the
+// given gradient, so the Poisson equation is solved by FFT of the extension,
+// which is equivalent to a discrete cosine transform.
+func IntegrateDCT(grad SippComplexImage) *FloatImage {
+	vals := grad.Values()
+	width, height := grad.Bounds().Dx(), grad.Bounds().Dy()
+	extWidth, extHeight := 2*width, 2*height
+	ext := make([]complex128, extWidth*extHeight)
+	for y := 0; y < extHeight; y++ {
+		// The row of the reflected gradient value, and whether this row is
+		// reflected.
+		srcY, flipY := y, y >= height
+		if flipY {
+			srcY = extHeight - y - 1
+		}
+		for x := 0; x < extWidth; x++ {
+			srcX, flipX := x, x >= width
+			if flipX {
+				srcX = extWidth - x - 1
+			}
+			val := vals[srcY*width+srcX]
+			re, im := real(val), imag(val)
+			// Reflecting the image swaps the diagonal and anti-diagonal
+			// differences, negating them for a horizontal reflection.
+			switch {
+			case flipX && flipY:
+				re, im = -re, -im
+			case flipX:
+				re, im = -im, -re
+			case flipY:
+				re, im = im, re
+			}
+			ext[y*extWidth+x] = complex(re, im)
+		}
+	}
+	per := solvePeriodic(ext, extWidth, extHeight)
+	res := NewFloatImage(image.Rect(0, 0, width+1, height+1))
+	for y := 0; y <= height; y++ {
+		copy(res.Pix[y*(width+1):(y+1)*(width+1)], per[y*extWidth:])
+	}
+	setLatticeMeans(res, 0, 0)
+	return res
+}
+
+// solvePeriodic returns the periodic image of the given size whose gradient
+// is closest to the given periodic gradient of the same size, with the DC
+// term, and the checkerboard term if there is one, set to 0.
+func solvePeriodic(vals []complex128, width, height int) []float64 {
+	if width < 1 || height < 1 {
+		panic("Can't integrate an empty gradient!")
+	}
+	ft := &ComplexImage{Rect: image.Rect(0, 0, width, height)}
+	ft.Pix = append([]complex128(nil), vals...)
+	sfft.Transform(ft)
+	spect := make([]complex128, len(ft.Pix))
+	for v := 0; v < height; v++ {
+		wy := 2 * math.Pi * float64(v) / float64(height)
+		for u := 0; u < width; u++ {
+			wx := 2 * math.Pi * float64(u) / float64(width)
+			denom := 4 - 4*math.Cos(wx)*math.Cos(wy)
+			if denom < 1e-12 {
+				continue
+			}
+			// The real and imaginary parts of the gradient are real images,
+			// whose transforms are separated using the symmetry of the
+			// transform of a real image.
+			g := ft.Pix[v*width+u]
+			gNeg := cmplx.Conj(ft.Pix[((height-v)%height)*width+(width-u)%width])
+			diag := (g + gNeg) / 2
+			anti := (g - gNeg) / 2i
+			// The transforms of the diagonal and anti-diagonal difference
+			// operators.
+			kDiag := cmplx.Exp(complex(0, wx+wy)) - 1
+			kAnti := cmplx.Exp(complex(0, wx)) - cmplx.Exp(complex(0, wy))
+			spect[v*width+u] = (cmplx.Conj(kDiag)*diag +
+				cmplx.Conj(kAnti)*anti) / complex(denom, 0)
+		}
+	}
+	ft.Pix = spect
+	sfft.InverseTransform(ft)
+	res := make([]float64, len(spect))
+	for i, val := range ft.Pix {
+		res[i] = real(val)
+	}
+	return res
+}
+
+// latticeMeans returns the means of the pixels with x+y even and odd.
+func latticeMeans(flt *FloatImage) (even, odd float64) {
+	var numEven, numOdd int
+	width := flt.Rect.Dx()
+	for i, val := range flt.Pix {
+		if (i%width+i/width)%2 == 0 {
+			even += val
+			numEven++
+		} else {
+			odd += val
+			numOdd++
+		}
+	}
+	if numEven > 0 {
+		even /= float64(numEven)
+	}
+	if numOdd > 0 {
+		odd /= float64(numOdd)
+	}
+	return
+}
+
+// setLatticeMeans adds constants to the pixels with x+y even and odd to give
+// them the given means.
+func setLatticeMeans(flt *FloatImage, even, odd float64) {
+	curEven, curOdd := latticeMeans(flt)
+	width := flt.Rect.Dx()
+	for i := range flt.Pix {
+		if (i%width+i/width)%2 == 0 {
+			flt.Pix[i] += even - curEven
+		} else {
+			flt.Pix[i] += odd - curOdd
+		}
+	}
+	flt.SetScaling()
+}
+
+// MatchLatticeMeans adds constants to the pixels of the integrated image with
+// x+y even and with x+y odd so that their means match those of the same
+// pixels in the reference image, typically the image from which the gradient
+// was computed. The images must be the same size.
+func MatchLatticeMeans(rec *FloatImage, ref SippImage) {
+	if rec.Rect.Size() != ref.Bounds().Size() {
+		panic("Integrated and reference image sizes differ!")
+	}
+	even, odd := latticeMeans(ToFloat(ref))
+	setLatticeMeans(rec, even, odd)
+}
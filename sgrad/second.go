@@ -0,0 +1,168 @@
+// Copyright Raul Vera 2015-2021
+
+package sgrad
+
+import (
+	"image"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// The second-order operators below use 3x3 stencils, so, as for the gradient,
+// their results are smaller than their sources rather than extending the
+// source, here by one pixel on each side. Pixel x, y of a result is centred on
+// pixel x+1, y+1 of the source. The results are FloatImages, whose Render
+// scales them in the same way as ComplexImage.Render scales each part. With
+// integer sources, all the values are exact.
+
+// A ScalarImage is any image of real values, such as a SippImage or a
+// FloatImage.
+type ScalarImage interface {
+	Bounds() image.Rectangle
+	Val(x, y int) float64
+}
+
+// SippHessian holds the second derivatives of an image.
+type SippHessian struct {
+	Xx, Xy, Yy *FloatImage
+}
+
+// Render renders the three components as separate 8-bit grayscale images.
+func (hess *SippHessian) Render() (xx, xy, yy SippImage) {
+	return hess.Xx.Render(), hess.Xy.Render(), hess.Yy.Render()
+}
+
+// stencil applies the given function to each pixel of the source that has all
+// 8 neighbours, passing the 3x3 neighbourhood in row-major order, and returns
+// the results.
+func stencil(src ScalarImage, op func(nb *[9]float64) float64) *FloatImage {
+	srect := src.Bounds()
+	width, height := srect.Dx()-2, srect.Dy()-2
+	if width < 1 || height < 1 {
+		panic("Image is too small for a 3x3 stencil!")
+	}
+	res := NewFloatImage(image.Rect(0, 0, width, height))
+	var nb [9]float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for j := 0; j < 3; j++ {
+				for i := 0; i < 3; i++ {
+					nb[j*3+i] = src.Val(x+i, y+j)
+				}
+			}
+			res.Pix[y*width+x] = op(&nb)
+		}
+	}
+	res.SetScaling()
+	return res
+}
+
+// Hessian computes the second derivatives of the image by central
+// differences.
+func Hessian(src ScalarImage) *SippHessian {
+	return &SippHessian{
+		Xx: stencil(src, func(nb *[9]float64) float64 {
+			return nb[3] - 2*nb[4] + nb[5]
+		}),
+		Xy: stencil(src, func(nb *[9]float64) float64 {
+			return (nb[0] - nb[2] - nb[6] + nb[8]) / 4
+		}),
+		Yy: stencil(src, func(nb *[9]float64) float64 {
+			return nb[1] - 2*nb[4] + nb[7]
+		}),
+	}
+}
+
+// Laplacian4 computes the Laplacian of the image with the 4-neighbour
+// stencil, which is the trace of the Hessian.
+func Laplacian4(src ScalarImage) *FloatImage {
+	return stencil(src, func(nb *[9]float64) float64 {
+		return nb[1] + nb[3] + nb[5] + nb[7] - 4*nb[4]
+	})
+}
+
+// Laplacian8 computes the Laplacian of the image with the 8-neighbour
+// stencil, which is more nearly isotropic than the 4-neighbour one. It is the
+// sum of the 4-neighbour Laplacian and the divergence of the gradient from
+// the default kernel, which compares only diagonal neighbours.
+func Laplacian8(src ScalarImage) *FloatImage {
+	return stencil(src, func(nb *[9]float64) float64 {
+		var sum float64
+		for _, val := range nb {
+			sum += val
+		}
+		return sum - 9*nb[4]
+	})
+}
+
+// LoG computes the Laplacian of Gaussian of the image, by smoothing it with a
+// Gaussian of the given standard deviation, as by FloatImage.GaussianSmooth,
+// and then applying the 4-neighbour Laplacian.
+func LoG(src ScalarImage, sigma float64) *FloatImage {
+	flt, ok := src.(*FloatImage)
+	if !ok {
+		srect := src.Bounds()
+		flt = NewFloatImage(image.Rect(0, 0, srect.Dx(), srect.Dy()))
+		for y := 0; y < srect.Dy(); y++ {
+			for x := 0; x < srect.Dx(); x++ {
+				flt.Pix[y*srect.Dx()+x] = src.Val(x, y)
+			}
+		}
+	}
+	return Laplacian4(flt.GaussianSmooth(sigma))
+}
+
+// DivCurl computes the divergence and curl of a vector field laid out as the
+// output of Fdgrad with the default kernel, returning them as the real and
+// imaginary parts of a complex image, so that its Render gives the two
+// separately. The result is a *ComplexImage for a *ComplexImage field, and a
+// *ComplexInt32Image, computed exactly, for a *ComplexInt32Image field.
+//
+// The default kernel puts the difference along the leading diagonal in the
+// real part and along the other diagonal in the imaginary part, at the
+// corner shared by the four pixels. Each result pixel is computed from the
+// four field pixels around one source pixel, so the result is one pixel
+// smaller than the field in each direction, and aligned with the Hessian and
+// Laplacians. For the gradient of an image, the divergence is the Laplacian
+// along the diagonals, the sum of the 4 diagonal neighbours less 4 times the
+// pixel, and the curl is 0. The curl is positive for a field rotating from
+// the x axis towards the y axis, which points down the image.
+func DivCurl(field SippComplexImage) SippComplexImage {
+	frect := field.Bounds()
+	width, height := frect.Dx()-1, frect.Dy()-1
+	if width < 1 || height < 1 {
+		panic("Vector field is too small for its divergence!")
+	}
+	switch f := field.(type) {
+	case *ComplexImage:
+		pix := make([]complex128, 0, width*height)
+		stride := frect.Dx()
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				tl, tr := f.Pix[y*stride+x], f.Pix[y*stride+x+1]
+				bl, br := f.Pix[(y+1)*stride+x], f.Pix[(y+1)*stride+x+1]
+				div := real(br) - real(tl) + imag(tr) - imag(bl)
+				curl := real(tr) - real(bl) - imag(br) + imag(tl)
+				pix = append(pix, complex(div, curl))
+			}
+		}
+		return FromComplexArray(pix, width)
+	case *ComplexInt32Image:
+		pix := make([]ComplexInt32, 0, width*height)
+		stride := frect.Dx()
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				tl, tr := f.Pix[y*stride+x], f.Pix[y*stride+x+1]
+				bl, br := f.Pix[(y+1)*stride+x], f.Pix[(y+1)*stride+x+1]
+				div := br.Re - tl.Re + tr.Im - bl.Im
+				curl := tr.Re - bl.Re - br.Im + tl.Im
+				pix = append(pix, ComplexInt32{div, curl})
+			}
+		}
+		return FromComplexInt32Array(pix, width)
+	}
+	panic("Unsupported vector field image type!")
+}
@@ -33,6 +33,40 @@ var defaultInt32Kernel = SippGradInt32Kernel {
 	{{0, -1}, {1, 0}},
 }
 
+// DefaultKernel returns the kernel used by Fdgrad, which puts the difference
+// along the leading diagonal in the real part and the difference along the
+// other diagonal in the imaginary part.
+func DefaultKernel() SippGradKernel {
+	return defaultKernel
+}
+
+// AxisKernel returns a kernel that puts the forward difference along x in the
+// real part and the forward difference along y in the imaginary part, so that
+// its values are already in image coordinates.
+func AxisKernel() SippGradKernel {
+	return SippGradKernel{
+		{-1 - 1i, 1},
+		{1i, 0},
+	}
+}
+
+// ImageFrame returns the gradient in image coordinates, with x to the right
+// and y down, that the kernel maps to the given value. Any 2x2 kernel maps
+// the gradient of an image linearly to its output, and this inverts that
+// map. It panics if the kernel can't distinguish all gradient directions.
+func (kern SippGradKernel) ImageFrame(val complex128) (gx, gy float64) {
+	// The kernel's response to images increasing by 1 along x and along y.
+	kx := kern[0][1] + kern[1][1]
+	ky := kern[1][0] + kern[1][1]
+	det := real(kx)*imag(ky) - real(ky)*imag(kx)
+	if det == 0 {
+		panic("Gradient kernel is singular!")
+	}
+	gx = (imag(ky)*real(val) - real(ky)*imag(val)) / det
+	gy = (real(kx)*imag(val) - imag(kx)*real(val)) / det
+	return
+}
+
 // TODO: The non-int32 functions below could be reimplemented to use only
 // floating-point arithmetic, with a conversion to complex only at the end. As
 // it is now it goes back and forth unnecessarily. This is optimisation and
@@ -60,8 +94,15 @@ func byInt32Kernel(kern SippGradInt32Kernel,
 // Use a SippGradKernel to create a finite-differences complex gradient image,
 // one pixel narrower and shorter than the original. We'd rather reduce the size
 // of the output image than arbitrarily wrap around or extend the source image,
-// as any such procedure could introduce errors into the statistics.
-func FdgradKernel(src SippImage, kern SippGradKernel) (grad *ComplexImage) {
+// as any such procedure could introduce errors into the statistics. The source
+// may also be a FloatImage, such as a smoothed image.
+func FdgradKernel(src ScalarImage, kern SippGradKernel) (grad *ComplexImage) {
+	return fdgradKernel(src, kern, nil)
+}
+
+// fdgradKernel implements FdgradKernel, setting to 0 any gradient pixel for
+// which included is false. If included is nil, all pixels are computed.
+func fdgradKernel(src ScalarImage, kern SippGradKernel, included []bool) (grad *ComplexImage) {
 	// Create the dst image from the bounds of the src
 	srect := src.Bounds()
 	grad = new(ComplexImage)
@@ -76,8 +117,11 @@ func FdgradKernel(src SippImage, kern SippGradKernel) (grad *ComplexImage) {
 	dsti := 0
 	for y := 0; y < grad.Rect.Dy(); y++ {
 		for x := 0; x < grad.Rect.Dx(); x++ {
-			val := byKernel(kern, src.Val(x, y),
-				src.Val(x+1, y), src.Val(x, y+1), src.Val(x+1, y+1))
+			var val complex128
+			if included == nil || included[dsti] {
+				val = byKernel(kern, src.Val(x, y),
+					src.Val(x+1, y), src.Val(x, y+1), src.Val(x+1, y+1))
+			}
 			grad.Pix[dsti] = val
 			dsti++
 			re := real(val)
@@ -113,6 +157,14 @@ func FdgradKernel(src SippImage, kern SippGradKernel) (grad *ComplexImage) {
 // statistics.
 func FdgradInt32Kernel(src SippImage,
 					   kern SippGradInt32Kernel) (grad *ComplexInt32Image) {
+	return fdgradInt32Kernel(src, kern, nil)
+}
+
+// fdgradInt32Kernel implements FdgradInt32Kernel, setting to 0 any gradient
+// pixel for which included is false. If included is nil, all pixels are
+// computed.
+func fdgradInt32Kernel(src SippImage, kern SippGradInt32Kernel,
+					   included []bool) (grad *ComplexInt32Image) {
 	// Create the dst image from the bounds of the src
 	srect := src.Bounds()
 	grad = new(ComplexInt32Image)
@@ -127,8 +179,11 @@ func FdgradInt32Kernel(src SippImage,
 	dsti := 0
 	for y := 0; y < grad.Rect.Dy(); y++ {
 		for x := 0; x < grad.Rect.Dx(); x++ {
-			val := byInt32Kernel(kern, src.IntVal(x, y),
-				src.IntVal(x+1, y), src.IntVal(x, y+1), src.IntVal(x+1, y+1))
+			var val ComplexInt32
+			if included == nil || included[dsti] {
+				val = byInt32Kernel(kern, src.IntVal(x, y),
+					src.IntVal(x+1, y), src.IntVal(x, y+1), src.IntVal(x+1, y+1))
+			}
 			grad.Pix[dsti] = val
 			dsti++
 			modsq := float64(val.Re*val.Re) + float64(val.Im*val.Im)
@@ -166,3 +221,60 @@ func Fdgrad(src SippImage) (grad *ComplexImage) {
 func FdgradInt32(src SippImage) (grad *ComplexInt32Image) {
 	return FdgradInt32Kernel(src, defaultInt32Kernel)
 }
+
+// GradMask converts a mask for a source image into a mask for its gradient,
+// which is one pixel narrower and shorter. A gradient pixel is included only
+// if all four of the source pixels it is computed from are included. Included
+// pixels are 255 in the returned mask, and excluded ones 0. Returns nil for a
+// nil mask.
+func GradMask(mask SippImage) SippImage {
+	if mask == nil {
+		return nil
+	}
+	mrect := mask.Bounds()
+	width, height := mrect.Dx()-1, mrect.Dy()-1
+	gmask := new(SippGray)
+	gmask.Gray = image.NewGray(image.Rect(0, 0, width, height))
+	gmaskPix := gmask.Pix()
+	i := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if mask.IntVal(x, y) != 0 && mask.IntVal(x+1, y) != 0 &&
+				mask.IntVal(x, y+1) != 0 && mask.IntVal(x+1, y+1) != 0 {
+				gmaskPix[i] = 255
+			}
+			i++
+		}
+	}
+	return gmask
+}
+
+// FdgradMasked computes a finite-differences gradient with the default kernel,
+// like Fdgrad, restricted to the region selected by the given mask for the
+// source image. Gradient pixels outside the region are set to 0, and are
+// excluded by the returned gradient mask, as computed by GradMask. Pass that
+// to shist.HistMasked to histogram only the region. Panics if the mask is not
+// the same size as the source image.
+func FdgradMasked(src, mask SippImage) (grad *ComplexImage, gmask SippImage) {
+	gmask, included := gradMaskFor(src, mask)
+	return fdgradKernel(src, defaultKernel, included), gmask
+}
+
+// FdgradInt32Masked is the ComplexInt32 version of FdgradMasked.
+func FdgradInt32Masked(src, mask SippImage) (grad *ComplexInt32Image, gmask SippImage) {
+	gmask, included := gradMaskFor(src, mask)
+	return fdgradInt32Kernel(src, defaultInt32Kernel, included), gmask
+}
+
+// gradMaskFor checks the source mask against the source image and returns the
+// gradient mask and its included pixels.
+func gradMaskFor(src, mask SippImage) (gmask SippImage, included []bool) {
+	if mask == nil {
+		return nil, nil
+	}
+	if mask.Bounds().Size() != src.Bounds().Size() {
+		panic("Mask and image sizes differ!")
+	}
+	gmask = GradMask(mask)
+	return gmask, MaskIncluded(gmask, gmask.Bounds().Size())
+}
@@ -6,6 +6,7 @@ package sgrad
 
 import (
 	//"fmt"
+	"image"
 	"math"
 	"reflect"
 	"testing"
@@ -318,3 +319,191 @@ func TestFdgradKernelInt32(t *testing.T) {
 			ComplexInt32ArrayToString(kierGrad.Pix, 3))
 	}
 }
+
+func TestFdgradMasked(t *testing.T) {
+	// Exclude the left column and the bottom-right pixel of the 4x4 source.
+	mask := new(SippGray)
+	mask.Gray = image.NewGray(Sgray.Bounds())
+	maskPix := mask.Pix()
+	for i := range maskPix {
+		if i%4 != 0 && i != 15 {
+			maskPix[i] = 1
+		}
+	}
+	expectedMask := []uint8{
+		0, 255, 255,
+		0, 255, 255,
+		0, 255, 0,
+	}
+	gmask := GradMask(mask)
+	if !reflect.DeepEqual(gmask.Pix(), expectedMask) {
+		t.Errorf("Error: gradient mask incorrect. Expected %v, got %v",
+			expectedMask, gmask.Pix())
+	}
+	grad, gmask := FdgradMasked(Sgray, mask)
+	grad32, gmask32 := FdgradInt32Masked(Sgray, mask)
+	if !reflect.DeepEqual(gmask.Pix(), expectedMask) ||
+		!reflect.DeepEqual(gmask32.Pix(), expectedMask) {
+		t.Error("Error: masked gradient returned incorrect mask")
+	}
+	for i, m := range expectedMask {
+		var expected complex128
+		if m != 0 {
+			expected = smallPicGrad[i]
+		}
+		if grad.Pix[i] != expected {
+			t.Errorf("Error: masked gradient pixel %d is %v, expected %v", i,
+				grad.Pix[i], expected)
+		}
+		if grad32.Pix[i] != (ComplexInt32{int32(real(expected)), int32(imag(expected))}) {
+			t.Errorf("Error: masked int32 gradient pixel %d is %v, expected %v",
+				i, grad32.Pix[i], expected)
+		}
+	}
+	// The zeros are included in the extremes, so rendering works.
+	if grad.MaxRe != 5 || grad.MinRe != 0 || grad.MinIm != -3 || grad.MaxIm != 0 {
+		t.Errorf("Error: masked gradient extremes incorrect: %v", grad)
+	}
+
+	// No mask is the same as Fdgrad.
+	grad, gmask = FdgradMasked(Sgray, nil)
+	if gmask != nil || !reflect.DeepEqual(grad, Fdgrad(Sgray)) {
+		t.Error("Error: gradient with nil mask differs from Fdgrad")
+	}
+}
+
+func TestIntegrate(t *testing.T) {
+	const tol = 1e-9
+	src := ToFloat(SgrayCosxCosyTiny)
+	check := func(name string, rec *FloatImage, want []float64) {
+		if len(rec.Pix) != len(want) {
+			t.Errorf("Error: %s has %d pixels, expected %d", name,
+				len(rec.Pix), len(want))
+			return
+		}
+		for i, val := range rec.Pix {
+			if math.Abs(val-want[i]) > tol {
+				t.Errorf("Error: %s pixel %d is %v, expected %v", name, i, val, want[i])
+				return
+			}
+		}
+	}
+
+	// The Neumann solution is exact for any image, from either gradient.
+	rec := IntegrateDCT(Fdgrad(SgrayCosxCosyTiny))
+	MatchLatticeMeans(rec, SgrayCosxCosyTiny)
+	check("DCT round trip", rec, src.Pix)
+	rec = IntegrateDCT(FdgradInt32(SgrayCosxCosyTiny))
+	MatchLatticeMeans(rec, SgrayCosxCosyTiny)
+	check("DCT integer round trip", rec, src.Pix)
+
+	// Without matching, each lattice has zero mean.
+	rec = IntegrateDCT(Fdgrad(SgrayCosxCosyTiny))
+	if even, odd := latticeMeans(rec); math.Abs(even) > tol || math.Abs(odd) > tol {
+		t.Errorf("Error: unmatched lattice means %v, %v", even, odd)
+	}
+
+	// The periodic solution is exact for a periodic image, whose last row
+	// and column repeat its first.
+	const period = 6
+	tile := []uint8{
+		3, 9, 4, 1, 7, 2,
+		8, 0, 5, 6, 2, 9,
+		1, 4, 7, 3, 8, 5,
+		6, 2, 9, 0, 4, 7,
+	}
+	per := new(SippGray)
+	per.Gray = image.NewGray(image.Rect(0, 0, period+1, 5))
+	want := make([]float64, 0, (period+1)*5)
+	for y := 0; y < 5; y++ {
+		for x := 0; x <= period; x++ {
+			val := tile[(y%4)*period+x%period]
+			per.Gray.Pix[y*(period+1)+x] = val
+			want = append(want, float64(val))
+		}
+	}
+	rec = IntegrateFFT(Fdgrad(per))
+	MatchLatticeMeans(rec, per)
+	check("FFT round trip", rec, want)
+	rec = IntegrateDCT(Fdgrad(per))
+	MatchLatticeMeans(rec, per)
+	check("DCT round trip of periodic image", rec, want)
+
+	// A ramp isn't periodic, so its gradient isn't consistent with a
+	// periodic one.
+	rec = IntegrateFFT(Fdgrad(Sgray))
+	MatchLatticeMeans(rec, Sgray)
+	ramp := ToFloat(Sgray)
+	var maxErr float64
+	for i, val := range rec.Pix {
+		maxErr = math.Max(maxErr, math.Abs(val-ramp.Pix[i]))
+	}
+	if maxErr < 1 {
+		t.Errorf("Error: periodic integration of a non-periodic image has "+
+			"maximum error %v", maxErr)
+	}
+}
+
+func TestSecondOrder(t *testing.T) {
+	// A quadratic has a constant Hessian and Laplacian.
+	const width, height = 5, 4
+	quad := NewFloatImage(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x), float64(y)
+			quad.Pix[y*width+x] = fx*fx + 3*fx*fy - 2*fy*fy
+		}
+	}
+	hess := Hessian(quad)
+	lap4 := Laplacian4(quad)
+	if hess.Xx.Rect != image.Rect(0, 0, width-2, height-2) {
+		t.Errorf("Error: Hessian bounds %v", hess.Xx.Rect)
+	}
+	for i := range hess.Xx.Pix {
+		if hess.Xx.Pix[i] != 2 || hess.Xy.Pix[i] != 3 || hess.Yy.Pix[i] != -4 ||
+			lap4.Pix[i] != -2 {
+			t.Errorf("Error: pixel %d Hessian %v, %v, %v, Laplacian %v", i,
+				hess.Xx.Pix[i], hess.Xy.Pix[i], hess.Yy.Pix[i], lap4.Pix[i])
+		}
+	}
+
+	// The 8-neighbour Laplacian is the sum of the 4-neighbour one and the
+	// divergence of the gradient, in both the float and integer paths, and
+	// the curl of a gradient is 0.
+	lap4 = Laplacian4(SgrayCosxCosyTiny)
+	lap8 := Laplacian8(SgrayCosxCosyTiny)
+	dc := DivCurl(Fdgrad(SgrayCosxCosyTiny)).(*ComplexImage)
+	dcInt := DivCurl(FdgradInt32(SgrayCosxCosyTiny)).(*ComplexInt32Image)
+	if dc.Rect != lap8.Rect || dcInt.Rect != lap8.Rect {
+		t.Fatalf("Error: divergence bounds %v and %v, expected %v", dc.Rect,
+			dcInt.Rect, lap8.Rect)
+	}
+	for i, val := range lap8.Pix {
+		if val != lap4.Pix[i]+real(dc.Pix[i]) || imag(dc.Pix[i]) != 0 ||
+			dcInt.Pix[i] != (ComplexInt32{int32(real(dc.Pix[i])), 0}) {
+			t.Errorf("Error: pixel %d Laplacians %v, %v, divergence and curl %v, %v",
+				i, val, lap4.Pix[i], dc.Pix[i], dcInt.Pix[i])
+			break
+		}
+	}
+
+	// A rotating field, along the diagonals, has only curl, and an expanding
+	// one only divergence.
+	rot := FromComplexArray([]complex128{0 + 1i, 1 + 0i, -1 + 0i, 0 - 1i}, 2)
+	exp := FromComplexArray([]complex128{-1 + 0i, 0 + 1i, 0 - 1i, 1 + 0i}, 2)
+	if dc := DivCurl(rot).(*ComplexImage); dc.Pix[0] != 0+4i {
+		t.Errorf("Error: rotating field divergence and curl %v", dc.Pix[0])
+	}
+	if dc := DivCurl(exp).(*ComplexImage); dc.Pix[0] != 4+0i {
+		t.Errorf("Error: expanding field divergence and curl %v", dc.Pix[0])
+	}
+
+	// The LoG with no smoothing is the 4-neighbour Laplacian.
+	log := LoG(SgrayCosxCosyTiny, 0)
+	if !reflect.DeepEqual(log.Pix, lap4.Pix) {
+		t.Errorf("Error: unsmoothed LoG %v, expected %v", log.Pix, lap4.Pix)
+	}
+	if smoothed := LoG(SgrayCosxCosyTiny, 2); smoothed.Max-smoothed.Min >= lap4.Max-lap4.Min {
+		t.Errorf("Error: smoothing didn't reduce the LoG range")
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright Raul Vera 2015-2021
+
+package shist
+
+import (
+	"errors"
+	"math"
+)
+
+import (
+	. "github.com/Causticity/sipp/simage"
+)
+
+// The functions below remap the grey levels of an image through cumulative
+// distributions computed from its grey-level histogram, to enhance its
+// contrast. They work on 8 and 16-bit images at full resolution, and return
+// images of the same depth.
+
+// cumulative returns the cumulative distribution of the histogram, the number
+// of values at or below each level.
+func cumulative(hist []uint32) []uint64 {
+	cdf := make([]uint64, len(hist))
+	var sum uint64
+	for i, count := range hist {
+		sum += uint64(count)
+		cdf[i] = sum
+	}
+	return cdf
+}
+
+// remap returns a new image of the given depth with each pixel replaced by its
+// value in the mapping, rounded.
+func remap(im SippImage, mapping []float64, bpp int) SippImage {
+	rect := im.Bounds()
+	width, height := rect.Dx(), rect.Dy()
+	vals := make([]float64, 0, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			vals = append(vals, mapping[im.IntVal(rect.Min.X+x, rect.Min.Y+y)])
+		}
+	}
+	return FromFloatArray(vals, width).Quantise(bpp)
+}
+
+// Equalise returns the image with its histogram equalised, so that its grey
+// levels are spread as evenly as possible over the full range of its depth.
+// Each level maps to the fraction of the pixels at or below it, excluding
+// those at the lowest level present, so that the lowest level maps to 0 and
+// the highest to the largest value.
+func Equalise(im SippImage) SippImage {
+	return EqualiseMasked(im, nil)
+}
+
+// EqualiseMasked is Equalise with the mapping computed from only those pixels
+// selected by the given mask, which must be the same size as the image, and
+// applied to every pixel. A nil mask includes every pixel.
+func EqualiseMasked(im, mask SippImage) SippImage {
+	hist := GreyHistMasked(im, mask)
+	cdf := cumulative(hist)
+	maxVal := float64(len(hist) - 1)
+	var lowest uint64
+	for _, count := range hist {
+		if count != 0 {
+			lowest = uint64(count)
+			break
+		}
+	}
+	total := cdf[len(cdf)-1]
+	mapping := make([]float64, len(hist))
+	if total > lowest {
+		for i, sum := range cdf {
+			if sum >= lowest {
+				mapping[i] = maxVal * float64(sum-lowest) / float64(total-lowest)
+			}
+		}
+	}
+	return remap(im, mapping, im.Bpp())
+}
+
+// MatchHistogram returns the image with its grey levels remapped so that its
+// histogram matches that of the reference image as closely as possible. Each
+// level maps to the lowest level of the reference at which the reference's
+// cumulative distribution reaches the image's cumulative distribution at that
+// level. The images need not be the same size, and the result has the depth
+// of the reference.
+func MatchHistogram(im, ref SippImage) SippImage {
+	cdf := cumulative(GreyHist(im))
+	refCdf := cumulative(GreyHist(ref))
+	total := float64(cdf[len(cdf)-1])
+	refTotal := float64(refCdf[len(refCdf)-1])
+	mapping := make([]float64, len(cdf))
+	// Both distributions increase, so the matching reference level does too.
+	j := 0
+	for i, sum := range cdf {
+		frac := float64(sum) / total
+		for j < len(refCdf)-1 && float64(refCdf[j])/refTotal < frac {
+			j++
+		}
+		mapping[i] = float64(j)
+	}
+	return remap(im, mapping, ref.Bpp())
+}
+
+// CLAHE returns the image enhanced by contrast-limited adaptive histogram
+// equalisation. The image is divided into a grid of tilesX by tilesY tiles,
+// each of which is equalised separately, with each level mapping to the
+// fraction of the tile's pixels at or below it. Each pixel is then mapped by
+// bilinear interpolation between the mappings of the four tiles whose centres
+// are nearest, so that there are no seams between tiles.
+//
+// To limit the amplification of noise in flat regions, each tile's histogram
+// is clipped to clipLimit times the mean count per grey level, taken over the
+// range of levels present in the image, and the excess is spread evenly over
+// that range. A larger limit gives more contrast, and a limit of 0 disables
+// clipping, giving plain adaptive histogram equalisation. A limit of 1 or
+// less that isn't 0 would leave the histograms flat and is invalid.
+//
+// Returns an error if the grid has fewer than one tile or more tiles than
+// pixels along either axis, or the clip limit is invalid.
+func CLAHE(im SippImage, tilesX, tilesY int, clipLimit float64) (SippImage, error) {
+	rect := im.Bounds()
+	width, height := rect.Dx(), rect.Dy()
+	if tilesX < 1 || tilesY < 1 || tilesX > width || tilesY > height {
+		return nil, errors.New("Invalid CLAHE tile grid for the image size!")
+	}
+	if clipLimit != 0 && !(clipLimit > 1) {
+		return nil, errors.New("CLAHE clip limit must be 0 or greater than 1!")
+	}
+	vals := make([]int32, 0, width*height)
+	lo, hi := int32(math.MaxInt32), int32(math.MinInt32)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			val := im.IntVal(rect.Min.X+x, rect.Min.Y+y)
+			vals = append(vals, val)
+			if val < lo {
+				lo = val
+			}
+			if val > hi {
+				hi = val
+			}
+		}
+	}
+	numLevels := int(hi-lo) + 1
+	maxVal := float64(int32(1)<<uint(im.Bpp()) - 1)
+
+	// The mappings of the tiles, for the levels from lo to hi.
+	mappings := make([][]float64, tilesX*tilesY)
+	hist := make([]float64, numLevels)
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			for i := range hist {
+				hist[i] = 0
+			}
+			x0, x1 := tx*width/tilesX, (tx+1)*width/tilesX
+			y0, y1 := ty*height/tilesY, (ty+1)*height/tilesY
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					hist[vals[y*width+x]-lo]++
+				}
+			}
+			count := float64((x1 - x0) * (y1 - y0))
+			if clipLimit != 0 {
+				limit := clipLimit * count / float64(numLevels)
+				var excess float64
+				for i, h := range hist {
+					if h > limit {
+						excess += h - limit
+						hist[i] = limit
+					}
+				}
+				for i := range hist {
+					hist[i] += excess / float64(numLevels)
+				}
+			}
+			mapping := make([]float64, numLevels)
+			var sum float64
+			for i, h := range hist {
+				sum += h
+				mapping[i] = maxVal * sum / count
+			}
+			mappings[ty*tilesX+tx] = mapping
+		}
+	}
+
+	// The tile and interpolation weight along an axis for a pixel position,
+	// given as the tile and the weight of the next one. Pixels before the
+	// first tile centre or after the last use only that tile.
+	locate := func(pos float64, size, tiles int) (int, float64) {
+		// Tile centres are at (i + 0.5) * size / tiles, in units in which
+		// pixel centres are at their index plus 0.5.
+		t := (pos+0.5)*float64(tiles)/float64(size) - 0.5
+		if t <= 0 {
+			return 0, 0
+		}
+		if t >= float64(tiles-1) {
+			return tiles - 1, 0
+		}
+		i := int(t)
+		return i, t - float64(i)
+	}
+	res := make([]float64, len(vals))
+	for y := 0; y < height; y++ {
+		ty, wy := locate(float64(y), height, tilesY)
+		ty1 := ty
+		if wy > 0 {
+			ty1++
+		}
+		for x := 0; x < width; x++ {
+			tx, wx := locate(float64(x), width, tilesX)
+			tx1 := tx
+			if wx > 0 {
+				tx1++
+			}
+			level := vals[y*width+x] - lo
+			top := (1-wx)*mappings[ty*tilesX+tx][level] +
+				wx*mappings[ty*tilesX+tx1][level]
+			bottom := (1-wx)*mappings[ty1*tilesX+tx][level] +
+				wx*mappings[ty1*tilesX+tx1][level]
+			res[y*width+x] = (1-wy)*top + wy*bottom
+		}
+	}
+	return FromFloatArray(res, width).Quantise(im.Bpp()), nil
+}
@@ -20,8 +20,11 @@ type flatSippHist struct {
 	histCore
 	// The histogram data.
 	bin []uint32
-	// The index of the histogram bin for each gradient image pixel.
+	// The index of the histogram bin for each gradient image pixel, or -1 for
+	// pixels excluded by a mask.
 	binIndex []int
+	// The number of bins that have been used at least once.
+	numUsedBins uint32
 }
 
 // flatBinSize is the number of uint32s per histogram entry.
@@ -33,67 +36,88 @@ func flatSize(width, height int) int {
 	return width * height * flatBinSize
 }
 
-// Make a flat histogram from the given complex gradient image. The width and
-// height (of the histogram, not the image) are passed in to avoid recomputing
-// them, as they were needed to decide whether to use this histogram or the
-// sparse one.
-func makeFlatHist(grad *ComplexImage, width, height int) SippHist {
+// Make a flat histogram from the given gradient image, either a *ComplexImage
+// or a *ComplexInt32Image. The width and height (of the histogram, not the
+// image) are passed in to avoid recomputing them, as they were needed to decide
+// whether to use this histogram or the sparse one. Only the pixels for which
+// included is true are counted, unless it is nil.
+func makeFlatHist(grad SippComplexImage, width, height int, included []bool) SippHist {
 	hist := new(flatSippHist)
 	hist.grad = grad
 	hist.width = width
 	hist.height = height
 	histDataSize := hist.width * hist.height
 	hist.bin = make([]uint32, histDataSize)
-	hist.binIndex = make([]int, uint32(len(grad.Pix)))
-	//fmt.Println("Grad image pixels, and binIndex length:", len(grad.Pix))
-	var numUsedBins uint32
+	hist.binIndex = make([]int, numPix(grad))
+	//fmt.Println("Grad image pixels, and binIndex length:", numPix(grad))
 
 	// Walk through the image, computing the bin address from the gradient
-	// values, storing the bin address in binIndex, and incrementing the
-	// bin. Save the maximum bin value and count the number of actually used
-	// bins.
+	// values. Floating-point values are floored; integer values are used
+	// as they are, so no rounding is involved.
 	xoff := int((width-1)/2)
 	yoff := int((height-1)/2)
-	for i, pixel := range grad.Pix {
-		u := int(math.Floor(real(pixel))) + xoff
-		v := int(math.Floor(imag(pixel))) + yoff
-		hist.binIndex[i] = v*int(hist.width) + u
-		if hist.bin[hist.binIndex[i]] == 0 {
-			// First use of this bin, so count it
-			numUsedBins++
+	switch g := grad.(type) {
+	case *ComplexImage:
+		for i, pixel := range g.Pix {
+			if included != nil && !included[i] {
+				hist.binIndex[i] = -1
+				continue
+			}
+			u := int(math.Floor(real(pixel))) + xoff
+			v := int(math.Floor(imag(pixel))) + yoff
+			hist.addPixel(i, v*hist.width + u)
 		}
-		hist.bin[hist.binIndex[i]]++
-		if hist.bin[hist.binIndex[i]] > hist.max {
-			hist.max = hist.bin[hist.binIndex[i]]
+	case *ComplexInt32Image:
+		for i, pixel := range g.Pix {
+			if included != nil && !included[i] {
+				hist.binIndex[i] = -1
+				continue
+			}
+			u := int(pixel.Re) + xoff
+			v := int(pixel.Im) + yoff
+			hist.addPixel(i, v*hist.width + u)
 		}
+	default:
+		panic("Unsupported gradient image type!")
 	}
 
-	// numUsedBins is larger, or in the worst case equal, to the number of
-	// distinct bin values, so it can be used as the capacity of the slice of
-	// distinct bin values.
-	hist.bins = make([]BinPair, 0, numUsedBins)
+	hist.initBins(hist.numUsedBins)
 	for _, binval := range hist.bin {
-		hist.bins = addBinsValue(hist.bins, binval)
+		hist.addBinsValue(binval)
 	}
 	//fmt.Println("Histogram complete. Maximum bin value:", hist.Max)
 	return hist
 }
 
+// addPixel stores the bin address for gradient pixel i in binIndex and
+// increments the bin. It saves the maximum bin value and counts the number of
+// actually used bins.
+func (hist *flatSippHist) addPixel(i, address int) {
+	hist.binIndex[i] = address
+	hist.total++
+	if hist.bin[address] == 0 {
+		// First use of this bin, so count it
+		hist.numUsedBins++
+	}
+	hist.bin[address]++
+	if hist.bin[address] > hist.max {
+		hist.max = hist.bin[address]
+	}
+}
+
 // BinForPixel returns the bin index in the slice returned by Bins for the
 // given gradient-image pixel.
 func (hist *flatSippHist) BinForPixel(x, y int) (int) {
-	stride := hist.grad.Rect.Dx()
+	stride := hist.grad.Bounds().Dx()
 	index := y*stride+x
+	if hist.binIndex[index] < 0 {
+		return -1
+	}
 	//fmt.Printf("index into binIndex for pixel %d, %d is %d\n", x, y, index)
 	//fmt.Println("binIndex value at that index is ", hist.binIndex[index])
 	val := hist.bin[hist.binIndex[index]]
 	//fmt.Println("histogram value is ", val)
-	for i, binVal := range hist.bins {
-		if val == binVal.BinVal {
-			return i
-		}
-	}
-	panic("No bin found for pixel!");
+	return hist.binIndexFor(val)
 }
 
 // Implement the rowSource interface for rendering
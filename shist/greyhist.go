@@ -6,22 +6,35 @@ import (
 	. "github.com/Causticity/sipp/simage"
 )
 
-const greyHistSize8BPP = 256
-const greyHistSize16BPP = 65536
+// The sizes of the 1D histograms returned by GreyHist for 8 and 16-bit images.
+const GreyHistSize8BPP = 256
+const GreyHistSize16BPP = 65536
 
 // GreyHist computes a 1D histogram of the greyscale values in the image.
 func GreyHist(im SippImage) (hist []uint32) {
-	histSize := greyHistSize8BPP
+	return GreyHistMasked(im, nil)
+}
+
+// GreyHistMasked computes a 1D histogram of the greyscale values of only those
+// pixels selected by the given mask, which must be the same size as the image.
+// A nil mask includes every pixel.
+func GreyHistMasked(im SippImage, mask SippImage) (hist []uint32) {
+	included := MaskIncluded(mask, im.Bounds().Size())
+	histSize := GreyHistSize8BPP
 	is16 := false
 	if im.Bpp() == 16 {
-		histSize = greyHistSize16BPP
+		histSize = GreyHistSize16BPP
 		is16 = true
 	}
 
 	hist = make([]uint32, histSize)
 	imPix := im.Pix()
+	width := im.Bounds().Dx()
 	for y := 0; y < im.Bounds().Dy(); y++ {
-		for x := 0; x < im.Bounds().Dx(); x++ {
+		for x := 0; x < width; x++ {
+			if included != nil && !included[y*width+x] {
+				continue
+			}
 			index := im.PixOffset(x, y)
 			var val uint16 = uint16(imPix[index])
 			if is16 {
@@ -0,0 +1,100 @@
+// Copyright Raul Vera 2015-2021
+
+package shist
+
+import (
+	"errors"
+)
+
+import (
+	. "github.com/Causticity/sipp/simage"
+)
+
+// A SippJointHist is a 2D histogram of the pairs of grey values at the same
+// location in two equally sized images, A and B. Grey values are binned
+// linearly, so that, for example, 64 bins of an 8-bit image each cover 4
+// consecutive grey values.
+type SippJointHist struct {
+	// The number of bins for the values of each image. Bins for A vary along
+	// a row, and bins for B down a column.
+	BinsA, BinsB int
+	// The histogram data, BinsB rows of BinsA bins each.
+	Bin []uint32
+	// The total of all the bins, which is the number of pixels in either
+	// image.
+	Total uint32
+	// The maximum bin value in the histogram.
+	Max uint32
+}
+
+// The maximum number of bins per image in a joint histogram. This matches the
+// largest histogram that is rendered at full size.
+const maxJointBins = maxRenderExtent
+
+// JointHist computes the joint histogram of the given images, using binsA and
+// binsB bins for the grey values of a and b respectively. Each number of bins
+// must be at least 1, at most the number of grey levels of its image, and at
+// most 4096. Returns an error if the images differ in size or either number of
+// bins is invalid.
+func JointHist(a, b SippImage, binsA, binsB int) (*SippJointHist, error) {
+	rect := a.Bounds()
+	if rect.Dx() != b.Bounds().Dx() || rect.Dy() != b.Bounds().Dy() {
+		return nil, errors.New("Images for a joint histogram must be the same size!")
+	}
+	levelsA := uint64(1) << uint(a.Bpp())
+	levelsB := uint64(1) << uint(b.Bpp())
+	if binsA < 1 || binsB < 1 || uint64(binsA) > levelsA || uint64(binsB) > levelsB ||
+		binsA > maxJointBins || binsB > maxJointBins {
+		return nil, errors.New("Invalid number of bins for a joint histogram!")
+	}
+	hist := new(SippJointHist)
+	hist.BinsA = binsA
+	hist.BinsB = binsB
+	hist.Bin = make([]uint32, binsA*binsB)
+	brect := b.Bounds()
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			valA := uint64(a.IntVal(rect.Min.X+x, rect.Min.Y+y))
+			valB := uint64(b.IntVal(brect.Min.X+x, brect.Min.Y+y))
+			u := int(valA * uint64(binsA) / levelsA)
+			v := int(valB * uint64(binsB) / levelsB)
+			index := v*binsA + u
+			hist.Bin[index]++
+			if hist.Bin[index] > hist.Max {
+				hist.Max = hist.Bin[index]
+			}
+			hist.Total++
+		}
+	}
+	return hist, nil
+}
+
+// Marginals returns the 1D histograms of the binned values of A and B, which
+// are the sums of the columns and rows respectively of the joint histogram.
+func (hist *SippJointHist) Marginals() (histA, histB []uint32) {
+	histA = make([]uint32, hist.BinsA)
+	histB = make([]uint32, hist.BinsB)
+	for v := 0; v < hist.BinsB; v++ {
+		for u, val := range hist.rowVals(v) {
+			histA[u] += val
+			histB[v] += val
+		}
+	}
+	return
+}
+
+// Implement the rowSource interface for rendering
+// rowVals returns a slice containing the bin values for one complete row of
+// the histogram.
+func (hist *SippJointHist) rowVals(y int) []uint32 {
+	i := y * hist.BinsA
+	return hist.Bin[i : i+hist.BinsA]
+}
+
+// Render renders the joint histogram as an 8-bit image, with A increasing to
+// the right and B increasing downwards. If clip is true, values are clipped to
+// 255. If clip is false, values are scaled to 255.
+func (hist *SippJointHist) Render(clip bool) SippImage {
+	core := histCore{width: hist.BinsA, height: hist.BinsB, max: hist.Max}
+	return core.renderCore(hist, clip)
+}
@@ -0,0 +1,204 @@
+// Copyright Raul Vera 2015-2021
+
+package shist
+
+import (
+	"errors"
+	"math"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// PolarOptions specify the bins of a polar gradient histogram.
+type PolarOptions struct {
+	// The number of bins for the gradient magnitude, from 0 to the maximum
+	// modulus of the gradient.
+	RadialBins int
+	// The number of bins for the gradient orientation, dividing the full
+	// circle into equal angles, starting from the negative real axis.
+	AngularBins int
+	// If true, the radial bins are equally spaced in log(1 + magnitude)
+	// rather than in magnitude, giving finer bins for small gradients.
+	LogRadial bool
+}
+
+// A SippPolarHist is a 2D histogram of a gradient image, binned by the
+// magnitude and orientation of each gradient value rather than its real and
+// imaginary parts. It implements SippHist, so it can be used wherever a
+// Cartesian histogram is, such as computing delentropy. Its rows are radial
+// bins, starting from 0 at the top, and its columns are angular bins, so
+// renderings show orientation across and magnitude down.
+//
+// Zero gradients have no orientation. They are counted in the first angular
+// bin of the first radial bin in the 2D histogram, but are excluded from the
+// orientation histogram returned by AngularHist.
+type SippPolarHist struct {
+	// Embed the core fields.
+	histCore
+	// The options the histogram was computed with.
+	opts PolarOptions
+	// The histogram data, RadialBins rows of AngularBins bins each.
+	bin []uint32
+	// The index of the histogram bin for each gradient image pixel.
+	binIndex []int
+	// The histogram of the orientations of non-zero gradients.
+	angular []uint32
+}
+
+// PolarHist computes a polar histogram of the given gradient image, either a
+// *ComplexImage or a *ComplexInt32Image. Returns an error if either number of
+// bins is less than 1 or greater than 4096.
+func PolarHist(grad SippComplexImage, opts PolarOptions) (*SippPolarHist, error) {
+	return PolarHistMasked(grad, nil, opts)
+}
+
+// PolarHistMasked computes the polar histogram of only those pixels of the
+// gradient image selected by the given mask, which must be the same size as
+// the gradient image, as for HistMasked. The radial bins still span the
+// maximum modulus of the whole gradient image. A nil mask includes every
+// pixel.
+func PolarHistMasked(grad SippComplexImage, mask SippImage,
+	opts PolarOptions) (*SippPolarHist, error) {
+	if opts.RadialBins < 1 || opts.AngularBins < 1 ||
+		opts.RadialBins > maxRenderExtent || opts.AngularBins > maxRenderExtent {
+		return nil, errors.New("Invalid number of bins for a polar histogram!")
+	}
+	hist := new(SippPolarHist)
+	hist.grad = grad
+	hist.opts = opts
+	hist.width = opts.AngularBins
+	hist.height = opts.RadialBins
+	hist.bin = make([]uint32, hist.width*hist.height)
+	hist.binIndex = make([]int, numPix(grad))
+	hist.angular = make([]uint32, opts.AngularBins)
+
+	// The radial scale maps the maximum modulus, linearly or logarithmically,
+	// to the number of radial bins.
+	maxMod := grad.MaxModulus()
+	if opts.LogRadial {
+		maxMod = math.Log1p(maxMod)
+	}
+	radialScale := 0.0
+	if maxMod > 0 {
+		radialScale = float64(opts.RadialBins) / maxMod
+	}
+	angularScale := float64(opts.AngularBins) / (2 * math.Pi)
+
+	included := MaskIncluded(mask, grad.Bounds().Size())
+	var numUsedBins uint32
+	for i, pt := range grad.Values() {
+		if included != nil && !included[i] {
+			hist.binIndex[i] = -1
+			continue
+		}
+		hist.total++
+		mod := math.Hypot(real(pt), imag(pt))
+		if opts.LogRadial {
+			mod = math.Log1p(mod)
+		}
+		r := int(mod * radialScale)
+		if r >= opts.RadialBins {
+			// Only the maximum itself can land here.
+			r = opts.RadialBins - 1
+		}
+		a := 0
+		if pt != 0 {
+			// An angle of pi is the same as -pi, so it wraps around to
+			// the first bin.
+			a = int((math.Atan2(imag(pt), real(pt)) + math.Pi) * angularScale)
+			a %= opts.AngularBins
+			hist.angular[a]++
+		}
+		index := r*hist.width + a
+		hist.binIndex[i] = index
+		if hist.bin[index] == 0 {
+			numUsedBins++
+		}
+		hist.bin[index]++
+		if hist.bin[index] > hist.max {
+			hist.max = hist.bin[index]
+		}
+	}
+
+	hist.initBins(numUsedBins)
+	for _, binval := range hist.bin {
+		hist.addBinsValue(binval)
+	}
+	return hist, nil
+}
+
+// Options returns the options the histogram was computed with.
+func (hist *SippPolarHist) Options() PolarOptions {
+	return hist.opts
+}
+
+// AngularHist returns the 1D histogram of the orientations of the non-zero
+// gradients, with the same angular bins as the 2D histogram.
+func (hist *SippPolarHist) AngularHist() []uint32 {
+	return hist.angular
+}
+
+// BinForPixel returns the bin index in the slice returned by Bins for the
+// given gradient-image pixel, or -1 if the pixel was excluded by a mask.
+func (hist *SippPolarHist) BinForPixel(x, y int) int {
+	stride := hist.grad.Bounds().Dx()
+	index := hist.binIndex[y*stride+x]
+	if index < 0 {
+		return -1
+	}
+	return hist.binIndexFor(hist.bin[index])
+}
+
+// Implement the rowSource interface for rendering
+// rowVals returns a slice containing the bin values for one complete row of
+// the histogram.
+func (hist *SippPolarHist) rowVals(y int) []uint32 {
+	i := y * hist.width
+	return hist.bin[i : i+hist.width]
+}
+
+// Render renders the histogram into an 8-bit grayscale image. If clip is true,
+// values are clipped to 255. If clip is false, values are scaled to 255.
+func (hist *SippPolarHist) Render(clip bool) SippImage {
+	return hist.renderCore(hist, clip)
+}
+
+// RenderSuppressed renders a suppressed version of the histogram and returns
+// the result as an 8-bit grayscale image. Each bin value is scaled by the
+// ratio of the centre of its radial bin over the centre of the last radial
+// bin, so the zero-gradient spike in the first row is reduced in the same way
+// as at the centre of a Cartesian histogram.
+func (hist *SippPolarHist) RenderSuppressed() SippImage {
+	rnd, _, _ := hist.renderInto()
+	rndPix := rnd.Pix()
+	suppressed := make([]float64, len(hist.bin))
+	var maxSuppressed float64
+	maxCentre := float64(hist.height) - 0.5
+	for row := 0; row < hist.height; row++ {
+		sscale := (float64(row) + 0.5) / maxCentre
+		for x, val := range hist.rowVals(row) {
+			index := row*hist.width + x
+			suppressed[index] = float64(val) * sscale
+			if suppressed[index] > maxSuppressed {
+				maxSuppressed = suppressed[index]
+			}
+		}
+	}
+	if maxSuppressed > 0 {
+		pixScale := 255.0 / maxSuppressed
+		for index, val := range suppressed {
+			rndPix[index] = uint8(val * pixScale)
+		}
+	}
+	return rnd
+}
+
+// RenderSubstitute renders an 8-bit image of the histogram, substituting
+// the given value as the pixel value for each corresponding bin value. See
+// SippHist.
+func (hist *SippPolarHist) RenderSubstitute(subs []uint8, zeroVal uint8) SippImage {
+	return hist.renderSubstituteCore(hist, subs, zeroVal)
+}
@@ -9,6 +9,7 @@ import (
 	"image"
 	"fmt"
 	"math"
+	"sort"
 )
 
 import (
@@ -26,19 +27,23 @@ type BinPair struct {
 
 // A SippHist is a 2D histogram.
 type SippHist interface {
-	// Grad returns the gradient image that this histogram is computed from.
-	Grad() (*ComplexImage)
+	// Grad returns the gradient image that this histogram is computed from,
+	// either a *ComplexImage or a *ComplexInt32Image.
+	Grad() (SippComplexImage)
 	// Size returns the width and height of the full histogram. ints are used
 	// instead of uint32s for compatibility with native Go pixel indexing.
 	Size() (int, int)
 	// Max returns the maximum bin value that occurs in this histogram
 	Max() (uint32)
+	// Total returns the number of gradient pixels counted in the histogram,
+	// which is all of them unless the histogram was computed with a mask.
+	Total() (int)
 	// Bins returns a compact slice of BinPairs for the histogram, without
 	// duplicates. There is no order specified, but each call to Bins returns
 	// the values in the same order.
 	Bins() ([]BinPair)
 	// BinForPixel returns the index in the slice returned by Bins for the
-	// given gradient-image pixel.
+	// given gradient-image pixel, or -1 if the pixel was excluded by a mask.
 	BinForPixel(x, y int) (int)
 	// Render returns a rendering of this histogram as an 8-bit image.  If clip
 	// is true, values are clipped to 255. If clip is false, values are scaled
@@ -65,27 +70,32 @@ type SippHist interface {
 // Internally, a 2D histogram can be either "flat", meaning that storage exists
 // for every possible bin, or sparse, meaning that storage is allocated in a map
 // based on actually occurring values. Which to use is based on criteria
-// described below.
-// The above interface hides the distinction from clients.
+// described below. Either can be computed from a complex128 or a ComplexInt32
+// gradient; the flat histogram handles both, while there is a sparse histogram
+// for each, keyed by the corresponding pixel type.
+// The above interface hides the distinctions from clients.
 
 // The core elements that either version includes.
 type histCore struct {
 	// A reference to the gradient image we are computing from
-	grad *ComplexImage
+	grad SippComplexImage
 	// Width and height of the histogram, not the image.
 	// These should be odd so that there is always a centre point.
 	width, height int
 	// The maximum bin value in the histogram.
 	max uint32
+	// The number of gradient pixels counted.
+	total int
 	// The set of bin values that actually occur, and the number of their
 	// occurrences.
 	bins []BinPair
-	// The inverse of bins. For a given bin value, stores the index in bins.
-	// Lazily initialised as needed.
-	invertedBins map[uint32]int
+	// The inverse of bins. Keyed by bin value, it stores the index in bins
+	// of each value that occurs. A map is used because the bin values can be
+	// as large as the number of pixels while the distinct values are few.
+	binForVal map[uint32]int
 }
 
-func (hist *histCore) Grad() (*ComplexImage) {
+func (hist *histCore) Grad() (SippComplexImage) {
 	return hist.grad
 }
 
@@ -97,6 +107,10 @@ func (hist *histCore) Max() (uint32) {
 	return hist.max
 }
 
+func (hist *histCore) Total() (int) {
+	return hist.total
+}
+
 func (hist *histCore) Bins() ([]BinPair) {
 	return hist.bins
 }
@@ -107,9 +121,10 @@ func (hist *histCore) Bins() ([]BinPair) {
 // are odd so that there is always a single central bin in both dimensions. The
 // returned overall maximum excursion is the maximum of the maximum excursions
 // for each axis.
-func computeHistSize(grad *ComplexImage) (maxExcursion, width, height int) {
-	maxRealExcursion := int(math.Max(math.Abs(grad.MaxRe), math.Abs(grad.MinRe)))
-	maxImagExcursion := int(math.Max(math.Abs(grad.MaxIm), math.Abs(grad.MinIm)))
+func computeHistSize(grad SippComplexImage) (maxExcursion, width, height int) {
+	minRe, maxRe, minIm, maxIm := grad.Extremes()
+	maxRealExcursion := int(math.Max(math.Abs(maxRe), math.Abs(minRe)))
+	maxImagExcursion := int(math.Max(math.Abs(maxIm), math.Abs(minIm)))
 	maxExcursion = int(math.Max(float64(maxRealExcursion), float64(maxImagExcursion)))
 
 	width = maxRealExcursion * 2 + 1 // Ensure both are odd
@@ -125,8 +140,22 @@ func computeHistSize(grad *ComplexImage) (maxExcursion, width, height int) {
 // plane, is always positive.
 const minSparseExcursion = 1024
 
-// Hist computes the 2D histogram from the given gradient image.
-func Hist(grad *ComplexImage) (hist SippHist) {
+// Hist computes the 2D histogram from the given gradient image, which must be
+// either a *ComplexImage or a *ComplexInt32Image. Integer gradients are binned
+// exactly, so a ComplexInt32Image and a ComplexImage holding the same values
+// produce the same histogram.
+func Hist(grad SippComplexImage) (hist SippHist) {
+	return HistMasked(grad, nil)
+}
+
+// HistMasked computes the 2D histogram of only those pixels of the gradient
+// image selected by the given mask, which must be the same size as the
+// gradient image. See sgrad.GradMask for computing a gradient mask from a mask
+// of the source image. The histogram is otherwise the same as that computed by
+// Hist, including its size, which is determined by the extremes of the whole
+// gradient image. A nil mask includes every pixel.
+func HistMasked(grad SippComplexImage, mask SippImage) (hist SippHist) {
+	included := MaskIncluded(mask, grad.Bounds().Size())
 	maxExcursion, width, height := computeHistSize(grad)
 	// The following sizes are number of uint32s for the histogram.
 	flatHistSize := flatSize(width, height)
@@ -137,36 +166,67 @@ func Hist(grad *ComplexImage) (hist SippHist) {
 	if maxExcursion > minSparseExcursion && maxSparseSize < flatHistSize {
 		// Use a sparse histogram
 		fmt.Println("Using sparse histogram")
-		hist = makeSparseHist(grad, width, height)
+		hist = makeSparseHist(grad, width, height, included)
 	} else {
 		// Use a flat histogram
 		fmt.Println("Using flat histogram")
-		hist = makeFlatHist(grad, width, height)
+		hist = makeFlatHist(grad, width, height, included)
 	}
 	return
 }
 
-// addBinsValue adds to the bins slice, either by incrementing the Num of the
-// relevant entry if the value is already in the slice, or appending a new entry
-// if it isn't. Returns the slice, or a new one if append is used.
-func addBinsValue(bins []BinPair, binval uint32) []BinPair{
-	var found bool
-	if binval != 0 {
-		found = false
-		//fmt.Printf("looking for binval %d\n", binval)
-		for i, pair := range bins {
-			if binval == pair.BinVal {
-				//fmt.Println("Found it, incrementing pair.num")
-				bins[i].Num++
-				found = true
-			}
-		}
-		if found == false {
-			//fmt.Printf("Appending pair for binval %d\n", binval)
-			bins = append(bins, BinPair{binval, 1})
-		}
+// numPix returns the number of pixels in the given gradient image.
+func numPix(grad SippComplexImage) int {
+	return grad.Bounds().Dx() * grad.Bounds().Dy()
+}
+
+// initBins allocates the bins slice and its inverse, ready to be filled by
+// addBinsValue. The maximum bin value must already have been computed. The
+// number of used bins is larger than, or in the worst case equal to, the number
+// of distinct bin values, so it is used as the capacity of the bins slice.
+func (hist *histCore) initBins(numUsedBins uint32) {
+	hist.bins = make([]BinPair, 0, numUsedBins)
+	hist.binForVal = make(map[uint32]int)
+}
+
+// addBinsValue adds a bin value to the bins slice, either by incrementing the
+// Num of the relevant entry if the value is already in the slice, or appending
+// a new entry if it isn't. The entry is found through binForVal, so this takes
+// constant expected time. Empty bins are ignored.
+func (hist *histCore) addBinsValue(binval uint32) {
+	if binval == 0 {
+		return
+	}
+	index, ok := hist.binForVal[binval]
+	if !ok {
+		hist.binForVal[binval] = len(hist.bins)
+		hist.bins = append(hist.bins, BinPair{binval, 1})
+	} else {
+		hist.bins[index].Num++
+	}
+}
+
+// binIndexFor returns the index in bins of the entry for the given bin value,
+// or -1 if the value doesn't occur, as for 0.
+func (hist *histCore) binIndexFor(binval uint32) int {
+	index, ok := hist.binForVal[binval]
+	if !ok {
+		return -1
+	}
+	return index
+}
+
+// sortBins sorts the bins slice by bin value and rebuilds binForVal to match.
+// Sparse histograms fill bins by iterating over a map, whose order is random,
+// so they sort them afterwards to make the order, and hence any sums computed
+// over the bins, the same from run to run.
+func (hist *histCore) sortBins() {
+	sort.Slice(hist.bins, func(i, j int) bool {
+		return hist.bins[i].BinVal < hist.bins[j].BinVal
+	})
+	for index, pair := range hist.bins {
+		hist.binForVal[pair.BinVal] = index
 	}
-	return bins
 }
 
 // The maximum size of either dimension of a rendering of a histogram. If
@@ -188,7 +248,7 @@ func (hist *histCore) renderInto() (rnd *SippGray, scale, pixScale float64) {
 	width, height = hist.Size()
 	if (width <= maxRenderExtent && height <= maxRenderExtent) {
 		scale = 1.0
-	} else if hist.width > maxRenderExtent {
+	} else if hist.width >= hist.height {
 		width = maxRenderExtent
 		scale = float64(hist.width)/float64(maxRenderExtent)
 		height = int(float64(hist.height)/scale)
@@ -197,13 +257,20 @@ func (hist *histCore) renderInto() (rnd *SippGray, scale, pixScale float64) {
 		scale = float64(hist.height)/float64(maxRenderExtent)
 		width = int(float64(hist.width)/scale)
 	}
+	// A narrow histogram still needs a pixel across.
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
 	rnd.Gray = image.NewGray(image.Rect(0, 0, int(width), int(height)))
 	pixScale = 255.0 / float64(hist.max)
 	return
 }
 
 // A rowSource provides a method for returning a complete row of the histogram.
-// Both histogram types, flat and sparse, implement this method, which is used
+// The flat, polar and joint histograms implement this method, which is used
 // for rendering.
 type rowSource interface {
 	// rowVals returns a slice containing the bin values for one complete row of
@@ -211,41 +278,68 @@ type rowSource interface {
 	rowVals(y int) []uint32
 }
 
+// A binSource provides the non-zero bins of a histogram directly, for
+// histograms that don't store complete rows. The sparse histogram implements
+// this method, which rendering uses in preference to rowVals.
+type binSource interface {
+	// forBins calls f with the position and value of each non-zero bin.
+	forBins(f func(x, y int, val uint32))
+}
+
+// forBins calls f with the position and value of each non-zero bin of the
+// histogram, using the row source's own forBins if it is also a binSource.
+func (hist *histCore) forBins(rs rowSource, f func(x, y int, val uint32)) {
+	if bs, ok := rs.(binSource); ok {
+		bs.forBins(f)
+		return
+	}
+	for row := 0; row < hist.height; row++ {
+		for x, val := range rs.rowVals(row) {
+			if val != 0 {
+				f(x, row, val)
+			}
+		}
+	}
+}
+
+// renderIndex returns the index of the pixel covering the bin at x, y in a
+// rendering of the given size, made with the given scale from renderInto.
+func renderIndex(x, y int, scale float64, width, height int) int {
+	if scale != 1.0 {
+		x = int(float64(x) / scale)
+		y = int(float64(y) / scale)
+		if x >= width {
+			x = width - 1
+		}
+		if y >= height {
+			y = height - 1
+		}
+	}
+	return y*width + x
+}
+
 // renderCore renders the histogram into an 8-bit grayscale image. The calling
 // histogram provides itself as the row source. If clip is true, values are
-// clipped to 255. If clip is false, values are scaled to 255.
+// clipped to 255. If clip is false, values are scaled to 255. Where several
+// bins fall in one pixel, as in a scaled-down rendering, the largest is shown.
 func (hist *histCore) renderCore(rs rowSource, clip bool) SippImage {
 	rnd, scale, pixScale := hist.renderInto()
 	rndPix := rnd.Pix()
+	width, height := rnd.Bounds().Dx(), rnd.Bounds().Dy()
 	//fmt.Println("Render pixel scale factor:", pixScale)
-	if scale == 1.0  {
-		for row := 0; row < hist.height; row++ {
-			histRow := rs.rowVals(row)
-			for index, val := range histRow {
-				if clip {
-					if val > 255 {
-						val = 255
-					}
-				} else {
-					val = uint32(math.Round(float64(val) * pixScale))
-				}
-				rndPix[row*hist.width+index] = uint8(val)
+	hist.forBins(rs, func(x, y int, val uint32) {
+		if clip {
+			if val > 255 {
+				val = 255
 			}
+		} else {
+			val = uint32(math.Round(float64(val) * pixScale))
 		}
-	} else {
-		// Render by rows to ensure that the intermediate fits in memory
-		// Use a block as wide as the output image but only as tall as one
-		// vertical filter. Fill this block, generate the output row, then
-		// roll the block.
-		// Allocate the intermediate block as a ring buffer of rows
-		// precompute both filters
-		// fill the first row of the ring buffer by filtering horizontally
-		// for each ouput row
-			// fill the remaining rows of the ring buffer by filtering horizontally
-			// filter the buffer vertically into the output row
-			// discard all the rows except the last (if the filter is non-zero!)
-			// make the last row the first
-	}
+		index := renderIndex(x, y, scale, width, height)
+		if uint8(val) > rndPix[index] {
+			rndPix[index] = uint8(val)
+		}
+	})
 	return rnd
 }
 
@@ -260,81 +354,66 @@ func supScale(x, y int, centx, centy, maxDist float64) float64 {
 }
 
 // RenderSuppressed renders a suppressed version of the histogram and returns
-// the result as an 8-bit grayscale image.
+// the result as an 8-bit grayscale image. Where several bins fall in one
+// pixel, the largest suppressed value is shown.
 func (hist *histCore) renderSuppressedCore(rs rowSource) SippImage {
-	rnd, filterScale, _ := hist.renderInto()
-	rndWidth := rnd.Bounds().Dx()
-	rndHeight := rnd.Bounds().Dy()
-	var suppressed []float64
+	rnd, scale, _ := hist.renderInto()
+	width, height := rnd.Bounds().Dx(), rnd.Bounds().Dy()
 	var maxSuppressed float64
-	size := int(rndWidth) * int(rndHeight)
-	suppressed = make([]float64, size)
+	suppressed := make([]float64, width*height)
 	centx := (float64(hist.width)-1)/2
 	centy := (float64(hist.height)-1)/2
+	maxMod := hist.grad.MaxModulus()
 
-	if filterScale == 1.0 {
-		var idx int = 0
-		for row := 0; row < int(hist.height); row++ {
-			histRow := rs.rowVals(row)
-			for x, val := range histRow {
-				sscale := supScale(x, row, centx, centy, hist.grad.MaxMod)
-				suppressed[idx] = float64(val) * sscale
-				if suppressed[idx] > maxSuppressed {
-					maxSuppressed = suppressed[idx]
-				}
-				idx++
-			}
+	hist.forBins(rs, func(x, y int, val uint32) {
+		sval := float64(val) * supScale(x, y, centx, centy, maxMod)
+		index := renderIndex(x, y, scale, width, height)
+		if sval > suppressed[index] {
+			suppressed[index] = sval
 		}
+		if sval > maxSuppressed {
+			maxSuppressed = sval
+		}
+	})
+	if maxSuppressed > 0 {
 		var pixScale float64 = 255.0 / maxSuppressed
 		//fmt.Println("Suppressed Render pixScale factor:", pixScale)
 		rndPix := rnd.Pix()
 		for index, val := range suppressed {
 			rndPix[index] = uint8(val * pixScale)
 		}
-	} else {
-		// Scale while rendering
 	}
 	return rnd
 }
 
-// setupInvertedBins populates the invertedBins map for the given histogram.
-// invertedBins stores the index in the bins slice for each occurring histogram
-// value.
-// Used to lazily populate invertedBins when needed.
-func setupInvertedBins(bins []BinPair) (invertedBins map[uint32]int) {
-	invertedBins = make(map[uint32]int, len(bins))
-	for index, val := range bins {
-		invertedBins[val.BinVal] = index
-	}
-	return
-}
-
 // RenderSubstituteCore renders an 8-bit image of the histogram, substituting
 // the given value as the pixel value for each corresponding bin value. The
 // input slice must be the same length as the slice of bin values returned
 // by Bins, and contain new values corresponding to that order.
 // This is used to render the delentropy values of the histogram.
 // Note that as the slice returned by Bins() does not include 0 values,
-// the value to be used for empty bins must be supplied.
+// the value to be used for empty bins must be supplied. Where several bins
+// fall in one pixel, the largest substitute is shown.
 func (hist *histCore) renderSubstituteCore(rs rowSource, subs []uint8, zeroVal uint8) SippImage {
-	if hist.invertedBins == nil {
-		hist.invertedBins = setupInvertedBins(hist.bins)
-	}
 	rnd, scale, _ := hist.renderInto()
 	rndPix := rnd.Pix()
-	if scale == 1.0  {
-		for row := 0; row < hist.height; row++ {
-			histRow := rs.rowVals(row)
-			for index, val := range histRow {
-				if val == 0 {
-					rndPix[row*hist.width+index] = zeroVal;
-				} else {
-					rndPix[row*hist.width+index]= subs[hist.invertedBins[val]]
-				}
-			}
-		}
-	} else {
-		// Scale while rendering
+	width, height := rnd.Bounds().Dx(), rnd.Bounds().Dy()
+	for index := range rndPix {
+		rndPix[index] = zeroVal
 	}
+	// Whether each pixel has had a bin substituted yet, as zeroVal may be
+	// larger than the substitutes.
+	set := make([]bool, len(rndPix))
+	hist.forBins(rs, func(x, y int, val uint32) {
+		binIndex, ok := hist.binForVal[val]
+		if !ok {
+			panic("Histogram bin value has no entry in bins!")
+		}
+		index := renderIndex(x, y, scale, width, height)
+		if !set[index] || subs[binIndex] > rndPix[index] {
+			rndPix[index] = subs[binIndex]
+			set[index] = true
+		}
+	})
 	return rnd
 }
@@ -5,10 +5,14 @@
 package shist
 
 import (
+	"image"
+	"image/color"
 	_ "image/png"
 	"math"
+	"math/rand"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 	"fmt"
 )
@@ -45,6 +49,121 @@ func TestGreyHist(t *testing.T) {
 	checkHist(t, hist)
 }
 
+// equaliseExpected checks that each pixel of the result is the given function
+// of the source pixel.
+func equaliseExpected(t *testing.T, name string, src, res SippImage,
+	want func(val int32) int32) {
+	size := src.Bounds().Size()
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			exp := want(src.IntVal(x, y))
+			if res.IntVal(x, y) != exp {
+				t.Fatalf("Error: %s pixel %d,%d is %d, expected %d", name, x, y,
+					res.IntVal(x, y), exp)
+			}
+		}
+	}
+}
+
+func TestEqualise(t *testing.T) {
+	// The 16 distinct levels are spread evenly over the whole range.
+	equaliseExpected(t, "equalised", Sgray, Equalise(Sgray), func(val int32) int32 {
+		return 17 * (val - 1)
+	})
+	eq16 := Equalise(Sgray16)
+	if eq16.Bpp() != 16 {
+		t.Errorf("Error: equalised 16-bit image has depth %d", eq16.Bpp())
+	}
+	equaliseExpected(t, "equalised 16-bit", Sgray16, eq16, func(val int32) int32 {
+		return 4369 * (val - 1)
+	})
+	// A mask excluding the 8 lowest levels maps them to 0.
+	mask := new(SippGray)
+	mask.Gray = image.NewGray(Sgray.Bounds())
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if Sgray.IntVal(x, y) > 8 {
+				mask.Gray.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	equaliseExpected(t, "masked equalised", Sgray, EqualiseMasked(Sgray, mask),
+		func(val int32) int32 {
+			if val <= 9 {
+				return 0
+			}
+			return int32(math.Round(255 * float64(val-9) / 7))
+		})
+
+	// Matching an image to itself changes nothing, and matching it to its
+	// equalisation equalises it, including across depths.
+	equaliseExpected(t, "self-matched", SgrayCosxCosyTiny,
+		MatchHistogram(SgrayCosxCosyTiny, SgrayCosxCosyTiny), func(val int32) int32 {
+			return val
+		})
+	equaliseExpected(t, "matched", Sgray, MatchHistogram(Sgray, eq16),
+		func(val int32) int32 {
+			return 4369 * (val - 1)
+		})
+}
+
+func TestCLAHE(t *testing.T) {
+	// With a single tile and no clipping, each level maps to the fraction of
+	// the pixels at or below it.
+	res, err := CLAHE(Sgray, 1, 1, 0)
+	if err != nil {
+		t.Fatal("Error computing CLAHE:", err)
+	}
+	tile := func(val int32) int32 {
+		return int32(math.Round(255 * float64(val) / 16))
+	}
+	equaliseExpected(t, "single tile CLAHE", Sgray, res, tile)
+
+	// With four identical tiles, the interpolation between them gives the
+	// same mapping everywhere.
+	tiled := new(SippGray)
+	tiled.Gray = image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			tiled.Gray.SetGray(x, y, color.Gray{uint8(Sgray.IntVal(x%4, y%4))})
+		}
+	}
+	res, err = CLAHE(tiled, 2, 2, 0)
+	if err != nil {
+		t.Fatal("Error computing CLAHE:", err)
+	}
+	equaliseExpected(t, "tiled CLAHE", tiled, res, tile)
+
+	// Clipping a histogram of two levels, 10 and 20, spreads the excess
+	// over the 11 levels from 10 to 20, reducing the contrast.
+	two := new(SippGray16)
+	two.Gray16 = image.NewGray16(image.Rect(0, 0, 4, 4))
+	for i := 0; i < 16; i++ {
+		val := uint16(10)
+		if i >= 12 {
+			val = 20
+		}
+		two.Gray16.SetGray16(i%4, i/4, color.Gray16{val})
+	}
+	res, err = CLAHE(two, 1, 1, 1.1)
+	if err != nil {
+		t.Fatal("Error computing CLAHE:", err)
+	}
+	limit := 1.1 * 16 / 11
+	excess := 12 - limit + 4 - limit
+	want := int32(math.Round(65535 * (limit + excess/11) / 16))
+	if res.Bpp() != 16 || res.IntVal(0, 0) != want || res.IntVal(3, 3) != 65535 {
+		t.Errorf("Error: clipped CLAHE levels are %d and %d, expected %d and "+
+			"65535", res.IntVal(0, 0), res.IntVal(3, 3), want)
+	}
+
+	for _, bad := range [][3]float64{{0, 1, 0}, {1, 5, 0}, {1, 1, 1}, {1, 1, -2}} {
+		if _, err := CLAHE(Sgray, int(bad[0]), int(bad[1]), bad[2]); err == nil {
+			t.Errorf("Error: CLAHE with %v didn't fail", bad)
+		}
+	}
+}
+
 // Next test the two 2D histograms.
 
 var cosxCosyTinyBinIndex = []int{
@@ -186,6 +305,15 @@ func TestHistCore(t *testing.T) {
 			t.Errorf("Error: supscale incorrect, expected %f, got %f", test.exp, scale)
 		}
 	}
+
+	// A gradient of a single pixel, as of a 2x2 image, can be histogrammed.
+	single := FromComplexArray([]complex128{3 + 4i}, 1)
+	if size := maxSparseHistSize(single); size != 2*sparseHistogramEntrySize {
+		t.Errorf("Error: maximum sparse size of a single pixel is %d", size)
+	}
+	if total := Hist(single).Total(); total != 1 {
+		t.Errorf("Error: histogram of a single pixel has total %d", total)
+	}
 }
 
 type binIndexTest struct {
@@ -286,7 +414,7 @@ func TestFlatHist(t *testing.T) {
 			}
 		}
 
-		numPix := uint32(len(hist.Grad().Pix))
+		numPix := uint32(len(grad.Pix))
 		fmt.Printf("There are %d pixels\n", numPix)
 
 		var totalBins uint32
@@ -298,11 +426,19 @@ func TestFlatHist(t *testing.T) {
 				totalBins, numPix)
 		}
 
-		hist.invertedBins = setupInvertedBins(hist.bins)
-		if !reflect.DeepEqual(hist.invertedBins, test.invertedBins) {
-		    t.Errorf("Error: inverted bins map incorrect.\nExpected\n%v\n got\n%v\n",
-		        test.invertedBins, hist.invertedBins)
-	    }
+		if !reflect.DeepEqual(hist.binForVal, test.invertedBins) {
+			t.Errorf("Error: binForVal for %s incorrect, expected\n%v\n got\n%v\n",
+				test.name, test.invertedBins, hist.binForVal)
+		}
+		// Values that don't occur, such as 0, have no bin rather than bin 0.
+		if index := hist.binIndexFor(0); index != -1 {
+			t.Errorf("Error: bin index for value 0 in %s is %d, expected -1",
+				test.name, index)
+		}
+		if index := hist.binIndexFor(numPix + 1); index != -1 {
+			t.Errorf("Error: bin index for absent value in %s is %d, expected -1",
+				test.name, index)
+		}
 
 	    // Check the polymorphic API
 		bins := hist.Bins()
@@ -389,6 +525,373 @@ func TestFlatHist(t *testing.T) {
 	}
 }
 
+// toComplexInt32 converts an integer-valued complex128 slice to ComplexInt32s.
+func toComplexInt32(cpx []complex128) []ComplexInt32 {
+	res := make([]ComplexInt32, len(cpx))
+	for i, c := range cpx {
+		res[i] = ComplexInt32{int32(real(c)), int32(imag(c))}
+	}
+	return res
+}
+
+// binValsForPixels returns the bin value for every gradient pixel, as found
+// through BinForPixel.
+func binValsForPixels(hist SippHist) []uint32 {
+	rect := hist.Grad().Bounds()
+	bins := hist.Bins()
+	vals := make([]uint32, 0, rect.Dx()*rect.Dy())
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			vals = append(vals, bins[hist.BinForPixel(x, y)].BinVal)
+		}
+	}
+	return vals
+}
+
+func TestInt32Hist(t *testing.T) {
+	grad := FromComplexArray(CosxCosyTinyGrad, CosxCosyTinyStride-1)
+	grad32 := FromComplexInt32Array(toComplexInt32(CosxCosyTinyGrad),
+		CosxCosyTinyStride-1)
+
+	hist32, ok := Hist(grad32).(*flatSippHist)
+	if !ok {
+		t.Fatal("Error: histogram of ComplexInt32 gradient is not flat")
+	}
+	if hist32.Grad() != grad32 {
+		t.Errorf("Error: SippHist has incorrect grad, expected %v, got %v",
+			grad32, hist32.Grad())
+	}
+	width, height := hist32.Size()
+	if width != cosxCosyTinyHistWidth || height != cosxCosyTinyHistHeight {
+		t.Errorf("Error: ComplexInt32 histogram size incorrect, expected %dx%d, got %dx%d",
+			cosxCosyTinyHistWidth, cosxCosyTinyHistHeight, width, height)
+	}
+	if !reflect.DeepEqual(hist32.binIndex, cosxCosyTinyBinIndex) {
+		t.Errorf("Error: ComplexInt32 hist.binIndex incorrect, expected\n%v\n got\n%v\n",
+			cosxCosyTinyBinIndex, hist32.binIndex)
+	}
+	if !reflect.DeepEqual(hist32.Bins(), cosxCosyTinyBins) {
+		t.Errorf("Error: ComplexInt32 hist.Bins() incorrect, expected\n%v\n got\n%v\n",
+			cosxCosyTinyBins, hist32.Bins())
+	}
+	if !reflect.DeepEqual(binValsForPixels(hist32), cosxCosyTinyBinVals) {
+		t.Errorf("Error: ComplexInt32 bin values for pixels incorrect, expected\n%v\n got\n%v\n",
+			cosxCosyTinyBinVals, binValsForPixels(hist32))
+	}
+
+	// The sparse histograms of both types must agree with each other and
+	// with the flat one, and have their bins sorted by value.
+	width, height = cosxCosyTinyHistWidth, cosxCosyTinyHistHeight
+	sparseTests := []struct {
+		name string
+		hist SippHist
+	}{
+		{"sparse complex128", makeSparseHist(grad, width, height, nil)},
+		{"sparse ComplexInt32", makeSparseHist(grad32, width, height, nil)},
+	}
+	sortedBins := []BinPair{
+		{1, 12}, {2, 78}, {4, 13}, {5, 5}, {6, 18}, {8, 1},
+	}
+	for _, test := range sparseTests {
+		if test.hist.Max() != expectedMax {
+			t.Errorf("Error: hist.Max for %s incorrect. Expected %v, got %v",
+				test.name, expectedMax, test.hist.Max())
+		}
+		if !reflect.DeepEqual(test.hist.Bins(), sortedBins) {
+			t.Errorf("Error: hist.Bins() for %s incorrect, expected\n%v\n got\n%v\n",
+				test.name, sortedBins, test.hist.Bins())
+		}
+		if !reflect.DeepEqual(binValsForPixels(test.hist), cosxCosyTinyBinVals) {
+			t.Errorf("Error: bin values for pixels for %s incorrect, expected\n%v\n got\n%v\n",
+				test.name, cosxCosyTinyBinVals, binValsForPixels(test.hist))
+		}
+		// Sparse histograms render from their bins, the same as flat ones.
+		for _, clip := range []bool{true, false} {
+			if !reflect.DeepEqual(test.hist.Render(clip).Pix(), hist32.Render(clip).Pix()) {
+				t.Errorf("Error: %s rendering with clip %v differs from flat", test.name, clip)
+			}
+		}
+		if !reflect.DeepEqual(test.hist.RenderSuppressed().Pix(),
+			hist32.RenderSuppressed().Pix()) {
+			t.Errorf("Error: %s suppressed rendering differs from flat", test.name)
+		}
+		if !reflect.DeepEqual(test.hist.RenderSubstitute(binValSubs(test.hist), 7).Pix(),
+			hist32.RenderSubstitute(binValSubs(hist32), 7).Pix()) {
+			t.Errorf("Error: %s substitute rendering differs from flat", test.name)
+		}
+	}
+
+	// A histogram wider than maxRenderExtent is rendered scaled down, with
+	// each pixel showing the largest bin that falls in it.
+	// The first two bins, holding 1 and 2, fall in the first pixel.
+	wide := FromComplexArray([]complex128{-3000, -2999, -2999, 3000}, 2)
+	_, width, height = computeHistSize(wide)
+	for _, hist := range []SippHist{
+		makeFlatHist(wide, width, height, nil),
+		makeSparseHist(wide, width, height, nil),
+	} {
+		rnd := hist.Render(false)
+		if rnd.Bounds() != image.Rect(0, 0, maxRenderExtent, 1) {
+			t.Fatalf("Error: scaled rendering bounds %v", rnd.Bounds())
+		}
+		pix := rnd.Pix()
+		for i, val := range pix {
+			want := uint8(0)
+			switch i {
+			case 0:
+				want = 255
+			case maxRenderExtent - 1:
+				want = 128
+			}
+			if val != want {
+				t.Errorf("Error: scaled rendering pixel %d is %d, expected %d",
+					i, val, want)
+			}
+		}
+	}
+	// A histogram taller than it is wide is scaled by its height.
+	tall := FromComplexArray([]complex128{-3000i, 3000i, 2000, 0}, 2)
+	_, width, height = computeHistSize(tall)
+	rnd := makeSparseHist(tall, width, height, nil).Render(true)
+	if rnd.Bounds().Dy() != maxRenderExtent || rnd.Bounds().Dx() >= maxRenderExtent {
+		t.Errorf("Error: tall scaled rendering bounds %v", rnd.Bounds())
+	}
+}
+
+// binValSubs returns substitutes for the bins of the histogram that depend
+// only on the bin values, so that histograms with their bins in different
+// orders can be compared.
+func binValSubs(hist SippHist) []uint8 {
+	subs := make([]uint8, len(hist.Bins()))
+	for i, bin := range hist.Bins() {
+		subs[i] = uint8(bin.BinVal * 30)
+	}
+	return subs
+}
+
+func TestHistMasked(t *testing.T) {
+	grad := FromComplexArray([]complex128{1, 1, 2i, 1, 0, 2i}, 3)
+	grad32 := FromComplexInt32Array(toComplexInt32(grad.Pix), 3)
+	mask := new(SippGray)
+	mask.Gray = image.NewGray(grad.Rect)
+	copy(mask.Pix(), []uint8{1, 0, 7, 1, 0, 0})
+	_, width, height := computeHistSize(grad)
+	tests := []struct {
+		name string
+		hist SippHist
+	}{
+		{"flat", HistMasked(grad, mask)},
+		{"flat ComplexInt32", HistMasked(grad32, mask)},
+		{"sparse complex128", makeSparseHist(grad, width, height,
+			MaskIncluded(mask, grad.Rect.Size()))},
+		{"sparse ComplexInt32", makeSparseHist(grad32, width, height,
+			MaskIncluded(mask, grad.Rect.Size()))},
+	}
+	// Two 1s and one 2i are included. Excluded pixels have no bin value.
+	expectedBins := []BinPair{{1, 1}, {2, 1}}
+	expectedBinVals := []uint32{2, 0, 1, 2, 0, 0}
+	for _, test := range tests {
+		if test.hist.Total() != 3 {
+			t.Errorf("Error: masked %s histogram total %d, expected 3",
+				test.name, test.hist.Total())
+		}
+		if test.hist.Max() != 2 {
+			t.Errorf("Error: masked %s histogram max %d, expected 2",
+				test.name, test.hist.Max())
+		}
+		bins := append([]BinPair(nil), test.hist.Bins()...)
+		sort.Slice(bins, func(i, j int) bool { return bins[i].BinVal < bins[j].BinVal })
+		if !reflect.DeepEqual(bins, expectedBins) {
+			t.Errorf("Error: masked %s histogram bins %v, expected %v",
+				test.name, bins, expectedBins)
+		}
+		for i, exp := range expectedBinVals {
+			var val uint32
+			if bin := test.hist.BinForPixel(i%3, i/3); bin >= 0 {
+				val = test.hist.Bins()[bin].BinVal
+			}
+			if val != exp {
+				t.Errorf("Error: masked %s histogram bin value for pixel %d "+
+					"is %d, expected %d", test.name, i, val, exp)
+			}
+		}
+	}
+	if total := Hist(grad).Total(); total != 6 {
+		t.Errorf("Error: unmasked histogram total %d, expected 6", total)
+	}
+
+	// The grey histogram counts only the masked pixels.
+	greyMask := new(SippGray)
+	greyMask.Gray = image.NewGray(Sgray.Bounds())
+	greyMask.Pix()[0] = 1
+	greyMask.Pix()[5] = 1
+	hist := GreyHistMasked(Sgray, greyMask)
+	var total uint32
+	for _, val := range hist {
+		total += val
+	}
+	if total != 2 || hist[Sgray.IntVal(0, 0)] == 0 || hist[Sgray.IntVal(1, 1)] == 0 {
+		t.Errorf("Error: masked grey histogram incorrect, total %d", total)
+	}
+}
+
+func TestJointHist(t *testing.T) {
+	// The small test image with itself, at full resolution, has a 1 on the
+	// diagonal for each of the values 1 to 16.
+	joint, err := JointHist(Sgray, Sgray, 256, 256)
+	if err != nil {
+		t.Fatalf("Error computing joint histogram: %v", err)
+	}
+	if joint.Total != 16 || joint.Max != 1 {
+		t.Errorf("Error: joint histogram total, max incorrect. Expected 16, 1, got %v, %v",
+			joint.Total, joint.Max)
+	}
+	for v := 0; v < 256; v++ {
+		for u := 0; u < 256; u++ {
+			var exp uint32
+			if u == v && u >= 1 && u <= 16 {
+				exp = 1
+			}
+			if joint.Bin[v*256+u] != exp {
+				t.Errorf("Error: joint histogram at %d, %d incorrect. Expected %v, got %v",
+					u, v, exp, joint.Bin[v*256+u])
+			}
+		}
+	}
+	histA, histB := joint.Marginals()
+	checkHist(t, histA)
+	checkHist(t, histB)
+
+	// The 8-bit image against the 16-bit one with the same values, in 4 bins
+	// each. All of the 16-bit values are in the lowest bin, and the 8-bit
+	// values 1 to 16 are all below 64, so everything is in the first bin.
+	joint, err = JointHist(Sgray, Sgray16, 4, 4)
+	if err != nil {
+		t.Fatalf("Error computing joint histogram: %v", err)
+	}
+	if !reflect.DeepEqual(joint.Bin, []uint32{16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("Error: 4x4 joint histogram incorrect, got %v", joint.Bin)
+	}
+	rnd := joint.Render(false)
+	if !reflect.DeepEqual(rnd.Pix(), []uint8{255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("Error: rendered 4x4 joint histogram incorrect, got %v", rnd.Pix())
+	}
+
+	// Errors
+	if _, err = JointHist(Sgray, SgrayCosxCosyTiny, 16, 16); err == nil {
+		t.Error("Error: joint histogram of different sized images should fail")
+	}
+	for _, bins := range [][2]int{{0, 16}, {16, 0}, {257, 16}, {16, 4097}} {
+		if _, err = JointHist(Sgray, Sgray16, bins[0], bins[1]); err == nil {
+			t.Errorf("Error: joint histogram with %v bins should fail", bins)
+		}
+	}
+}
+
+func TestPolarHist(t *testing.T) {
+	// One pixel in each direction along the axes, and a zero. With 4 angular
+	// bins starting from the negative real axis, -1, -i, 1, and i fall in
+	// bins 0 to 3 of the outer radial bin, and 0 in the first bin of the
+	// inner one.
+	axes := []complex128{1, 1i, -1, -1i, 0}
+	for _, logRadial := range []bool{false, true} {
+		opts := PolarOptions{RadialBins: 2, AngularBins: 4, LogRadial: logRadial}
+		for _, grad := range []SippComplexImage{
+			FromComplexArray(axes, 5),
+			FromComplexInt32Array(toComplexInt32(axes), 5),
+		} {
+			hist, err := PolarHist(grad, opts)
+			if err != nil {
+				t.Fatalf("Error computing polar histogram: %v", err)
+			}
+			if hist.Options() != opts {
+				t.Errorf("Error: polar histogram options incorrect. Expected %v, got %v",
+					opts, hist.Options())
+			}
+			width, height := hist.Size()
+			if width != 4 || height != 2 {
+				t.Errorf("Error: polar histogram size incorrect. Expected 4x2, got %dx%d",
+					width, height)
+			}
+			if !reflect.DeepEqual(hist.bin, []uint32{1, 0, 0, 0, 1, 1, 1, 1}) {
+				t.Errorf("Error: polar histogram incorrect, got %v", hist.bin)
+			}
+			if !reflect.DeepEqual(hist.binIndex, []int{6, 7, 4, 5, 0}) {
+				t.Errorf("Error: polar histogram bin indices incorrect, got %v", hist.binIndex)
+			}
+			if !reflect.DeepEqual(hist.AngularHist(), []uint32{1, 1, 1, 1}) {
+				t.Errorf("Error: polar angular histogram incorrect, got %v", hist.AngularHist())
+			}
+			if !reflect.DeepEqual(hist.Bins(), []BinPair{{1, 5}}) {
+				t.Errorf("Error: polar histogram bins incorrect, got %v", hist.Bins())
+			}
+			rnd := hist.Render(false)
+			if !reflect.DeepEqual(rnd.Pix(), []uint8{255, 0, 0, 0, 255, 255, 255, 255}) {
+				t.Errorf("Error: rendered polar histogram incorrect, got %v", rnd.Pix())
+			}
+			// The inner radial bin centre is a third of the outer one.
+			supp := hist.RenderSuppressed()
+			if !reflect.DeepEqual(supp.Pix(), []uint8{85, 0, 0, 0, 255, 255, 255, 255}) {
+				t.Errorf("Error: suppressed polar histogram incorrect, got %v", supp.Pix())
+			}
+		}
+	}
+
+	// On a real gradient, every pixel is counted once, and BinForPixel finds
+	// the value of the bin the pixel is in.
+	grad := FromComplexArray(CosxCosyTinyGrad, CosxCosyTinyStride-1)
+	hist, err := PolarHist(grad, PolarOptions{RadialBins: 8, AngularBins: 16})
+	if err != nil {
+		t.Fatalf("Error computing polar histogram: %v", err)
+	}
+	var total, angularTotal, zeros uint32
+	for _, pair := range hist.Bins() {
+		total += pair.BinVal * pair.Num
+	}
+	for _, val := range hist.AngularHist() {
+		angularTotal += val
+	}
+	for _, pix := range CosxCosyTinyGrad {
+		if pix == 0 {
+			zeros++
+		}
+	}
+	if total != uint32(len(CosxCosyTinyGrad)) || angularTotal != total-zeros {
+		t.Errorf("Error: polar histogram totals incorrect. Expected %d and %d, got %d and %d",
+			len(CosxCosyTinyGrad), len(CosxCosyTinyGrad)-int(zeros), total, angularTotal)
+	}
+	bins := hist.Bins()
+	for i, index := range hist.binIndex {
+		x, y := i%(CosxCosyTinyStride-1), i/(CosxCosyTinyStride-1)
+		if bins[hist.BinForPixel(x, y)].BinVal != hist.bin[index] {
+			t.Errorf("Error: polar histogram bin for pixel %d, %d incorrect", x, y)
+		}
+	}
+
+	// A mask excludes the first two axis pixels, leaving the radial bins
+	// unchanged.
+	mask := &SippGray{image.NewGray(image.Rect(0, 0, 5, 1))}
+	copy(mask.Gray.Pix, []uint8{0, 0, 1, 1, 1})
+	masked, err := PolarHistMasked(FromComplexArray(axes, 5), mask,
+		PolarOptions{RadialBins: 2, AngularBins: 4})
+	if err != nil {
+		t.Fatalf("Error computing masked polar histogram: %v", err)
+	}
+	if !reflect.DeepEqual(masked.bin, []uint32{1, 0, 0, 0, 1, 1, 0, 0}) ||
+		!reflect.DeepEqual(masked.AngularHist(), []uint32{1, 1, 0, 0}) ||
+		masked.Total() != 3 || masked.BinForPixel(1, 0) != -1 ||
+		masked.BinForPixel(2, 0) != 0 {
+		t.Errorf("Error: masked polar histogram incorrect, got %v, angular %v, total %d",
+			masked.bin, masked.AngularHist(), masked.Total())
+	}
+
+	for _, opts := range []PolarOptions{{0, 16, false}, {16, 0, false}, {4097, 16, true}} {
+		if _, err = PolarHist(grad, opts); err == nil {
+			t.Errorf("Error: polar histogram with options %v should fail", opts)
+		}
+	}
+}
+
 // Invert the bins slice to be black on white.
 func blackToWhite(bins []BinPair, max uint32) (white []uint8, zero uint8) {
 	white = make([]uint8, len(bins))
@@ -404,4 +907,157 @@ func blackToWhite(bins []BinPair, max uint32) (white []uint8, zero uint8) {
 func TestSparseHist(t *testing.T) {
 	t.Error("sparse test unimplemented")
 }
-*/
\ No newline at end of file
+*/
+
+// Benchmarks comparing the bin bookkeeping against the original linear-scan
+// implementation, which is retained here for reference.
+
+// linearAddBinsValue is the original implementation of addBinsValue, which
+// scans the whole bins slice for every histogram cell.
+func linearAddBinsValue(bins []BinPair, binval uint32) []BinPair {
+	var found bool
+	if binval != 0 {
+		found = false
+		for i, pair := range bins {
+			if binval == pair.BinVal {
+				bins[i].Num++
+				found = true
+			}
+		}
+		if found == false {
+			bins = append(bins, BinPair{binval, 1})
+		}
+	}
+	return bins
+}
+
+// linearBinForVal is the original search used by BinForPixel, which scans
+// the bins slice for every pixel.
+func linearBinForVal(bins []BinPair, val uint32) int {
+	for i, binVal := range bins {
+		if val == binVal.BinVal {
+			return i
+		}
+	}
+	panic("No bin found for pixel!")
+}
+
+const benchSide = 512
+
+// benchGrad returns a pseudo-random gradient image with a Gaussian spread of
+// values, so that the bin counts resemble those of a natural image. The spread
+// is chosen by the caller to select a flat or a sparse histogram.
+func benchGrad(spread float64) *ComplexImage {
+	rnd := rand.New(rand.NewSource(1))
+	cpx := make([]complex128, benchSide*benchSide)
+	for i := range cpx {
+		cpx[i] = complex(math.Round(rnd.NormFloat64()*spread),
+			math.Round(rnd.NormFloat64()*spread))
+	}
+	return FromComplexArray(cpx, benchSide)
+}
+
+const flatBenchSpread = 20.0
+const sparseBenchSpread = 2000.0
+
+func BenchmarkFlatHist(b *testing.B) {
+	grad := benchGrad(flatBenchSpread)
+	_, width, height := computeHistSize(grad)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		makeFlatHist(grad, width, height, nil)
+	}
+}
+
+func BenchmarkSparseHist(b *testing.B) {
+	grad := benchGrad(sparseBenchSpread)
+	_, width, height := computeHistSize(grad)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		makeSparseHist(grad, width, height, nil)
+	}
+}
+
+func BenchmarkFlatBins(b *testing.B) {
+	grad := benchGrad(flatBenchSpread)
+	_, width, height := computeHistSize(grad)
+	hist := makeFlatHist(grad, width, height, nil).(*flatSippHist)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hist.initBins(uint32(len(hist.bins)))
+		for _, binval := range hist.bin {
+			hist.addBinsValue(binval)
+		}
+	}
+}
+
+func BenchmarkFlatBinsLinear(b *testing.B) {
+	grad := benchGrad(flatBenchSpread)
+	_, width, height := computeHistSize(grad)
+	hist := makeFlatHist(grad, width, height, nil).(*flatSippHist)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bins := make([]BinPair, 0, len(hist.bins))
+		for _, binval := range hist.bin {
+			bins = linearAddBinsValue(bins, binval)
+		}
+	}
+}
+
+func BenchmarkFlatBinForPixel(b *testing.B) {
+	grad := benchGrad(flatBenchSpread)
+	_, width, height := computeHistSize(grad)
+	hist := makeFlatHist(grad, width, height, nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for y := 0; y < benchSide; y++ {
+			for x := 0; x < benchSide; x++ {
+				hist.BinForPixel(x, y)
+			}
+		}
+	}
+}
+
+func BenchmarkFlatBinForPixelLinear(b *testing.B) {
+	grad := benchGrad(flatBenchSpread)
+	_, width, height := computeHistSize(grad)
+	hist := makeFlatHist(grad, width, height, nil).(*flatSippHist)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for y := 0; y < benchSide; y++ {
+			for x := 0; x < benchSide; x++ {
+				val := hist.bin[hist.binIndex[y*benchSide+x]]
+				linearBinForVal(hist.bins, val)
+			}
+		}
+	}
+}
+
+func BenchmarkSparseBinForPixel(b *testing.B) {
+	grad := benchGrad(sparseBenchSpread)
+	_, width, height := computeHistSize(grad)
+	hist := makeSparseHist(grad, width, height, nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for y := 0; y < benchSide; y++ {
+			for x := 0; x < benchSide; x++ {
+				hist.BinForPixel(x, y)
+			}
+		}
+	}
+}
+
+func BenchmarkSparseBinForPixelLinear(b *testing.B) {
+	grad := benchGrad(sparseBenchSpread)
+	_, width, height := computeHistSize(grad)
+	hist := makeSparseHist(grad, width, height, nil).(*sparseSippHist)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for y := 0; y < benchSide; y++ {
+			for x := 0; x < benchSide; x++ {
+				val := hist.sparse[grad.Pix[y*benchSide+x]]
+				linearBinForVal(hist.bins, val)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ package shist
 
 import (
 	//"fmt"
+	"math"
 	"math/bits"
 )
 import (
@@ -14,11 +15,21 @@ import (
 // A sparseSippHist is a 2-dimensional histogram of the values in a complex
 // gradient image, stored sparsely to conserve memory. This is useful for
 // 16-bit and deeper images, as a full flat histogram would require 2^17^2 bins.
+// It holds either kind of gradient image, keyed by the values returned by
+// Values. These are exact for a ComplexInt32Image, as every int32 is exactly
+// representable as a float64, so integer gradients are still binned exactly.
 type sparseSippHist struct {
 	// Embed the core fields
 	histCore
 	// The histogram data
 	sparse map[complex128]uint32
+	// The index in bins for each gradient image pixel. Looking up a pixel's
+	// bin in the map is relatively expensive, so this is lazily initialised
+	// on the first call to BinForPixel, after which each lookup is a slice
+	// access.
+	pixBins []int
+	// The pixels counted, if the histogram was computed with a mask.
+	included []bool
 }
 
 // sparseHistogramEntrySize is the number of uint32s per histogram entry.
@@ -57,22 +68,32 @@ func upToNextPowerOf2(n uint32) uint32 {
 
 // maxSparseHistSize returns the maximum size of a sparse histogram for the
 // given image, in uint32s.
-func maxSparseHistSize(grad *ComplexImage) int {
+func maxSparseHistSize(grad SippComplexImage) int {
 	// The maximum size of a sparse histogram is one sparseHistogramEntry per
 	// gradient pixel, but Go maps always have a power of 2 number of entries.
 	// See the comment for sparseHistogramEntrySize above.
-	return int(sparseHistogramEntrySize * upToNextPowerOf2(uint32(len(grad.Pix))))
+	// A map of a single entry still takes a bucket, so count at least 2.
+	n := uint32(numPix(grad))
+	if n < 2 {
+		n = 2
+	}
+	return int(sparseHistogramEntrySize * upToNextPowerOf2(n))
 }
 
-func makeSparseHist(grad *ComplexImage, width, height int) SippHist {
+func makeSparseHist(grad SippComplexImage, width, height int, included []bool) SippHist {
 	// A sparse histogram is a map of actually occurring values.
 	hist := new(sparseSippHist)
 	hist.grad = grad
 	hist.width = width
 	hist.height = height
+	hist.included = included
 	hist.sparse = make(map[complex128]uint32)
 	var numUsedBins uint32
-	for _, pixel := range grad.Pix {
+	for i, pixel := range grad.Values() {
+		if included != nil && !included[i] {
+			continue
+		}
+		hist.total++
 		v := hist.sparse[pixel]
 		if v == 0 {
 			// First use of this bin, so count it
@@ -84,40 +105,57 @@ func makeSparseHist(grad *ComplexImage, width, height int) SippHist {
 			hist.max = v
 		}
 	}
-	// numUsedBins is larger, or in the worst case equal, to the number of
-	// distinct bin values, so it can be used as the capacity of the slice of
-	// distinct bin values.
-	hist.bins = make([]BinPair, 0, numUsedBins)
+	hist.initBins(numUsedBins)
 	for _, binval := range hist.sparse {
-		hist.bins = addBinsValue(hist.bins, binval)
+		hist.addBinsValue(binval)
 	}
+	hist.sortBins()
 	return hist
 }
 
 // BinForPixel returns the bin index in the slice returned by Bins for the
 // given gradient-image pixel.
 func (hist *sparseSippHist) BinForPixel(x, y int) (int) {
-	// get the complex value in the image at x, y
-	stride := hist.grad.Rect.Dx()
-	index := y*stride+x
-	pixel := hist.grad.Pix[index]
-	// get the value from the map
-	val:= hist.sparse[pixel]
-	// find the value in the bins slice
-	for i, binVal := range hist.bins {
-		if val == binVal.BinVal {
-			return i
+	if hist.pixBins == nil {
+		hist.setupPixBins()
+	}
+	stride := hist.grad.Bounds().Dx()
+	return hist.pixBins[y*stride+x]
+}
+
+// setupPixBins populates pixBins, by getting the value from the map for each
+// gradient pixel and looking up that value's index in the bins slice.
+func (hist *sparseSippHist) setupPixBins() {
+	pix := hist.grad.Values()
+	hist.pixBins = make([]int, len(pix))
+	for i, pixel := range pix {
+		if hist.included != nil && !hist.included[i] {
+			hist.pixBins[i] = -1
+		} else {
+			hist.pixBins[i] = hist.binIndexFor(hist.sparse[pixel])
 		}
 	}
-	panic("No bin found for pixel!");
 }
 
 // Implement the rowSource interface for rendering
-// rowVals returns a slice containing the bin values for one complete row of
-// the histogram.
+// A sparse histogram has no complete rows, so rowVals returns nil, and
+// rendering uses forBins instead.
 func (hist *sparseSippHist) rowVals(y int) []uint32 {
 	return nil
 }
+
+// Implement the binSource interface for rendering
+// forBins calls f with the position and value of each bin in the map. The
+// position is the bin a flat histogram of the same size would use, so values
+// that floor to the same bin are reported at the same position.
+func (hist *sparseSippHist) forBins(f func(x, y int, val uint32)) {
+	xoff := (hist.width - 1) / 2
+	yoff := (hist.height - 1) / 2
+	for pixel, val := range hist.sparse {
+		f(int(math.Floor(real(pixel)))+xoff, int(math.Floor(imag(pixel)))+yoff, val)
+	}
+}
+
 // Render renders the histogram into an 8-bit grayscale image. If clip is true,
 // values are clipped to 255. If clip is false, values are scaled to 255.
 // Sparse histograms are rendered scaled to the the maximum flat histogram
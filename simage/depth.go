@@ -0,0 +1,197 @@
+// Copyright Raul Vera 2015-2021
+
+package simage
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// An Expansion selects how 8-bit values are converted to 16 bits.
+type Expansion int
+
+const (
+	// Replicate repeats the 8 bits in the low byte, multiplying by 257, so
+	// that 255 becomes 65535 and the full range maps to the full range.
+	Replicate Expansion = iota
+	// Scale multiplies by 256, so that dividing by 256 recovers the 8-bit
+	// values, and the largest value is 65280.
+	Scale
+)
+
+// A Reduction selects how values are reduced to fewer bits.
+type Reduction int
+
+const (
+	// Truncate drops the low bits.
+	Truncate Reduction = iota
+	// Round scales the full range of the source to the full range of the
+	// result and rounds to the nearest level.
+	Round
+	// FloydSteinberg scales as for Round and diffuses the rounding error of
+	// each pixel to its unprocessed neighbours, 7/16 to the right and 3/16,
+	// 5/16 and 1/16 to the lower left, below and lower right, so that the
+	// local mean is preserved.
+	FloydSteinberg
+	// Ordered scales as for Round and adds a threshold from an 8x8 Bayer
+	// matrix, tiled over the image, before taking the level below, so that
+	// the local mean is preserved in a regular pattern.
+	Ordered
+)
+
+var reductionNames = []string{"truncate", "round", "fs", "ordered"}
+
+func (red Reduction) String() string {
+	if red < 0 || int(red) >= len(reductionNames) {
+		return fmt.Sprintf("Reduction(%d)", int(red))
+	}
+	return reductionNames[red]
+}
+
+// ParseReduction returns the Reduction with the given name, as returned by
+// String.
+func ParseReduction(name string) (Reduction, error) {
+	for i, redName := range reductionNames {
+		if name == redName {
+			return Reduction(i), nil
+		}
+	}
+	return Truncate, fmt.Errorf("Unknown depth reduction %q; use truncate, "+
+		"round, fs or ordered", name)
+}
+
+// The 8x8 Bayer matrix, whose entries, plus 0.5 and divided by 64, give
+// thresholds spread evenly between 0 and 1.
+var bayer8 = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// To16 converts the image to 16 bits by the given expansion. A 16-bit image
+// is copied unchanged.
+func To16(src SippImage, exp Expansion) *SippGray16 {
+	mult := int32(1)
+	if src.Bpp() == 8 {
+		mult = 257
+		if exp == Scale {
+			mult = 256
+		}
+	}
+	rect := src.Bounds()
+	dst := new(SippGray16)
+	dst.Gray16 = image.NewGray16(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	i := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			val := src.IntVal(x, y) * mult
+			dst.Gray16.Pix[i] = uint8(val >> 8)
+			dst.Gray16.Pix[i+1] = uint8(val)
+			i += 2
+		}
+	}
+	return dst
+}
+
+// To8 converts the image to 8 bits by the given reduction. It is the same as
+// ReduceDepth to 8 bits.
+func To8(src SippImage, red Reduction) *SippGray {
+	return ReduceDepth(src, 8, red).(*SippGray)
+}
+
+// ReduceDepth reduces the image to the given number of bits, from 1 to its
+// depth, by the given reduction. The result has values from 0 to
+// 2^bits - 1, and is 8-bit for up to 8 bits and 16-bit otherwise, so that its
+// gradient and delentropy can be compared with those of the source. It panics
+// if the number of bits is invalid.
+func ReduceDepth(src SippImage, bits int, red Reduction) SippImage {
+	if bits < 1 || bits > src.Bpp() {
+		panic("Invalid number of bits for depth reduction!")
+	}
+	rect := src.Bounds()
+	flt := NewFloatImage(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	shift := uint(src.Bpp() - bits)
+	scale := float64(int32(1)<<uint(bits)-1) / float64(int32(1)<<uint(src.Bpp())-1)
+	i := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			val := src.IntVal(x, y)
+			if red == Truncate {
+				flt.Pix[i] = float64(val >> shift)
+			} else {
+				flt.Pix[i] = float64(val) * scale
+			}
+			i++
+		}
+	}
+	return flt.Reduce(bits, red)
+}
+
+// Reduce converts the image to an image of the given number of bits, from 1
+// to 16, by the given reduction, treating its values as levels of the result
+// without rescaling them, like Quantise. Truncate takes the level below and
+// Round the nearest level, which is the same as Quantise. The values are
+// clipped to the range from 0 to 2^bits - 1, and the result is 8-bit for up
+// to 8 bits and 16-bit otherwise.
+func (flt *FloatImage) Reduce(bits int, red Reduction) SippImage {
+	if bits < 1 || bits > 16 {
+		panic("Invalid number of bits for depth reduction!")
+	}
+	maxVal := float64(int32(1)<<uint(bits) - 1)
+	width, height := flt.Rect.Dx(), flt.Rect.Dy()
+	res := NewFloatImage(image.Rect(0, 0, width, height))
+	clip := func(val float64) float64 {
+		return math.Max(0, math.Min(maxVal, val))
+	}
+	switch red {
+	case Truncate:
+		for i, val := range flt.Pix {
+			res.Pix[i] = clip(math.Floor(val))
+		}
+	case Round:
+		for i, val := range flt.Pix {
+			res.Pix[i] = clip(math.Round(val))
+		}
+	case FloydSteinberg:
+		// The errors diffused to the current row and the next.
+		cur := make([]float64, width+2)
+		next := make([]float64, width+2)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				val := flt.Pix[i] + cur[x+1]
+				res.Pix[i] = clip(math.Round(val))
+				e := val - res.Pix[i]
+				cur[x+2] += e * 7 / 16
+				next[x] += e * 3 / 16
+				next[x+1] += e * 5 / 16
+				next[x+2] += e / 16
+			}
+			cur, next = next, cur
+			for i := range next {
+				next[i] = 0
+			}
+		}
+	case Ordered:
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				threshold := (float64(bayer8[y%8][x%8]) + 0.5) / 64
+				res.Pix[i] = clip(math.Floor(flt.Pix[i] + threshold))
+			}
+		}
+	default:
+		panic("Unknown depth reduction!")
+	}
+	bpp := 8
+	if bits > 8 {
+		bpp = 16
+	}
+	return res.Quantise(bpp)
+}
@@ -0,0 +1,317 @@
+// Copyright Raul Vera 2015-2021
+
+package simage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A Display maps the values of an 8 or 16-bit image to 8 bits for display. A
+// window of values is chosen, and the values within it are stretched to the
+// range from 0 to 255, with values outside it clipped to 0 or 255. The zero
+// Display maps the full range of the image's depth linearly, which for 16-bit
+// images amounts to dividing by 256, so that scientific images using only a
+// small part of that range appear nearly black.
+type Display struct {
+	Window WindowMode
+	// For PercentileClip, the percentiles of the image's values, from 0 to
+	// 100, at the bottom and top of the window.
+	LowPct, HighPct float64
+	// For WindowLevel, the value at the centre of the window and its width.
+	Level, Width float64
+	Stretch      Stretch
+	// For GammaStretch, the exponent to which the position of each value
+	// within the window, from 0 to 1, is raised. Exponents below 1 brighten
+	// the darker values. 0 selects 1.
+	Gamma float64
+	// For LogStretch and AsinhStretch, the scale of the position within the
+	// window at which the stretch changes from linear to logarithmic. Larger
+	// values compress the brighter values more. 0 selects a default of 1000
+	// for LogStretch and 10 for AsinhStretch.
+	Strength float64
+}
+
+// A WindowMode selects how the window of displayed values is chosen.
+type WindowMode int
+
+const (
+	// FullRange uses the full range of the image's depth.
+	FullRange WindowMode = iota
+	// MinMax uses the range of the image's values.
+	MinMax
+	// PercentileClip uses the range between two percentiles of the image's
+	// values, ignoring outliers.
+	PercentileClip
+	// WindowLevel uses a window of the given width centred on the given
+	// level.
+	WindowLevel
+)
+
+// A Stretch is the curve with which the values in the window are mapped to
+// the displayed values.
+type Stretch int
+
+const (
+	// LinearStretch maps the values linearly.
+	LinearStretch Stretch = iota
+	// GammaStretch maps the values by a power law.
+	GammaStretch
+	// LogStretch maps the position t within the window, from 0 to 1, to
+	// log(1 + a t) / log(1 + a), where a is the strength.
+	LogStretch
+	// AsinhStretch maps the position t within the window, from 0 to 1, to
+	// asinh(a t) / asinh(a), where a is the strength. It is linear for small t
+	// and logarithmic for large t.
+	AsinhStretch
+)
+
+// Default strengths of the logarithmic stretches.
+const (
+	defaultLogStrength   = 1000
+	defaultAsinhStrength = 10
+)
+
+// check returns an error if the parameters are invalid.
+func (disp Display) check() error {
+	switch disp.Window {
+	case FullRange, MinMax:
+	case PercentileClip:
+		if disp.LowPct < 0 || disp.HighPct <= disp.LowPct || disp.HighPct > 100 {
+			return errors.New("Display percentiles must satisfy 0 <= low < high <= 100!")
+		}
+	case WindowLevel:
+		if !(disp.Width > 0) {
+			return errors.New("Display window width must be positive!")
+		}
+	default:
+		return errors.New("Unknown display window mode!")
+	}
+	if disp.Gamma < 0 || disp.Strength < 0 {
+		return errors.New("Display gamma and strength must be non-negative!")
+	}
+	if disp.Stretch < LinearStretch || disp.Stretch > AsinhStretch {
+		return errors.New("Unknown display stretch!")
+	}
+	return nil
+}
+
+// Limits returns the values at the bottom and top of the window for the
+// image.
+func (disp Display) Limits(im SippImage) (low, high float64) {
+	if err := disp.check(); err != nil {
+		panic(err.Error())
+	}
+	switch disp.Window {
+	case FullRange:
+		return 0, float64(int32(1)<<uint(im.Bpp()) - 1)
+	case WindowLevel:
+		return disp.Level - disp.Width/2, disp.Level + disp.Width/2
+	}
+	hist := levelCounts(im)
+	if disp.Window == MinMax {
+		return percentileLevel(hist, 0), percentileLevel(hist, 100)
+	}
+	return percentileLevel(hist, disp.LowPct), percentileLevel(hist, disp.HighPct)
+}
+
+// levelCounts returns the number of pixels of the image at each grey level.
+func levelCounts(im SippImage) []int {
+	hist := make([]int, 1<<uint(im.Bpp()))
+	rect := im.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			hist[im.IntVal(x, y)]++
+		}
+	}
+	return hist
+}
+
+// percentileLevel returns the lowest level at or below which the given
+// percentage of the pixels lie, or the lowest level present for 0.
+func percentileLevel(hist []int, pct float64) float64 {
+	var total int
+	for _, count := range hist {
+		total += count
+	}
+	target := pct / 100 * float64(total)
+	var sum int
+	for level, count := range hist {
+		sum += count
+		if count > 0 && float64(sum) >= target {
+			return float64(level)
+		}
+	}
+	return float64(len(hist) - 1)
+}
+
+// LUT returns the displayed value, from 0 to 255 before rounding, of each
+// grey level of the image. It panics if the parameters are invalid.
+func (disp Display) LUT(im SippImage) []float64 {
+	low, high := disp.Limits(im)
+	strength := disp.Strength
+	if strength == 0 {
+		strength = defaultLogStrength
+		if disp.Stretch == AsinhStretch {
+			strength = defaultAsinhStrength
+		}
+	}
+	gamma := disp.Gamma
+	if gamma == 0 {
+		gamma = 1
+	}
+	lut := make([]float64, 1<<uint(im.Bpp()))
+	for level := range lut {
+		// A window of one level shows it as white.
+		t := 1.0
+		if high > low {
+			t = math.Max(0, math.Min(1, (float64(level)-low)/(high-low)))
+		} else if float64(level) < low {
+			t = 0
+		}
+		switch disp.Stretch {
+		case GammaStretch:
+			t = math.Pow(t, gamma)
+		case LogStretch:
+			t = math.Log1p(strength*t) / math.Log1p(strength)
+		case AsinhStretch:
+			t = math.Asinh(strength*t) / math.Asinh(strength)
+		}
+		lut[level] = 255 * t
+	}
+	return lut
+}
+
+// Render returns the image mapped to 8 bits for display.
+func (disp Display) Render(im SippImage) SippImage {
+	lut := disp.LUT(im)
+	rect := im.Bounds()
+	rnd := new(SippGray)
+	rnd.Gray = image.NewGray(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	rndPix := rnd.Pix()
+	i := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			rndPix[i] = uint8(math.Round(lut[im.IntVal(x, y)]))
+			i++
+		}
+	}
+	return rnd
+}
+
+// Thumbnail returns a thumbnail of the image, as returned by its Thumbnail
+// method, with its values mapped for display.
+func (disp Display) Thumbnail(im SippImage) SippImage {
+	thumb := new(SippGray)
+	thumb.Gray = image.NewGray(image.Rect(0, 0, thumbSide, thumbSide))
+	if disp.Window == FullRange && disp.Stretch == LinearStretch {
+		// Scaling the weights of the filter, rather than the values, gives
+		// exactly the thumbnails made before the display mapping existed.
+		scaleDown(im, thumb.Gray, im.Val, true)
+	} else {
+		lut := disp.LUT(im)
+		scaleDown(im, thumb.Gray, func(x, y int) float64 {
+			return lut[im.IntVal(x, y)]
+		}, false)
+	}
+	return thumb
+}
+
+// ParseDisplay parses a window and a stretch specification into a Display.
+// The window is one of full, minmax, pct:low,high or win:level,width, and the
+// stretch is one of linear, gamma:exponent, log, log:strength, asinh or
+// asinh:strength. Returns an error if either is invalid.
+func ParseDisplay(window, stretch string) (Display, error) {
+	var disp Display
+	name, params, err := parseSpec(window)
+	if err != nil {
+		return disp, err
+	}
+	numParams := 0
+	switch name {
+	case "full":
+		disp.Window = FullRange
+	case "minmax":
+		disp.Window = MinMax
+	case "pct":
+		disp.Window = PercentileClip
+		numParams = 2
+	case "win":
+		disp.Window = WindowLevel
+		numParams = 2
+	default:
+		return disp, fmt.Errorf("Unknown display window %q; use full, "+
+			"minmax, pct or win", name)
+	}
+	if len(params) != numParams {
+		return disp, fmt.Errorf("Display window %q takes %d parameters",
+			name, numParams)
+	}
+	if disp.Window == PercentileClip {
+		disp.LowPct, disp.HighPct = params[0], params[1]
+	} else if disp.Window == WindowLevel {
+		disp.Level, disp.Width = params[0], params[1]
+	}
+
+	name, params, err = parseSpec(stretch)
+	if err != nil {
+		return disp, err
+	}
+	maxParams := 1
+	switch name {
+	case "linear":
+		disp.Stretch = LinearStretch
+		maxParams = 0
+	case "gamma":
+		disp.Stretch = GammaStretch
+		if len(params) != 1 {
+			return disp, errors.New("Display stretch \"gamma\" takes 1 parameter")
+		}
+	case "log":
+		disp.Stretch = LogStretch
+	case "asinh":
+		disp.Stretch = AsinhStretch
+	default:
+		return disp, fmt.Errorf("Unknown display stretch %q; use linear, "+
+			"gamma, log or asinh", name)
+	}
+	if len(params) > maxParams {
+		return disp, fmt.Errorf("Display stretch %q takes at most %d "+
+			"parameters", name, maxParams)
+	}
+	if len(params) == 1 {
+		if !(params[0] > 0) {
+			return disp, fmt.Errorf("Display stretch %q takes a positive "+
+				"parameter", name)
+		}
+		if disp.Stretch == GammaStretch {
+			disp.Gamma = params[0]
+		} else {
+			disp.Strength = params[0]
+		}
+	}
+	return disp, disp.check()
+}
+
+// parseSpec splits a specification of the form name or name:p1,p2,... into
+// the name and the parameters.
+func parseSpec(spec string) (name string, params []float64, err error) {
+	parts := strings.SplitN(spec, ":", 2)
+	name = parts[0]
+	if len(parts) == 1 {
+		return
+	}
+	for _, field := range strings.Split(parts[1], ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return name, nil, fmt.Errorf("Invalid display parameter %q: %v",
+				field, err)
+		}
+		params = append(params, val)
+	}
+	return
+}
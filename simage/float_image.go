@@ -0,0 +1,192 @@
+// Copyright Raul Vera 2015-2021
+
+package simage
+
+import (
+	"image"
+	"math"
+)
+
+// A FloatImage is an image where each pixel is a float64. It holds the results
+// of computations that aren't naturally 8 or 16-bit grey values, such as
+// per-pixel measures, and can be rendered as an 8-bit image for display.
+type FloatImage struct {
+	// The pixel data, in row-major order.
+	Pix []float64
+	// The rectangle defining the bounds of the image.
+	Rect image.Rectangle
+	// Extreme values found in this image.
+	Min, Max float64
+}
+
+// NewFloatImage returns a new FloatImage of the given bounds, with all pixels
+// 0.
+func NewFloatImage(r image.Rectangle) *FloatImage {
+	return &FloatImage{Pix: make([]float64, r.Dx()*r.Dy()), Rect: r}
+}
+
+// FromFloatArray wraps an array of float64s in a FloatImage.
+func FromFloatArray(pix []float64, width int) (dst *FloatImage) {
+	dst = new(FloatImage)
+	dst.Pix = pix
+	dst.Rect = image.Rect(0, 0, width, len(pix)/width)
+	dst.SetScaling()
+	return
+}
+
+// ToFloat converts a SippImage to a FloatImage with the same values.
+func ToFloat(src SippImage) (dst *FloatImage) {
+	rect := src.Bounds()
+	dst = NewFloatImage(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	i := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			dst.Pix[i] = src.Val(x, y)
+			i++
+		}
+	}
+	dst.SetScaling()
+	return
+}
+
+// SetScaling recomputes Min and Max from the pixel data. Call it after
+// changing the pixels.
+func (flt *FloatImage) SetScaling() {
+	flt.Min = math.MaxFloat64
+	flt.Max = -math.MaxFloat64
+	for _, val := range flt.Pix {
+		if val < flt.Min {
+			flt.Min = val
+		}
+		if val > flt.Max {
+			flt.Max = val
+		}
+	}
+}
+
+// Bounds returns the rectangle defining the bounds of the image.
+func (flt *FloatImage) Bounds() image.Rectangle {
+	return flt.Rect
+}
+
+// Val returns the value at x, y, relative to the top-left corner.
+func (flt *FloatImage) Val(x, y int) float64 {
+	return flt.Pix[y*flt.Rect.Dx()+x]
+}
+
+// Render renders the image as an 8-bit grayscale image, scaling the range from
+// Min to Max to the range from 0 to 255.
+func (flt *FloatImage) Render() SippImage {
+	div := flt.Max - flt.Min
+	if div <= 0 {
+		div = 1
+	}
+	scale := 255.0 / div
+	rnd := new(SippGray)
+	rnd.Gray = image.NewGray(flt.Rect)
+	rndPix := rnd.Pix()
+	for index, val := range flt.Pix {
+		rndPix[index] = uint8(math.Round((val - flt.Min) * scale))
+	}
+	return rnd
+}
+
+// GaussianKernel returns a normalised 1D Gaussian kernel of the given standard
+// deviation, truncated at 3 standard deviations. The centre of the kernel is
+// at index len/2. A sigma of 0 gives the identity kernel.
+func GaussianKernel(sigma float64) []float64 {
+	if sigma < 0 || math.IsNaN(sigma) {
+		panic("Gaussian kernel must have non-negative sigma!")
+	}
+	radius := int(math.Ceil(3 * sigma))
+	kern := make([]float64, 2*radius+1)
+	if radius == 0 {
+		kern[0] = 1
+		return kern
+	}
+	var sum float64
+	for i := range kern {
+		x := float64(i - radius)
+		kern[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kern[i]
+	}
+	for i := range kern {
+		kern[i] /= sum
+	}
+	return kern
+}
+
+// Smooth returns a new image, the convolution of this one with the given
+// kernel horizontally and then vertically. Where the kernel extends past the
+// edge of the image, only the weights inside it are used, renormalised to sum
+// to 1, rather than extending the image in some arbitrary way.
+func (flt *FloatImage) Smooth(kern []float64) *FloatImage {
+	width, height := flt.Rect.Dx(), flt.Rect.Dy()
+	radius := len(kern) / 2
+	tmp := make([]float64, len(flt.Pix))
+	res := NewFloatImage(flt.Rect)
+	pass := func(src, dst []float64, n, stride, count, step int) {
+		for line := 0; line < count; line++ {
+			base := line * step
+			for i := 0; i < n; i++ {
+				var sum, wsum float64
+				for k, w := range kern {
+					j := i + k - radius
+					if j >= 0 && j < n {
+						sum += w * src[base+j*stride]
+						wsum += w
+					}
+				}
+				dst[base+i*stride] = sum / wsum
+			}
+		}
+	}
+	// Rows, then columns.
+	pass(flt.Pix, tmp, width, 1, height, width)
+	pass(tmp, res.Pix, height, width, width, 1)
+	res.SetScaling()
+	return res
+}
+
+// GaussianSmooth returns a new image, this one smoothed with a Gaussian of
+// the given standard deviation in pixels. See Smooth for the handling of the
+// edges.
+func (flt *FloatImage) GaussianSmooth(sigma float64) *FloatImage {
+	return flt.Smooth(GaussianKernel(sigma))
+}
+
+// Quantise converts the image to an 8 or 16-bit SippImage, rounding the
+// values to the nearest integer and clipping them to the range of the pixel
+// depth. Unlike Render, it does not rescale the values, so an image from
+// ToFloat is converted back unchanged.
+func (flt *FloatImage) Quantise(bpp int) SippImage {
+	var maxVal float64
+	switch bpp {
+	case 8:
+		maxVal = math.MaxUint8
+	case 16:
+		maxVal = math.MaxUint16
+	default:
+		panic("Quantised images must be 8 or 16-bit!")
+	}
+	rect := image.Rect(0, 0, flt.Rect.Dx(), flt.Rect.Dy())
+	vals := make([]uint16, len(flt.Pix))
+	for i, val := range flt.Pix {
+		vals[i] = uint16(math.Max(0, math.Min(maxVal, math.Round(val))))
+	}
+	if bpp == 8 {
+		dst := new(SippGray)
+		dst.Gray = image.NewGray(rect)
+		for i, val := range vals {
+			dst.Gray.Pix[i] = uint8(val)
+		}
+		return dst
+	}
+	dst := new(SippGray16)
+	dst.Gray16 = image.NewGray16(rect)
+	for i, val := range vals {
+		dst.Gray16.Pix[2*i] = uint8(val >> 8)
+		dst.Gray16.Pix[2*i+1] = uint8(val)
+	}
+	return dst
+}
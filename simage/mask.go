@@ -0,0 +1,120 @@
+// Copyright Raul Vera 2015-2021
+
+package simage
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// A mask is a SippImage that selects the pixels of another image of the same
+// size to include in an analysis. Non-zero mask pixels are included and zero
+// mask pixels are excluded. Functions that take a mask treat a nil mask as
+// including every pixel.
+
+// MaskIncluded returns a slice with one entry per pixel of the mask, in
+// row-major order, which is true where the mask is non-zero. It returns nil
+// for a nil mask. It panics if the mask is not of the given size, as
+// callers should check that masks match their images before starting.
+func MaskIncluded(mask SippImage, size image.Point) []bool {
+	if mask == nil {
+		return nil
+	}
+	rect := mask.Bounds()
+	if rect.Size() != size {
+		panic("Mask and image sizes differ!")
+	}
+	included := make([]bool, 0, size.X*size.Y)
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			included = append(included, mask.IntVal(x, y) != 0)
+		}
+	}
+	return included
+}
+
+// A ValueRange is an inclusive range of pixel values. A single value is a
+// range with Lo equal to Hi.
+type ValueRange struct {
+	Lo, Hi int32
+}
+
+// InvalidValues is a set of pixel values that mark missing data rather than
+// measurements, such as the no-data and saturation markers of a sensor. It is
+// converted to a mask by ValidMask, so that the rest of the pipeline skips
+// those pixels.
+type InvalidValues []ValueRange
+
+// Contains returns true if the value is in any of the ranges.
+func (inv InvalidValues) Contains(val int32) bool {
+	for _, r := range inv {
+		if val >= r.Lo && val <= r.Hi {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseInvalidValues parses a comma-separated list of values and inclusive
+// ranges, such as "0,65535" or "0-15,65520-65535". An empty string gives no
+// invalid values.
+func ParseInvalidValues(list string) (inv InvalidValues, err error) {
+	if list == "" {
+		return nil, nil
+	}
+	for _, field := range strings.Split(list, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(field), "-", 2)
+		var r ValueRange
+		lo, err := strconv.ParseInt(bounds[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %q: %v", field, err)
+		}
+		r.Lo, r.Hi = int32(lo), int32(lo)
+		if len(bounds) == 2 {
+			hi, err := strconv.ParseInt(bounds[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid value range %q: %v", field, err)
+			}
+			r.Hi = int32(hi)
+		}
+		if r.Hi < r.Lo {
+			return nil, fmt.Errorf("Invalid value range %q is empty", field)
+		}
+		inv = append(inv, r)
+	}
+	return
+}
+
+// ValidMask returns a mask for the image that includes only the pixels that
+// are selected by the given mask and whose values are not invalid, along with
+// the number of pixels that the mask selects but that are invalid. The given
+// mask may be nil to start from all the pixels. The result can be passed to
+// any function that takes a mask for the image, and to sgrad.FdgradMasked to
+// exclude every gradient stencil that touches an invalid pixel. If there are
+// no invalid values, the given mask is returned unchanged.
+func ValidMask(im SippImage, invalid InvalidValues, mask SippImage) (valid SippImage, skipped int) {
+	if len(invalid) == 0 {
+		return mask, 0
+	}
+	size := im.Bounds().Size()
+	included := MaskIncluded(mask, size)
+	vmask := new(SippGray)
+	vmask.Gray = image.NewGray(image.Rect(0, 0, size.X, size.Y))
+	vmaskPix := vmask.Pix()
+	i := 0
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if included == nil || included[i] {
+				if invalid.Contains(im.IntVal(x, y)) {
+					skipped++
+				} else {
+					vmaskPix[i] = 255
+				}
+			}
+			i++
+		}
+	}
+	return vmask, skipped
+}
@@ -0,0 +1,63 @@
+// Copyright Raul Vera 2015-2021
+
+package simage
+
+import (
+	"image"
+)
+
+// Montage returns the images laid out in a grid of the given number of
+// columns, in rows from left to right and top to bottom, as an 8-bit image.
+// Each cell is the size of the largest image, and each image is centred in its
+// cell on black. The cells are separated by white lines of the given width,
+// with no border around the grid. 16-bit images are mapped to 8 bits by the
+// zero Display. It panics if there are no images, the number of columns is
+// less than 1 or the gap is negative.
+func Montage(ims []SippImage, cols, gap int) SippImage {
+	if len(ims) == 0 || cols < 1 || gap < 0 {
+		panic("Invalid montage parameters!")
+	}
+	var cellWidth, cellHeight int
+	for _, im := range ims {
+		size := im.Bounds().Size()
+		if size.X > cellWidth {
+			cellWidth = size.X
+		}
+		if size.Y > cellHeight {
+			cellHeight = size.Y
+		}
+	}
+	if cols > len(ims) {
+		cols = len(ims)
+	}
+	rows := (len(ims) + cols - 1) / cols
+	mont := new(SippGray)
+	mont.Gray = image.NewGray(image.Rect(0, 0,
+		cols*(cellWidth+gap)-gap, rows*(cellHeight+gap)-gap))
+	montPix := mont.Pix()
+	for i := range montPix {
+		montPix[i] = 255
+	}
+	for i, im := range ims {
+		if im.Bpp() == 16 {
+			im = Display{}.Render(im)
+		}
+		cellX := (i % cols) * (cellWidth + gap)
+		cellY := (i / cols) * (cellHeight + gap)
+		for y := cellY; y < cellY+cellHeight; y++ {
+			for x := cellX; x < cellX+cellWidth; x++ {
+				montPix[mont.Gray.PixOffset(x, y)] = 0
+			}
+		}
+		rect := im.Bounds()
+		offX := cellX + (cellWidth-rect.Dx())/2
+		offY := cellY + (cellHeight-rect.Dy())/2
+		for y := 0; y < rect.Dy(); y++ {
+			for x := 0; x < rect.Dx(); x++ {
+				montPix[mont.Gray.PixOffset(offX+x, offY+y)] =
+					uint8(im.IntVal(rect.Min.X+x, rect.Min.Y+y))
+			}
+		}
+	}
+	return mont
+}
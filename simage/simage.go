@@ -142,32 +142,32 @@ func sippWrite(img image.Image, out *string) error {
 	return png.Encode(writer, img)
 }
 
+// Thumbnail returns a thumbnail with the full range of the image's depth
+// mapped linearly to 8 bits. Use Display.Thumbnail for other mappings.
 func (img *SippGray) Thumbnail() SippImage {
-	return thumbnail(img)
+	return Display{}.Thumbnail(img)
 }
 
+// Thumbnail returns a thumbnail with the full range of the image's depth
+// mapped linearly to 8 bits. Use Display.Thumbnail for other mappings.
 func (img *SippGray16) Thumbnail() SippImage {
-	return thumbnail(img)
+	return Display{}.Thumbnail(img)
 }
 
 // Thumbnails are square, this many pixels on a side, padded with black if
 // original isn't square.
+// TODO: if the original is smaller than or equal to this, just center it
 const thumbSide = 150
 
-func thumbnail(src SippImage) SippImage {
-	thumb := new(SippGray)
-	thumb.Gray = image.NewGray(image.Rect(0, 0, thumbSide, thumbSide))
-	// TODO: if the original is smaller than or equal to this, just center it
-	scaleDown(src, thumb.Gray)
-	return thumb
-}
-
-// Scale the source image down to the destination image.
+// Scale the source image down to the destination image, taking the source
+// values from the given function, which are divided by 256 for a 16-bit
+// image if scale16 is true.
 // Preserves aspect ratio, leaving unused destination pixels untouched.
 // At present it just uses a simple box filter.
 // It might be possible to improve performance and clarity by making all
 // pixel fractions 1/16 and using essentially fixed-point arithmetic.
-func scaleDown(src SippImage, dst *image.Gray) {
+func scaleDown(src SippImage, dst *image.Gray, srcVal func(x, y int) float64,
+	scale16 bool) {
 	srcRect := src.Bounds()
 	dstRect := dst.Bounds()
 
@@ -201,7 +201,7 @@ func scaleDown(src SippImage, dst *image.Gray) {
 	// Scale 16-bit images down to 8. We incour the cost spuriously for 8-bit
 	// images so that we can access the source polymorphically.
 	var scaleBpp float64 = 1.0
-	if src.Bpp() == 16 {
+	if scale16 && src.Bpp() == 16 {
 		scaleBpp = 1.0 / 256.0
 	}
 
@@ -214,7 +214,7 @@ func scaleDown(src SippImage, dst *image.Gray) {
 		for intx := 0; intx < outWidth; intx++ {
 			var val float64
 			for i := 0; i < hfilter[intx].n; i++ {
-				val = val + src.Val(hfilter[intx].idx+i, inty)*hfilter[intx].weights[i]
+				val = val + srcVal(hfilter[intx].idx+i, inty)*hfilter[intx].weights[i]
 			}
 			val = math.Floor(val + 0.5)
 			if val > 255.0 {
@@ -5,7 +5,9 @@
 package simage
 
 import (
+	"image"
 	"image/color"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -168,3 +170,293 @@ func TestGray16SippImage(t *testing.T) {
 		t.Error("Error: golden gray16 thumbnail and generated differ")
 	}
 }
+
+func TestFloatImage(t *testing.T) {
+	flt := FromFloatArray([]float64{-1, 0, 1, 3}, 2)
+	if flt.Bounds().Dx() != 2 || flt.Bounds().Dy() != 2 {
+		t.Errorf("Error: float image bounds %v", flt.Bounds())
+	}
+	if flt.Min != -1 || flt.Max != 3 {
+		t.Errorf("Error: float image range %v to %v, expected -1 to 3",
+			flt.Min, flt.Max)
+	}
+	if flt.Val(0, 1) != 1 {
+		t.Errorf("Error: float image value %v, expected 1", flt.Val(0, 1))
+	}
+	rnd := flt.Render()
+	expected := []uint8{0, 64, 128, 255}
+	if !reflect.DeepEqual(rnd.Pix(), expected) {
+		t.Errorf("Error: float image rendered as %v, expected %v", rnd.Pix(),
+			expected)
+	}
+	// Round trip through an 8-bit image.
+	back := ToFloat(rnd)
+	for i, val := range back.Pix {
+		if val != float64(expected[i]) {
+			t.Errorf("Error: converted pixel %d is %v, expected %v", i, val,
+				expected[i])
+		}
+	}
+}
+
+func TestValidMask(t *testing.T) {
+	inv, err := ParseInvalidValues("0, 65520-65535,7")
+	if err != nil {
+		t.Fatalf("Error parsing invalid values: %v", err)
+	}
+	expected := InvalidValues{{0, 0}, {65520, 65535}, {7, 7}}
+	if !reflect.DeepEqual(inv, expected) {
+		t.Errorf("Error: parsed invalid values %v, expected %v", inv, expected)
+	}
+	for _, bad := range []string{"x", "3-", "9-2", "1,,2"} {
+		if _, err := ParseInvalidValues(bad); err == nil {
+			t.Errorf("Error: parsing %q did not fail", bad)
+		}
+	}
+	if inv, err = ParseInvalidValues(""); inv != nil || err != nil {
+		t.Errorf("Error: parsing an empty list gave %v, %v", inv, err)
+	}
+
+	// A 16-bit image with a no-data pixel and a saturated pixel.
+	im := new(SippGray16)
+	im.Gray16 = image.NewGray16(image.Rect(0, 0, 3, 2))
+	vals := []uint16{0, 100, 65535, 200, 300, 7}
+	for i, val := range vals {
+		im.Gray16.Pix[2*i] = uint8(val >> 8)
+		im.Gray16.Pix[2*i+1] = uint8(val)
+	}
+	valid, skipped := ValidMask(im, expected, nil)
+	if skipped != 3 || !reflect.DeepEqual(valid.Pix(), []uint8{0, 255, 0, 255, 255, 0}) {
+		t.Errorf("Error: valid mask %v with %d skipped", valid.Pix(), skipped)
+	}
+	// Combined with a mask, only the selected pixels are counted.
+	mask := new(SippGray)
+	mask.Gray = image.NewGray(im.Bounds())
+	copy(mask.Pix(), []uint8{1, 1, 0, 0, 1, 1})
+	valid, skipped = ValidMask(im, expected, mask)
+	if skipped != 2 || !reflect.DeepEqual(valid.Pix(), []uint8{0, 255, 0, 0, 255, 0}) {
+		t.Errorf("Error: combined valid mask %v with %d skipped", valid.Pix(), skipped)
+	}
+	if valid, skipped = ValidMask(im, nil, mask); valid != mask || skipped != 0 {
+		t.Error("Error: valid mask with no invalid values is not the given mask")
+	}
+	included := MaskIncluded(mask, im.Bounds().Size())
+	if !reflect.DeepEqual(included, []bool{true, true, false, false, true, true}) {
+		t.Errorf("Error: mask included %v", included)
+	}
+}
+
+func TestFloatSmoothQuantise(t *testing.T) {
+	kern := GaussianKernel(0)
+	if !reflect.DeepEqual(kern, []float64{1}) {
+		t.Errorf("Error: zero-sigma kernel %v", kern)
+	}
+	flt := FromFloatArray([]float64{0, 0, 9, 0, 0, 3}, 3)
+	if smoothed := flt.Smooth(kern); !reflect.DeepEqual(smoothed.Pix, flt.Pix) {
+		t.Errorf("Error: identity smoothing gave %v", smoothed.Pix)
+	}
+	// A box kernel at the edges uses only the weights inside the image.
+	smoothed := flt.Smooth([]float64{1.0 / 3, 1.0 / 3, 1.0 / 3})
+	// Rows become 0, 3, 4.5 and 0, 1, 1.5, then the columns are averaged.
+	expected := []float64{0, 2, 3, 0, 2, 3}
+	for i, val := range smoothed.Pix {
+		if val < expected[i]-1e-12 || val > expected[i]+1e-12 {
+			t.Errorf("Error: smoothed %v, expected %v", smoothed.Pix, expected)
+			break
+		}
+	}
+
+	flt = FromFloatArray([]float64{-3, 2.4, 2.5, 300, 65535.7, 1e6}, 3)
+	q8 := flt.Quantise(8)
+	if q8.Bpp() != 8 || !reflect.DeepEqual(q8.Pix(), []uint8{0, 2, 3, 255, 255, 255}) {
+		t.Errorf("Error: 8-bit quantised image %v", q8.Pix())
+	}
+	q16 := flt.Quantise(16)
+	expected16 := []int32{0, 2, 3, 300, 65535, 65535}
+	for i, exp := range expected16 {
+		if val := q16.IntVal(i%3, i/3); val != exp {
+			t.Errorf("Error: 16-bit quantised pixel %d is %d, expected %d", i, val, exp)
+		}
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	// The 16 levels from 1 to 16 in the test images.
+	sg16 := &SippGray16{&Gray16}
+	sg := &SippGray{&Gray}
+	check := func(name string, disp Display, im SippImage, want func(val int32) uint8) {
+		rnd := disp.Render(im)
+		if rnd.Bpp() != 8 {
+			t.Fatalf("Error: %s render has depth %d", name, rnd.Bpp())
+		}
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				val := im.IntVal(x, y)
+				if got := uint8(rnd.IntVal(x, y)); got != want(val) {
+					t.Fatalf("Error: %s render of %d is %d, expected %d", name,
+						val, got, want(val))
+				}
+			}
+		}
+	}
+	stretched := func(val int32) uint8 {
+		return uint8(17 * (val - 1))
+	}
+	check("full range", Display{}, sg, func(val int32) uint8 {
+		return uint8(val)
+	})
+	check("min-max", Display{Window: MinMax}, sg16, stretched)
+	check("window/level", Display{Window: WindowLevel, Level: 8.5, Width: 15},
+		sg16, stretched)
+	check("percentile", Display{Window: PercentileClip, LowPct: 25, HighPct: 75},
+		sg, func(val int32) uint8 {
+			return uint8(math.Round(255 * math.Max(0, math.Min(1,
+				float64(val-4)/8))))
+		})
+	check("gamma", Display{Window: MinMax, Stretch: GammaStretch, Gamma: 0.5},
+		sg16, func(val int32) uint8 {
+			return uint8(math.Round(255 * math.Sqrt(float64(val-1)/15)))
+		})
+
+	// The logarithmic stretches keep the ends of the window and brighten the
+	// values between.
+	for _, stretch := range []Stretch{LogStretch, AsinhStretch} {
+		lut := Display{Window: MinMax, Stretch: stretch}.LUT(sg16)
+		if lut[1] != 0 || math.Abs(lut[16]-255) > 1e-9 || lut[8] <= 255*7.0/15 {
+			t.Errorf("Error: stretch %d maps 1, 8 and 16 to %v, %v and %v",
+				stretch, lut[1], lut[8], lut[16])
+		}
+	}
+
+	// A thumbnail of an image using a small part of the 16-bit range is
+	// nearly black by default, but not when stretched.
+	dim := &SippGray16{image.NewGray16(image.Rect(0, 0, 300, 300))}
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			dim.SetGray16(x, y, color.Gray16{uint16(x + y)})
+		}
+	}
+	maxThumb := func(thm SippImage) (max uint8) {
+		for _, val := range thm.Pix() {
+			if val > max {
+				max = val
+			}
+		}
+		return
+	}
+	if max := maxThumb(dim.Thumbnail()); max > 3 {
+		t.Errorf("Error: default thumbnail maximum %d, expected at most 3", max)
+	}
+	if max := maxThumb(Display{Window: MinMax}.Thumbnail(dim)); max < 250 {
+		t.Errorf("Error: min-max thumbnail maximum %d, expected at least 250", max)
+	}
+
+	disp, err := ParseDisplay("pct:1,99", "asinh:5")
+	if err != nil || disp.Window != PercentileClip || disp.HighPct != 99 ||
+		disp.Stretch != AsinhStretch || disp.Strength != 5 {
+		t.Errorf("Error: parsed display %+v, %v", disp, err)
+	}
+	bad := [][2]string{{"pct:99,1", "linear"}, {"win:10", "linear"},
+		{"win:10,0", "linear"}, {"auto", "linear"}, {"full", "gamma"},
+		{"full", "log:-1"}, {"full", "linear:2"}, {"minmax", "cube"}}
+	for _, spec := range bad {
+		if _, err := ParseDisplay(spec[0], spec[1]); err == nil {
+			t.Errorf("Error: parsing display %v didn't fail", spec)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	sg := &SippGray{&Gray}
+	rep := To16(sg, Replicate)
+	scaled := To16(sg, Scale)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			val := sg.IntVal(x, y)
+			if rep.IntVal(x, y) != 257*val || scaled.IntVal(x, y) != 256*val {
+				t.Fatalf("Error: %d expanded to %d and %d", val, rep.IntVal(x, y),
+					scaled.IntVal(x, y))
+			}
+			// Rounding inverts replication and truncation inverts both.
+			for _, back := range []SippImage{To8(rep, Round), To8(rep, Truncate),
+				To8(scaled, Truncate)} {
+				if back.IntVal(x, y) != val {
+					t.Fatalf("Error: %d converted back to %d", val, back.IntVal(x, y))
+				}
+			}
+		}
+	}
+	if !reflect.DeepEqual(To16(rep, Scale), rep) {
+		t.Error("Error: 16-bit image changed by conversion to 16 bits")
+	}
+	two := ReduceDepth(sg, 2, Round)
+	if two.Bpp() != 8 || two.IntVal(0, 0) != 0 {
+		t.Errorf("Error: reduced to 2 bits as %d-bit image with value %d",
+			two.Bpp(), two.IntVal(0, 0))
+	}
+	if ReduceDepth(rep, 12, Truncate).IntVal(0, 0) != rep.IntVal(0, 0)>>4 {
+		t.Error("Error: truncation to 12 bits didn't drop 4 bits")
+	}
+
+	// Dithering a constant image of a quarter of the way between levels 0
+	// and 1 to 1 bit gives a quarter of the pixels at 1, exactly over each
+	// tile of the ordered pattern, and nearly for error diffusion, which
+	// loses the error diffused beyond the right edge and the bottom, up to a
+	// level per row. Rounding gives 0 everywhere.
+	flt := NewFloatImage(image.Rect(0, 0, 64, 64))
+	for i := range flt.Pix {
+		flt.Pix[i] = 0.25
+	}
+	for _, red := range []Reduction{Round, FloydSteinberg, Ordered} {
+		res := flt.Reduce(1, red)
+		var ones int
+		for _, val := range res.Pix() {
+			ones += int(val)
+		}
+		want := 1024
+		if red == Round {
+			want = 0
+		}
+		if ones < want-64 || ones > want || (red != FloydSteinberg && ones != want) {
+			t.Errorf("Error: %v gave %d pixels at 1, expected %d", red, ones, want)
+		}
+	}
+
+	if red, err := ParseReduction("fs"); err != nil || red != FloydSteinberg {
+		t.Errorf("Error: parsed fs as %v, %v", red, err)
+	}
+	if _, err := ParseReduction("dither"); err == nil {
+		t.Error("Error: parsing an unknown reduction didn't fail")
+	}
+}
+
+func TestMontage(t *testing.T) {
+	sg := &SippGray{&Gray}
+	sg16 := &SippGray16{&Gray16}
+	small := &SippGray{image.NewGray(image.Rect(0, 0, 2, 2))}
+	for i := range small.Gray.Pix {
+		small.Gray.Pix[i] = 7
+	}
+	mont := Montage([]SippImage{sg, sg16, small}, 2, 1)
+	if mont.Bpp() != 8 || mont.Bounds() != image.Rect(0, 0, 9, 9) {
+		t.Fatalf("Error: montage is %v at %d bits", mont.Bounds(), mont.Bpp())
+	}
+	expected := []struct {
+		x, y int
+		val  int32
+	}{
+		{3, 2, sg.IntVal(3, 2)},
+		// The 16-bit image is divided by 256.
+		{8, 3, sg16.IntVal(3, 3) / 256},
+		// The gaps and the unused cell are white.
+		{4, 0, 255}, {0, 4, 255}, {6, 6, 255},
+		// The small image is centred on black.
+		{0, 5, 0}, {1, 6, 7}, {2, 7, 7}, {3, 8, 0},
+	}
+	for _, exp := range expected {
+		if got := mont.IntVal(exp.x, exp.y); got != exp.val {
+			t.Errorf("Error: montage pixel %d,%d is %d, expected %d", exp.x,
+				exp.y, got, exp.val)
+		}
+	}
+}
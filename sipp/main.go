@@ -5,16 +5,28 @@ package main
 import (
 	"flag"
 	"fmt"
+	"image"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 import (
+	"github.com/Causticity/sipp/scomplex"
+	"github.com/Causticity/sipp/sedge"
 	"github.com/Causticity/sipp/sentropy"
 	"github.com/Causticity/sipp/sfft"
+	"github.com/Causticity/sipp/sfilter"
+	"github.com/Causticity/sipp/sgeom"
 	"github.com/Causticity/sipp/sgrad"
 	"github.com/Causticity/sipp/shist"
 	"github.com/Causticity/sipp/simage"
+	"github.com/Causticity/sipp/smorph"
+	"github.com/Causticity/sipp/sscale"
+	"github.com/Causticity/sipp/stensor"
+	"github.com/Causticity/sipp/swavelet"
 )
 
 func main() {
@@ -29,6 +41,35 @@ func main() {
 	}
 
 	var in = flag.String("in", "", "Input image file; must be grayscale png")
+	var maskName = flag.String("mask", "", "Mask image file, the same size "+
+		"as the input; only pixels where it is non-zero are analysed. The "+
+		"structure tensor, Poisson integration, FFT and comparison still use "+
+		"the whole image")
+	var invalid = flag.String("invalid", "", "Comma-separated list of "+
+		"values and ranges, e.g. 0,65535 or 0-15, marking missing data; "+
+		"these pixels of the unfiltered input and the gradients touching them "+
+		"are skipped, as if masked out, along with the pixels within reach "+
+		"of them of any -filter")
+	var maskOps = flag.String("maskops", "", "Morphological operations "+
+		"applied in turn to the mask from -mask and -invalid, separated by +, "+
+		"each either fill, to fill holes, or op:element, where op is erode, "+
+		"dilate, open, close, tophat, blacktophat or gradient and element is "+
+		"square:radius, rect:width,height, disk:radius or line:length,angle; "+
+		"e.g. open:disk:2+fill. The result is written to _mask.png")
+	var filter = flag.String("filter", "", "Filter to smooth the input "+
+		"with before any analysis: gauss:sigma, box:radius, median:radius or "+
+		"bilateral:sigma,rangesigma")
+	var border = flag.String("border", "reflect", "Border mode of the "+
+		"filter, padding and rotation: reflect, clamp, wrap, zero or crop; "+
+		"crop is valid only for the filter")
+	var geom = flag.String("geom", "", "Geometric transforms applied in "+
+		"turn to the input after any filter, separated by +: flip:h, flip:v, "+
+		"transpose, rot90:turns, rotate:degrees, crop:x,y,width,height or "+
+		"pad:left,top,right,bottom; e.g. crop:0,0,512,512+rotate:30. The "+
+		"mask is transformed with the input, with nearest interpolation, and "+
+		"excludes pixels from beyond the input's edges")
+	var interp = flag.String("interp", "bilinear", "Interpolation of "+
+		"rotations: nearest, bilinear or bicubic")
 	var out = flag.String("out", "", "Output image file prefix")
 	var thb = flag.Bool("t", false, "Boolean; if true, write a thumbnail image")
 	var grd = flag.Bool("g", false, "Boolean; if true, write the gradient"+
@@ -49,12 +90,221 @@ func main() {
 	var a = flag.Bool("a", false, "Boolean; if true, write all the images")
 	var v = flag.Bool("v", false, "Boolean; if true, verbosely report "+
 		"everything done")
+	var i32 = flag.Bool("i", false, "Boolean; if true, compute the gradient "+
+		"and histogram with exact integer arithmetic")
 	var csv = flag.Bool("csv", false, "Boolean: if true, write the name of the"+
-		"image, a comma, and the delentropy,"+
-		"on a single line.")
+		" image, a comma, and the delentropy, followed by any other requested"+
+		" values, on a single line.")
+
+	var renyi = flag.String("renyi", "", "Comma-separated list of "+
+		"non-negative orders; report the Rényi entropy and delentropy of "+
+		"each order (may be inf)")
+	var tsallis = flag.String("tsallis", "", "Comma-separated list of "+
+		"finite orders; report the Tsallis entropy and delentropy of each "+
+		"order, unaffected by -units, -pair and -rel")
+	var boot = flag.Int("boot", 0, "Number of bootstrap iterations; if "+
+		"non-zero, report the bootstrap mean, standard error, and percentile "+
+		"interval of the entropy and delentropy")
+	var bootBlock = flag.Int("bootblock", 0, "Block size for the bootstrap; "+
+		"0 resamples individual pixels")
+	var bootSeed = flag.Int64("bootseed", 0, "Seed for the bootstrap")
+	var bootLevel = flag.Float64("bootlevel", 0.95, "Confidence level of "+
+		"the bootstrap percentile interval")
+	var cmp = flag.String("cmp", "", "Image file to compare the input with; "+
+		"must be grayscale png of the same size. Reports the joint, "+
+		"conditional and mutual information of the two")
+	var jb = flag.Int("jb", 256, "Number of bins per image for the joint "+
+		"histogram")
+	var jh = flag.Bool("jh", false, "Boolean; if true, write a joint "+
+		"histogram image of the input and the -cmp image")
+	var pr = flag.Int("pr", 0, "Number of radial bins; if non-zero, use a "+
+		"polar histogram of gradient magnitude and orientation instead of a "+
+		"Cartesian one, and report the orientation entropy")
+	var pa = flag.Int("pa", 360, "Number of angular bins for a polar histogram")
+	var plog = flag.Bool("plog", false, "Boolean; if true, space the radial "+
+		"bins of a polar histogram logarithmically")
+	var units = flag.String("units", "bits", "Units of the reported "+
+		"entropies: bits, nats or dits")
+	var pair = flag.Float64("pair", sentropy.LarkinPairFactor, "Factor "+
+		"multiplying the reported delentropies, for the pair of gradient "+
+		"components at each pixel")
+	var rel = flag.Bool("rel", false, "Boolean; if true, report entropies "+
+		"as a fraction of the maximum for the histogram size, ignoring "+
+		"-units and -pair")
+	var noDC = flag.Bool("nodc", false, "Boolean; if true, exclude the "+
+		"zero-gradient histogram bin from the histogram and everything "+
+		"computed from it")
+	var floor = flag.Float64("floor", 0, "Gradient modulus below which "+
+		"pixels are excluded from the histogram and everything computed "+
+		"from it")
+	var radial = flag.Bool("radial", false, "Boolean; if true, exclude the "+
+		"zero-gradient bin as for -nodc and weight the other bins by their "+
+		"distance from the centre; the weights apply only to the delentropy")
+	var scales = flag.String("scales", "", "Comma-separated list of "+
+		"Gaussian standard deviations in pixels; if given, write the "+
+		"delentropy at each scale to a CSV file, and delentropy images")
+	var octaves = flag.Int("octaves", 0, "Number of octaves of scales "+
+		"to use instead of -scales, after the unsmoothed image")
+	var steps = flag.Int("steps", 1, "Number of scales per octave")
+	var pyramid = flag.Int("pyramid", 0, "Number of Gaussian pyramid "+
+		"levels to use instead of -scales, after the unsmoothed image")
+	var st = flag.Bool("st", false, "Boolean; if true, report the global "+
+		"structure tensor and gradient principal axes")
+	var sti = flag.Bool("sti", false, "Boolean; if true, write the "+
+		"structure tensor orientation, coherence and anisotropy images")
+	var stSigma = flag.Float64("stsigma", 1.0, "Standard deviation in "+
+		"pixels of the structure tensor window")
+	var integ = flag.String("pi", "", "Boundary conditions, fft for "+
+		"periodic or dct for Neumann, with which to integrate the gradient "+
+		"back to an image by solving a Poisson equation; if given, write "+
+		"the reconstructed image")
+	var canny = flag.Bool("canny", false, "Boolean; if true, write Canny "+
+		"edge and edge orientation images")
+	var cSigma = flag.Float64("csigma", 1.4, "Standard deviation in pixels "+
+		"of the Gaussian smoothing before Canny edge detection")
+	var cLow = flag.Float64("clow", -1, "Low Canny hysteresis threshold; "+
+		"if negative, both thresholds are chosen automatically")
+	var cHigh = flag.Float64("chigh", -1, "High Canny hysteresis threshold")
+	var cPct = flag.Bool("cpct", false, "Boolean; if true, the Canny "+
+		"thresholds are percentiles of the gradient magnitude")
+	var cGrad = flag.String("cgrad", "diag", "Gradient operator for Canny "+
+		"edge detection: diag or axis for a 2x2 kernel, with edge images one "+
+		"pixel smaller than the source, or sobel or scharr for a 3x3 operator")
+	var se = flag.Bool("se", false, "Boolean; if true, report the spectral "+
+		"entropy of the power spectrum and of its radial and angular profiles")
+	var seRad = flag.Int("ser", 32, "Number of radial bins for the "+
+		"spectral entropy")
+	var seAng = flag.Int("sea", 36, "Number of angular bins for the "+
+		"spectral entropy")
+	var wav = flag.String("wav", "", "Wavelet to decompose the input "+
+		"with: haar, db4, db8 or cdf97; if given, report the wavelet energy "+
+		"and entropy and write the subband mosaic")
+	var wavLevels = flag.Int("wlev", 0, "Number of wavelet levels; 0 for "+
+		"as many as the image size allows")
+	var dispWindow = flag.String("disp", "full", "Window of values shown "+
+		"by the thumbnail and renders: full for the full range of the "+
+		"depth, minmax, pct:low,high for percentiles, or win:level,width")
+	var dispStretch = flag.String("stretch", "linear", "Stretch of the "+
+		"displayed values: linear, gamma:exponent, log[:strength] or "+
+		"asinh[:strength]")
+	var rnd = flag.Bool("r", false, "Boolean; if true, write the input "+
+		"mapped to 8 bits by -disp and -stretch, and write 16-bit output "+
+		"images mapped the same way")
+	var bitSweep = flag.String("bitsweep", "", "Depth reduction, one of "+
+		"truncate, round, fs for Floyd-Steinberg dithering or ordered; if "+
+		"given, reduce the input to each bit depth from 1 to its own and "+
+		"write the entropy and delentropy of each to a CSV file")
+	var rot = flag.String("rot", "", "Comma-separated list of angles in "+
+		"degrees; if given, rotate the input by each, as interpolated by "+
+		"-interp, crop all to the region valid at every angle, and write "+
+		"the delentropy at each angle to a CSV file and a montage of "+
+		"thumbnails of the histograms, to measure the anisotropy of the "+
+		"gradient. The histograms are computed as for the delentropy, and "+
+		"any mask is rotated too, excluding the pixels interpolated from "+
+		"excluded ones")
+	var eq = flag.Bool("eq", false, "Boolean; if true, write the input "+
+		"with its histogram equalised")
+	var match = flag.String("match", "", "Reference image file; if given, "+
+		"write the input with its histogram matched to the reference's")
+	var clahe = flag.Bool("clahe", false, "Boolean; if true, write the "+
+		"input enhanced by contrast-limited adaptive histogram equalisation")
+	var claheTiles = flag.String("ctiles", "8x8", "CLAHE tile grid, as "+
+		"columns x rows")
+	var claheClip = flag.Float64("cclip", 2, "CLAHE clip limit, as a "+
+		"multiple of the mean count per grey level; 0 disables clipping")
 
 	flag.Parse()
+
+	invalidVals, err := simage.ParseInvalidValues(*invalid)
+	if err != nil {
+		fmt.Println("Error parsing invalid values:", err)
+		os.Exit(1)
+	}
+	scaleSigmas, err := parseFloats(*scales)
+	if err != nil {
+		fmt.Println("Error parsing scales:", err)
+		os.Exit(1)
+	}
+	for _, sigma := range scaleSigmas {
+		if !(sigma >= 0) || math.IsInf(sigma, 1) {
+			fmt.Println("Error: scales must be finite and non-negative, got", sigma)
+			os.Exit(1)
+		}
+	}
+	if *octaves > 0 {
+		if *steps < 1 {
+			fmt.Println("Error: -steps must be at least 1")
+			os.Exit(1)
+		}
+		scaleSigmas = sscale.OctaveSigmas(*octaves, *steps)
+	}
+	renyiOrders, err := parseFloats(*renyi)
+	if err != nil {
+		fmt.Println("Error parsing Rényi orders:", err)
+		os.Exit(1)
+	}
+	for _, alpha := range renyiOrders {
+		if alpha < 0 || math.IsNaN(alpha) {
+			fmt.Println("Error: Rényi orders must be non-negative, got", alpha)
+			os.Exit(1)
+		}
+	}
+	rotAngles, err := parseFloats(*rot)
+	if err != nil {
+		fmt.Println("Error parsing rotation angles:", err)
+		os.Exit(1)
+	}
+	for _, angle := range rotAngles {
+		if math.IsNaN(angle) || math.IsInf(angle, 0) {
+			fmt.Println("Error: rotation angles must be finite, got", angle)
+			os.Exit(1)
+		}
+	}
+	tsallisOrders, err := parseFloats(*tsallis)
+	if err != nil {
+		fmt.Println("Error parsing Tsallis orders:", err)
+		os.Exit(1)
+	}
+	for _, q := range tsallisOrders {
+		if math.IsNaN(q) || math.IsInf(q, 0) {
+			fmt.Println("Error: Tsallis orders must be finite, got", q)
+			os.Exit(1)
+		}
+	}
+	unit, err := sentropy.ParseUnit(*units)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	norm := sentropy.Normalisation{Unit: unit, PairFactor: *pair, Relative: *rel}
+	disp, err := simage.ParseDisplay(*dispWindow, *dispStretch)
+	if err != nil {
+		fmt.Println("Error parsing display mapping:", err)
+		os.Exit(1)
+	}
+	// Negative Canny thresholds select them automatically.
+	cannyOpts := sedge.CannyOptions{Sigma: *cSigma, Low: *cLow, High: *cHigh,
+		Orientation: true}
+	if *cLow >= 0 || *cHigh >= 0 {
+		cannyOpts.Mode = sedge.Absolute
+		if *cPct {
+			cannyOpts.Mode = sedge.Percentile
+		}
+	}
+	err = cannyOpts.SetGradient(*cGrad)
+	if err != nil {
+		fmt.Println("Error parsing Canny gradient:", err)
+		os.Exit(1)
+	}
+	// forDisplay maps a 16-bit output image to 8 bits if requested.
+	forDisplay := func(im simage.SippImage) simage.SippImage {
+		if *rnd && im.Bpp() == 16 {
+			return disp.Render(im)
+		}
+		return im
+	}
 	if *a {
+		*jh = *cmp != ""
 		*thb = true
 		*grd = true
 		*hst = true
@@ -64,6 +314,7 @@ func main() {
 		*e = true
 		*f = true
 		*fls = true
+		*sti = true
 	}
 
 	if *v {
@@ -79,9 +330,231 @@ func main() {
 	if *v {
 		fmt.Println("source image read")
 	}
+	// The mask is in the coordinates of the input, and is transformed with it.
+	// The invalid values are found before any filter smooths them away, and
+	// those pixels stay excluded after it.
+	var mask simage.SippImage
+	if *maskName != "" {
+		mask, err = simage.Read(*maskName)
+		if err != nil {
+			fmt.Println("Error reading mask image:", err)
+			os.Exit(1)
+		}
+		if mask.Bounds().Size() != src.Bounds().Size() {
+			fmt.Println("Error: the mask is not the same size as the input")
+			os.Exit(1)
+		}
+	}
+	mask, skipped := simage.ValidMask(src, invalidVals, mask)
+
+	borderMode, err := sfilter.ParseBorder(*border)
+	if err != nil {
+		fmt.Println("Error parsing border mode:", err)
+		os.Exit(1)
+	}
+	if *filter != "" {
+		filt, err := sfilter.ParseFilter(*filter, borderMode)
+		if err != nil {
+			fmt.Println("Error parsing filter:", err)
+			os.Exit(1)
+		}
+		// A cropping border trims the extent of the filter from each side of
+		// the image and the mask.
+		rx, ry := filt.Extent()
+		size := src.Bounds().Size()
+		if borderMode == sfilter.Crop {
+			if size.X < 2*rx+2 || size.Y < 2*ry+2 {
+				fmt.Println("Error: the input is too small to leave a " +
+					"gradient after the filter with a cropping border")
+				os.Exit(1)
+			}
+			size = size.Sub(image.Pt(2*rx, 2*ry))
+		}
+		// The filter mixes the invalid values into the pixels within its
+		// extent, so those are excluded too.
+		if len(invalidVals) > 0 {
+			valid, _ := simage.ValidMask(src, invalidVals, nil)
+			valid = smorph.Erode(valid, smorph.Rectangle(2*rx+1, 2*ry+1))
+			mask, _ = simage.ValidMask(valid, simage.InvalidValues{{Lo: 0, Hi: 0}}, mask)
+		}
+		src = sfilter.Apply(src, filt)
+		if mask != nil && mask.Bounds().Size() != size {
+			trim := image.Pt(rx, ry)
+			mask, err = sgeom.Crop(mask, image.Rectangle{Min: trim,
+				Max: trim.Add(size)})
+			if err != nil {
+				fmt.Println("Error cropping mask:", err)
+				os.Exit(1)
+			}
+		}
+		if *v {
+			fmt.Println("source image filtered")
+		}
+	}
+
+	if len(rotAngles) > 0 && borderMode == sfilter.Crop {
+		fmt.Println("Error: -rot can't be used with a cropping border")
+		os.Exit(1)
+	}
+	interpMode, err := sgeom.ParseInterpolation(*interp)
+	if err != nil {
+		fmt.Println("Error parsing interpolation:", err)
+		os.Exit(1)
+	}
+	if *geom != "" {
+		transform, err := sgeom.ParseTransforms(*geom, interpMode, borderMode)
+		if err != nil {
+			fmt.Println("Error parsing geometric transforms:", err)
+			os.Exit(1)
+		}
+		src, err = transform(src)
+		if err != nil {
+			fmt.Println("Error transforming image:", err)
+			os.Exit(1)
+		}
+		// The mask is transformed without mixing included and excluded
+		// pixels, and pixels from beyond the input's edges are excluded.
+		if mask != nil {
+			maskTransform, err := sgeom.ParseTransforms(*geom, sgeom.Nearest,
+				sfilter.Zero)
+			if err != nil {
+				fmt.Println("Error parsing geometric transforms:", err)
+				os.Exit(1)
+			}
+			mask, err = maskTransform(mask)
+			if err != nil {
+				fmt.Println("Error transforming mask:", err)
+				os.Exit(1)
+			}
+		}
+		if *v {
+			fmt.Println("source image transformed")
+		}
+	}
+
+	if *maskOps != "" {
+		if mask == nil {
+			fmt.Println("Error: -maskops needs -mask or -invalid")
+			os.Exit(1)
+		}
+		op, err := smorph.ParseOps(*maskOps)
+		if err != nil {
+			fmt.Println("Error parsing mask operations:", err)
+			os.Exit(1)
+		}
+		mask = op(smorph.Binary(mask))
+		mname := *out + "_mask.png"
+		err = mask.Write(&mname)
+		if err != nil {
+			fmt.Println("Error writing mask image:", err)
+			os.Exit(1)
+		}
+	}
+	if mask != nil && *boot > 0 {
+		fmt.Println("Error: -mask and -invalid can't be used with the " +
+			"bootstrap")
+		os.Exit(1)
+	}
+	// The scale levels are computed from the whole image with floating-point
+	// gradients.
+	if (mask != nil || *i32) && (*pyramid > 0 || len(scaleSigmas) > 0) {
+		fmt.Println("Error: -mask, -invalid and -i can't be used with " +
+			"-scales, -octaves or -pyramid")
+		os.Exit(1)
+	}
+	delOpts := sentropy.DelentropyOptions{
+		ExcludeZero:  *noDC,
+		NoiseFloor:   *floor,
+		RadialWeight: *radial,
+	}
+	filterDel := delOpts != (sentropy.DelentropyOptions{})
+	if filterDel {
+		if *pr > 0 || *boot > 0 {
+			fmt.Println("Error: -nodc, -floor and -radial can't be used " +
+				"with a polar histogram or the bootstrap")
+			os.Exit(1)
+		}
+		if !(*floor >= 0) {
+			fmt.Println("Error: -floor must be non-negative")
+			os.Exit(1)
+		}
+		// The radial weights apply only to the delentropy itself.
+		if *radial && (len(renyiOrders) > 0 || len(tsallisOrders) > 0 ||
+			*de || *hde) {
+			fmt.Println("Error: -radial can't be used with -renyi, " +
+				"-tsallis, -de or -hde")
+			os.Exit(1)
+		}
+	}
+	bootOpts := sentropy.BootstrapOptions{
+		Iterations: *boot,
+		BlockSize:  *bootBlock,
+		Seed:       *bootSeed,
+		Level:      *bootLevel,
+	}
+	if *boot > 0 {
+		// The gradient, which is also resampled, is one pixel smaller.
+		srcSize := src.Bounds().Size()
+		if err := bootOpts.Validate(srcSize.X-1, srcSize.Y-1); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *rnd {
+		rndName := *out + "_render.png"
+		err = disp.Render(src).Write(&rndName)
+		if err != nil {
+			fmt.Println("Error writing rendered image:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *eq {
+		eqName := *out + "_eq.png"
+		err = forDisplay(shist.Equalise(src)).Write(&eqName)
+		if err != nil {
+			fmt.Println("Error writing equalised image:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *match != "" {
+		ref, err := simage.Read(*match)
+		if err != nil {
+			fmt.Println("Error reading reference image:", err)
+			os.Exit(1)
+		}
+		matchName := *out + "_match.png"
+		err = forDisplay(shist.MatchHistogram(src, ref)).Write(&matchName)
+		if err != nil {
+			fmt.Println("Error writing matched image:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *clahe {
+		var tilesX, tilesY int
+		_, err = fmt.Sscanf(*claheTiles, "%dx%d", &tilesX, &tilesY)
+		if err != nil {
+			fmt.Println("Error parsing CLAHE tile grid:", err)
+			os.Exit(1)
+		}
+		enhanced, err := shist.CLAHE(src, tilesX, tilesY, *claheClip)
+		if err != nil {
+			fmt.Println("Error computing CLAHE:", err)
+			os.Exit(1)
+		}
+		claheName := *out + "_clahe.png"
+		err = forDisplay(enhanced).Write(&claheName)
+		if err != nil {
+			fmt.Println("Error writing CLAHE image:", err)
+			os.Exit(1)
+		}
+	}
 
 	if *thb {
-		thumb := src.Thumbnail()
+		thumb := disp.Thumbnail(src)
 		if *v {
 			fmt.Println("Thumbnail generated")
 		}
@@ -93,10 +566,16 @@ func main() {
 		}
 	}
 
-	grad := sgrad.Fdgrad(src)
+	grad, gmask := gradient(src, mask, *i32)
 	if *v {
 		fmt.Println("gradient image computed")
 	}
+	// The masked gradient is 0 outside the region, which would bias the
+	// structure tensor and the integrated image, so they use the whole image.
+	wholeGrad := grad
+	if gmask != nil && (*integ != "" || *st || *sti) {
+		wholeGrad, _ = gradient(src, nil, *i32)
+	}
 
 	if *grd {
 		re, im := grad.Render()
@@ -114,7 +593,89 @@ func main() {
 		}
 	}
 
-	hist := shist.Hist(grad)
+	if *integ != "" {
+		var rec *simage.FloatImage
+		switch *integ {
+		case "fft":
+			rec = sgrad.IntegrateFFT(wholeGrad)
+		case "dct":
+			rec = sgrad.IntegrateDCT(wholeGrad)
+		default:
+			fmt.Println("Error: -pi must be fft or dct")
+			os.Exit(1)
+		}
+		sgrad.MatchLatticeMeans(rec, src)
+		recName := *out + "_recon.png"
+		err = forDisplay(rec.Quantise(src.Bpp())).Write(&recName)
+		if err != nil {
+			fmt.Println("Error writing reconstructed image:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *canny {
+		writeCanny(src, cannyOpts, *out)
+	}
+
+	if *st {
+		gt := stensor.GlobalTensor(wholeGrad)
+		l1, l2 := gt.Eigenvalues()
+		fmt.Println("Structure tensor eigenvalues:", l1, l2)
+		fmt.Println("Structure tensor orientation, coherence, anisotropy:",
+			gt.Orientation(), gt.Coherence(), gt.Anisotropy())
+		axes := stensor.GradientMoments(wholeGrad)
+		fmt.Println("Gradient mean:", axes.Mean)
+		fmt.Println("Gradient principal axis angle, major, minor std dev:",
+			axes.Angle, axes.Major, axes.Minor)
+	}
+
+	if *sti {
+		field := stensor.StructureTensor(wholeGrad, *stSigma)
+		stImages := []struct {
+			img  *simage.FloatImage
+			name string
+		}{
+			{field.Orientation(), "_st_orient.png"},
+			{field.Coherence(), "_st_coher.png"},
+			{field.Anisotropy(), "_st_aniso.png"},
+		}
+		for _, sti := range stImages {
+			name := *out + sti.name
+			err = sti.img.Render().Write(&name)
+			if err != nil {
+				fmt.Println("Error writing structure tensor image:", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	polarOpts := shist.PolarOptions{
+		RadialBins:  *pr,
+		AngularBins: *pa,
+		LogRadial:   *plog,
+	}
+	// The filtered histogram replaces the plain one for everything that
+	// follows, so that all the reported values are consistent.
+	hist, filtered, err := delHist(grad, gmask, polarOpts, delOpts)
+	if err != nil {
+		fmt.Println("Error computing polar histogram:", err)
+		os.Exit(1)
+	}
+	// The orientation entropy of a polar histogram.
+	var orientEnt float64
+	if polar, ok := hist.(*shist.SippPolarHist); ok {
+		orientEnt = sentropy.OrientationEntropy(polar) *
+			norm.GreyHistFactor(polar.AngularHist())
+	}
+	if filtered != nil && !*csv {
+		fmt.Println("Pixels excluded from delentropy:", filtered.Excluded)
+	}
+	if mask != nil && !*csv {
+		gradRect := grad.Bounds()
+		fmt.Println("Invalid pixels skipped:", skipped)
+		fmt.Println("Gradient pixels excluded:",
+			gradRect.Dx()*gradRect.Dy()-hist.Total())
+	}
 
 	if *hst {
 		rhist := hist.Render(true)
@@ -137,36 +698,62 @@ func main() {
 	}
 
 	sippDel := sentropy.Delentropy(hist)
-	delentropy := sippDel.Delentropy / 2.0
+	delentropy := sippDel.Normalised(norm)
+	greyHist := shist.GreyHistMasked(src, mask)
+	entFactor := norm.GreyHistFactor(greyHist)
+	delFactor := norm.DelentropyFactor(hist)
+	if *radial {
+		delentropy = filtered.Delentropy * delFactor
+	}
 
-	if *csv {
-		fmt.Printf("%s,%.2f\n", *in, delentropy)
-	} else {
+	// Values reported after the delentropy in the CSV output, in the order
+	// they are computed below.
+	var csvVals []float64
+	if !*csv {
 		fmt.Println("Delentropy:", delentropy)
 	}
-	if *hde {
-		histEntImg := sippDel.HistDelentropyImage()
-		histEntName := *out + "_hist_delent.png"
-		err = histEntImg.Write(&histEntName)
-		if err != nil {
-			fmt.Println("Error writing the histogram delentropy image", err)
-			os.Exit(1)
+	if *pr > 0 {
+		csvVals = append(csvVals, orientEnt)
+		if !*csv {
+			fmt.Println("Orientation entropy:", orientEnt)
 		}
 	}
-
-	if *de {
-		delEntImg := sippDel.DelEntropyImage()
-		delEntName := *out + "_delent.png"
-		err = delEntImg.Write(&delEntName)
-		if err != nil {
-			fmt.Println("Error writing the delentropy image", err)
-			os.Exit(1)
+	for _, alpha := range renyiOrders {
+		re := sentropy.RenyiEntropy(greyHist, alpha) * entFactor
+		rd := sentropy.RenyiDelentropy(hist, alpha) * delFactor
+		csvVals = append(csvVals, re, rd)
+		if !*csv {
+			fmt.Printf("Rényi entropy, delentropy (alpha=%v): %v, %v\n", alpha, re, rd)
 		}
 	}
-
-	ent := sentropy.Entropy(src)
+	for _, q := range tsallisOrders {
+		// Tsallis entropies have no units and are not additive, so neither
+		// the unit nor the pair factor applies, and they are always reported
+		// unnormalised.
+		te := sentropy.TsallisEntropy(greyHist, q)
+		td := sentropy.TsallisDelentropy(hist, q)
+		csvVals = append(csvVals, te, td)
+		if !*csv {
+			fmt.Printf("Tsallis entropy, delentropy (q=%v): %v, %v\n", q, te, td)
+		}
+	}
+	if *boot > 0 {
+		be := sentropy.BootstrapEntropy(src, bootOpts).Scale(entFactor)
+		bd := sentropy.BootstrapDelentropy(grad, bootOpts).Scale(delFactor)
+		csvVals = append(csvVals, be.Mean, be.StdErr, be.Lower, be.Upper,
+			bd.Mean, bd.StdErr, bd.Lower, bd.Upper)
+		if !*csv {
+			fmt.Printf("Bootstrap entropy mean, standard error, %v%% interval: "+
+				"%v, %v, [%v, %v]\n", *bootLevel*100, be.Mean, be.StdErr,
+				be.Lower, be.Upper)
+			fmt.Printf("Bootstrap delentropy mean, standard error, %v%% interval: "+
+				"%v, %v, [%v, %v]\n", *bootLevel*100, bd.Mean,
+				bd.StdErr, bd.Lower, bd.Upper)
+		}
+	}
+	ent := sentropy.EntropyMasked(src, mask)
 	if *v {
-		fmt.Println("Conventional entropy of the source image:", ent)
+		fmt.Println("Conventional entropy of the source image:", ent.Normalised(norm))
 	}
 
 	entImg := ent.EntropyImage()
@@ -184,6 +771,101 @@ func main() {
 		fmt.Println("fft computed")
 	}
 
+	if *se {
+		if *seRad < 1 || *seAng < 1 {
+			fmt.Println("Error: -ser and -sea must be at least 1")
+			os.Exit(1)
+		}
+		spec := sfft.SpectralEntropy(fft, *seRad, *seAng)
+		entVal := ent.Normalised(norm)
+		specEnt := spec.Entropy * norm.DistributionFactor(spec.Frequencies)
+		radEnt := spec.RadialEntropy * norm.DistributionFactor(*seRad)
+		angEnt := spec.AngularEntropy * norm.DistributionFactor(*seAng)
+		csvVals = append(csvVals, entVal, specEnt, radEnt, angEnt)
+		if !*csv {
+			if !*v {
+				fmt.Println("Conventional entropy of the source image:", entVal)
+			}
+			fmt.Println("Spectral entropy:", specEnt)
+			fmt.Println("Radial spectral entropy:", radEnt)
+			fmt.Println("Angular spectral entropy:", angEnt)
+		}
+		if *v {
+			fmt.Println("Radial power profile:", spec.RadialPower)
+			fmt.Println("Angular power profile:", spec.AngularPower)
+		}
+	}
+
+	if *cmp != "" {
+		cmpSrc, err := simage.Read(*cmp)
+		if err != nil {
+			fmt.Println("Error reading comparison image:", err)
+			os.Exit(1)
+		}
+		joint, err := shist.JointHist(src, cmpSrc, *jb, *jb)
+		if err != nil {
+			fmt.Println("Error computing joint histogram:", err)
+			os.Exit(1)
+		}
+		mi := sentropy.MutualInformation(joint).Normalised(norm)
+		csvVals = append(csvVals, mi.JointEntropy, mi.CondEntropyAB,
+			mi.CondEntropyBA, mi.MutualInfo, mi.NormMutualInfo,
+			mi.SymUncertainty)
+		if !*csv {
+			fmt.Println("Joint entropy:", mi.JointEntropy)
+			fmt.Println("Conditional entropies H(in|cmp), H(cmp|in):",
+				mi.CondEntropyAB, mi.CondEntropyBA)
+			fmt.Println("Mutual information:", mi.MutualInfo)
+			fmt.Println("Normalised mutual information:", mi.NormMutualInfo)
+			fmt.Println("Symmetric uncertainty:", mi.SymUncertainty)
+		}
+		if *jh {
+			jointImg := joint.Render(false)
+			jointName := *out + "_joint_hist.png"
+			err = jointImg.Write(&jointName)
+			if err != nil {
+				fmt.Println("Error writing joint histogram image:", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if *csv {
+		// The orientation entropy of a polar histogram follows the
+		// delentropy. Then come the generalised entropies, as pairs of
+		// entropy and delentropy, Rényi orders first, then Tsallis orders.
+		// Then come the bootstrap mean, standard error, lower and upper
+		// bounds, first for the entropy and then for the delentropy. Then
+		// come the conventional entropy and the spectral, radial and
+		// angular spectral entropies, and last the joint entropy, the two
+		// conditional entropies, the mutual information, the normalised
+		// mutual information and the symmetric uncertainty with the
+		// comparison image.
+		fmt.Printf("%s,%.2f", *in, delentropy)
+		for _, val := range csvVals {
+			fmt.Printf(",%.4f", val)
+		}
+		fmt.Println()
+	}
+	if *hde {
+		histEntImg := sippDel.HistDelentropyImage()
+		histEntName := *out + "_hist_delent.png"
+		err = histEntImg.Write(&histEntName)
+		if err != nil {
+			fmt.Println("Error writing the histogram delentropy image", err)
+			os.Exit(1)
+		}
+	}
+
+	if *de {
+		delEntImg := sippDel.DelEntropyImage()
+		delEntName := *out + "_delent.png"
+		err = delEntImg.Write(&delEntName)
+		if err != nil {
+			fmt.Println("Error writing the delentropy image", err)
+			os.Exit(1)
+		}
+	}
+
 	if *f {
 		re, im := fft.Render()
 		reName := *out + "_fft_real.png"
@@ -211,8 +893,289 @@ func main() {
 		}
 	}
 
+	var levels []*sscale.Level
+	if *pyramid > 0 {
+		levels = sscale.Pyramid(src, *pyramid)
+	} else if len(scaleSigmas) > 0 {
+		levels = sscale.ScaleSpace(src, scaleSigmas)
+	}
+	if levels != nil {
+		writeScales(levels, norm, *out)
+	}
+
+	if *wav != "" {
+		writeWavelet(src, *wav, *wavLevels, norm, *out)
+	}
+
+	if *bitSweep != "" {
+		red, err := simage.ParseReduction(*bitSweep)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		writeBitSweep(src, mask, *i32, red, norm, *out)
+	}
+
+	if len(rotAngles) > 0 {
+		writeRotSweep(src, mask, *i32, rotAngles, interpMode, borderMode,
+			polarOpts, delOpts, norm, *out)
+	}
+
 	elapsed := time.Since(start)
 	if *v {
 		fmt.Println("Elapsed time:" + elapsed.String())
 	}
 }
+
+// writeScales writes the delentropy curve of the given levels to a CSV file,
+// with a row of standard deviation, size reduction factor and delentropy for
+// each level, and writes a delentropy image for each level.
+func writeScales(levels []*sscale.Level, norm sentropy.Normalisation, out string) {
+	csvName := out + "_scales.csv"
+	csvFile, err := os.Create(csvName)
+	if err != nil {
+		fmt.Println("Error creating scales CSV file:", err)
+		os.Exit(1)
+	}
+	defer csvFile.Close()
+	fmt.Fprintln(csvFile, "sigma,factor,delentropy")
+	sigmas, delentropies := sscale.Curve(levels, norm)
+	for i, lev := range levels {
+		fmt.Fprintf(csvFile, "%.4f,%d,%.4f\n", sigmas[i], lev.Factor,
+			delentropies[i])
+		delEntImg := lev.Delentropy.DelEntropyImage()
+		delEntName := fmt.Sprintf("%s_scale%d_delent.png", out, i)
+		err = delEntImg.Write(&delEntName)
+		if err != nil {
+			fmt.Println("Error writing a scale delentropy image", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// writeCanny detects the edges in the image with the given options and writes
+// the edge and edge orientation images.
+func writeCanny(src simage.SippImage, opts sedge.CannyOptions, out string) {
+	edges, err := sedge.Canny(src, opts)
+	if err != nil {
+		fmt.Println("Error detecting edges:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Canny thresholds:", edges.Low, edges.High)
+	edgeName := out + "_canny.png"
+	err = edges.Edges.Write(&edgeName)
+	if err != nil {
+		fmt.Println("Error writing Canny edge image:", err)
+		os.Exit(1)
+	}
+	orientName := out + "_canny_orient.png"
+	err = edges.Orientation.Render().Write(&orientName)
+	if err != nil {
+		fmt.Println("Error writing Canny orientation image:", err)
+		os.Exit(1)
+	}
+}
+
+// writeWavelet decomposes the image with the named wavelet, reports the
+// energy and normalised entropy of each level, and writes the subband mosaic.
+func writeWavelet(src simage.SippImage, name string, levels int,
+	norm sentropy.Normalisation, out string) {
+	wavelet, err := swavelet.ByName(name)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if levels == 0 {
+		levels = swavelet.MaxLevels(wavelet, src.Bounds().Dx(), src.Bounds().Dy())
+	}
+	dec, err := swavelet.Decompose(src, wavelet, levels)
+	if err != nil {
+		fmt.Println("Error computing wavelet decomposition:", err)
+		os.Exit(1)
+	}
+	stats := dec.Stats()
+	for _, lev := range stats.Levels {
+		// The level entropy is of a distribution over the coefficients of
+		// its three detail subbands.
+		var numCoeffs int
+		for band := swavelet.HL; band <= swavelet.HH; band++ {
+			r := dec.Subband(lev.Level, band)
+			numCoeffs += r.Dx() * r.Dy()
+		}
+		fmt.Printf("Wavelet level %d energy, relative energy, entropy: %v, %v, %v\n",
+			lev.Level, lev.Energy, lev.RelativeEnergy,
+			lev.Entropy*norm.DistributionFactor(numCoeffs))
+	}
+	fmt.Println("Wavelet approximation energy:", stats.ApproxEnergy)
+	// The wavelet entropy is of a distribution over the detail levels and
+	// the approximation.
+	fmt.Println("Wavelet entropy:",
+		stats.Entropy*norm.DistributionFactor(len(stats.Levels)+1))
+	mosName := out + "_wavelet.png"
+	err = dec.Mosaic().Write(&mosName)
+	if err != nil {
+		fmt.Println("Error writing wavelet mosaic image:", err)
+		os.Exit(1)
+	}
+}
+
+// gradient computes the gradient of the image restricted to the mask, in
+// int32 arithmetic if i32 is true, and returns it with its gradient mask. With
+// no mask, these are the same as the unmasked functions and the gradient mask
+// is nil.
+func gradient(src, mask simage.SippImage,
+	i32 bool) (scomplex.SippComplexImage, simage.SippImage) {
+	if i32 {
+		return sgrad.FdgradInt32Masked(src, mask)
+	}
+	return sgrad.FdgradMasked(src, mask)
+}
+
+// delHist computes the histogram of the gradient over the gradient mask that
+// the delentropy is taken from: a polar histogram if the polar options have
+// radial bins, the filtered histogram if the delentropy options select any
+// filtering, and the Cartesian histogram otherwise. The filtered result is
+// returned too, or nil without filtering. The error is that of an invalid
+// polar histogram.
+func delHist(grad scomplex.SippComplexImage, gmask simage.SippImage,
+	polarOpts shist.PolarOptions, delOpts sentropy.DelentropyOptions) (
+	shist.SippHist, *sentropy.SippFilteredDelentropy, error) {
+	if polarOpts.RadialBins > 0 {
+		polar, err := shist.PolarHistMasked(grad, gmask, polarOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return polar, nil, nil
+	}
+	if delOpts != (sentropy.DelentropyOptions{}) {
+		delOpts.Mask = gmask
+		filtered := sentropy.FilteredDelentropy(grad, delOpts)
+		return filtered.Hist, filtered, nil
+	}
+	return shist.HistMasked(grad, gmask), nil, nil
+}
+
+// writeBitSweep reduces the image to each bit depth from 1 to its own with
+// the given reduction, and reports and writes to a CSV file the entropy and
+// delentropy at each depth, over the pixels selected by the mask and with
+// int32 gradients if i32 is true.
+func writeBitSweep(src, mask simage.SippImage, i32 bool, red simage.Reduction,
+	norm sentropy.Normalisation, out string) {
+	csvName := out + "_bits.csv"
+	csvFile, err := os.Create(csvName)
+	if err != nil {
+		fmt.Println("Error creating bit depth CSV file:", err)
+		os.Exit(1)
+	}
+	defer csvFile.Close()
+	fmt.Fprintln(csvFile, "bits,entropy,delentropy")
+	for bits := 1; bits <= src.Bpp(); bits++ {
+		im := simage.ReduceDepth(src, bits, red)
+		ent := sentropy.EntropyMasked(im, mask).Normalised(norm)
+		dent := sentropy.Delentropy(shist.HistMasked(gradient(im, mask, i32))).Normalised(norm)
+		fmt.Printf("%d-bit entropy, delentropy: %v, %v\n", bits, ent, dent)
+		fmt.Fprintf(csvFile, "%d,%.4f,%.4f\n", bits, ent, dent)
+	}
+}
+
+// writeRotSweep rotates the image by each of the given angles and crops the
+// results to the largest centred rectangle that lies within the image at
+// every angle, so that all the angles see the same content. It reports and
+// writes to a CSV file the delentropy at each angle and its deviation from
+// the mean over the angles, reports the relative range of the delentropy, and
+// writes a montage of thumbnails of the histograms in the order of the
+// angles. The mask is rotated with the image, and the histograms are computed
+// by delHist, with int32 gradients if i32 is true, as for the delentropy of
+// the whole image.
+func writeRotSweep(src, mask simage.SippImage, i32 bool, angles []float64,
+	interp sgeom.Interpolation, border sfilter.Border,
+	polarOpts shist.PolarOptions, delOpts sentropy.DelentropyOptions,
+	norm sentropy.Normalisation, out string) {
+	size := src.Bounds().Size()
+	rect := image.Rect(0, 0, size.X, size.Y)
+	for _, angle := range angles {
+		rect = rect.Intersect(sgeom.InscribedRect(size.X, size.Y, angle))
+	}
+	// The gradient needs at least 2 pixels in each direction.
+	if rect.Dx() < 2 || rect.Dy() < 2 {
+		fmt.Println("Error: the image is too small to rotate by these angles")
+		os.Exit(1)
+	}
+	dents := make([]float64, len(angles))
+	thumbs := make([]simage.SippImage, len(angles))
+	var mean float64
+	for i, angle := range angles {
+		rotated, err := sgeom.Crop(sgeom.Rotate(src, angle, interp, border), rect)
+		if err != nil {
+			fmt.Println("Error cropping rotated image:", err)
+			os.Exit(1)
+		}
+		var rmask simage.SippImage
+		if mask != nil {
+			rmask, err = sgeom.Crop(sgeom.RotateMask(mask, angle, interp, border), rect)
+			if err != nil {
+				fmt.Println("Error cropping rotated mask:", err)
+				os.Exit(1)
+			}
+		}
+		grad, gmask := gradient(rotated, rmask, i32)
+		hist, filtered, err := delHist(grad, gmask, polarOpts, delOpts)
+		if err != nil {
+			fmt.Println("Error computing polar histogram:", err)
+			os.Exit(1)
+		}
+		dents[i] = sentropy.Delentropy(hist).Normalised(norm)
+		if delOpts.RadialWeight {
+			dents[i] = filtered.Delentropy * norm.DelentropyFactor(hist)
+		}
+		thumbs[i] = hist.Render(true).Thumbnail()
+		mean += dents[i]
+	}
+	mean /= float64(len(angles))
+
+	csvName := out + "_rot.csv"
+	csvFile, err := os.Create(csvName)
+	if err != nil {
+		fmt.Println("Error creating rotation CSV file:", err)
+		os.Exit(1)
+	}
+	defer csvFile.Close()
+	fmt.Fprintln(csvFile, "angle,delentropy,deviation")
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i, angle := range angles {
+		fmt.Printf("Delentropy at %v degrees: %v\n", angle, dents[i])
+		fmt.Fprintf(csvFile, "%v,%.4f,%.4f\n", angle, dents[i], dents[i]-mean)
+		lo = math.Min(lo, dents[i])
+		hi = math.Max(hi, dents[i])
+	}
+	fmt.Println("Rotation region:", rect)
+	fmt.Println("Rotation delentropy mean, range:", mean, hi-lo)
+	if mean != 0 {
+		fmt.Println("Rotation delentropy relative range:", (hi-lo)/mean)
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(len(thumbs)))))
+	montName := out + "_rot_hist.png"
+	err = simage.Montage(thumbs, cols, 2).Write(&montName)
+	if err != nil {
+		fmt.Println("Error writing rotation histogram montage:", err)
+		os.Exit(1)
+	}
+}
+
+// parseFloats parses a comma-separated list of numbers, such as entropy
+// orders, scales or angles. An empty string gives an empty list.
+func parseFloats(list string) ([]float64, error) {
+	var vals []float64
+	if list == "" {
+		return vals, nil
+	}
+	for _, field := range strings.Split(list, ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
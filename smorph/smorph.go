@@ -0,0 +1,644 @@
+// Copyright Raul Vera 2015-2021
+
+// Package smorph provides mathematical morphology for the sipp package:
+// erosion, dilation, opening, closing, top-hats, the morphological gradient
+// and reconstruction, with configurable structuring elements.
+//
+// The operators work directly on the integer values of 8 and 16-bit images
+// and return images of the same depth. Binary morphology is the same
+// operators applied to binary images, such as masks, whose pixels are 0 or
+// 255; Binary converts any mask to that form. Pixels beyond the edges of the
+// image are ignored, so that erosion and dilation don't spread the edges
+// inwards and opening and closing remain idempotent.
+//
+// Rectangular structuring elements use the van Herk/Gil-Werman algorithm,
+// which takes 3 comparisons per pixel for each dimension whatever the size of
+// the rectangle. Other elements compare every pixel of the element.
+package smorph
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+import (
+	. "github.com/Causticity/sipp/simage"
+)
+
+// An Element is a structuring element, a set of pixels with its origin at
+// Width/2, Height/2.
+type Element struct {
+	Width, Height int
+	// Whether each pixel is in the element, in row-major order.
+	Mask []bool
+	// Whether every pixel is in the element, for the fast path.
+	rect bool
+}
+
+// NewElement returns an element with the given pixels in row-major order. The
+// width and height must be odd.
+func NewElement(width, height int, mask []bool) *Element {
+	if width < 1 || height < 1 || width%2 == 0 || height%2 == 0 ||
+		len(mask) != width*height {
+		panic("Element dimensions must be odd and match the mask!")
+	}
+	el := &Element{Width: width, Height: height, Mask: mask, rect: true}
+	for _, in := range mask {
+		if !in {
+			el.rect = false
+			break
+		}
+	}
+	return el
+}
+
+// ElementFromImage returns the element whose pixels are the non-zero pixels
+// of the image, whose width and height must be odd.
+func ElementFromImage(im SippImage) *Element {
+	size := im.Bounds().Size()
+	return NewElement(size.X, size.Y, MaskIncluded(im, size))
+}
+
+// Rectangle returns the element that is a full rectangle of the given odd
+// width and height.
+func Rectangle(width, height int) *Element {
+	if width < 1 || height < 1 {
+		panic("Element dimensions must be odd and match the mask!")
+	}
+	mask := make([]bool, width*height)
+	for i := range mask {
+		mask[i] = true
+	}
+	return NewElement(width, height, mask)
+}
+
+// Square returns the element that is the square of side 2 radius + 1.
+func Square(radius int) *Element {
+	return Rectangle(2*radius+1, 2*radius+1)
+}
+
+// Disk returns the element of pixels whose centres are no further than the
+// radius from the origin.
+func Disk(radius int) *Element {
+	side := 2*radius + 1
+	mask := make([]bool, 0, side*side)
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			mask = append(mask, x*x+y*y <= radius*radius)
+		}
+	}
+	return NewElement(side, side, mask)
+}
+
+// Line returns the element that is a digital line segment of the given odd
+// length in pixels, centred on the origin, at the given angle in degrees,
+// measured from the x axis towards the y axis, which points down the image.
+// The length is measured along whichever axis the line is closer to.
+func Line(length int, angle float64) *Element {
+	if length < 1 || length%2 == 0 {
+		panic("Line length must be odd!")
+	}
+	half := length / 2
+	rad := angle * math.Pi / 180
+	dx, dy := math.Cos(rad), math.Sin(rad)
+	// Step one pixel at a time along the dominant axis.
+	if math.Abs(dx) >= math.Abs(dy) {
+		dx, dy = 1, dy/dx
+	} else {
+		dx, dy = dx/dy, 1
+	}
+	points := make([]image.Point, 0, length)
+	var rx, ry int
+	for t := -half; t <= half; t++ {
+		p := image.Pt(int(math.Round(float64(t)*dx)), int(math.Round(float64(t)*dy)))
+		points = append(points, p)
+		if p.X > rx {
+			rx = p.X
+		}
+		if p.Y > ry {
+			ry = p.Y
+		}
+	}
+	width, height := 2*rx+1, 2*ry+1
+	mask := make([]bool, width*height)
+	for _, p := range points {
+		mask[(p.Y+ry)*width+p.X+rx] = true
+	}
+	return NewElement(width, height, mask)
+}
+
+// reflect returns the element reflected through its origin.
+func (el *Element) reflect() *Element {
+	mask := make([]bool, len(el.Mask))
+	for i, in := range el.Mask {
+		mask[len(mask)-1-i] = in
+	}
+	return &Element{el.Width, el.Height, mask, el.rect}
+}
+
+// ParseElement parses an element specification of the form shape:parameters,
+// one of square:radius, rect:width,height, disk:radius or
+// line:length,angle.
+func ParseElement(spec string) (*Element, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("Structuring element must be given as " +
+			"shape:parameters")
+	}
+	var params []float64
+	for _, field := range strings.Split(parts[1], ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid element parameter %q: %v", field, err)
+		}
+		params = append(params, val)
+	}
+	numParams := 1
+	if parts[0] == "rect" || parts[0] == "line" {
+		numParams = 2
+	}
+	if len(params) != numParams {
+		return nil, fmt.Errorf("Element %q takes %d parameters", parts[0], numParams)
+	}
+	// All but the angle of a line are sizes.
+	sizes := params
+	if parts[0] == "line" {
+		sizes = params[:1]
+	}
+	for _, size := range sizes {
+		if size < 0 || size != math.Trunc(size) {
+			return nil, fmt.Errorf("Element %q takes non-negative integer "+
+				"sizes", parts[0])
+		}
+	}
+	switch parts[0] {
+	case "square":
+		return Square(int(params[0])), nil
+	case "disk":
+		return Disk(int(params[0])), nil
+	case "rect", "line":
+		for _, size := range sizes {
+			if int(size)%2 == 0 {
+				return nil, fmt.Errorf("Element %q takes odd sizes", parts[0])
+			}
+		}
+		if parts[0] == "rect" {
+			return Rectangle(int(params[0]), int(params[1])), nil
+		}
+		return Line(int(params[0]), params[1]), nil
+	}
+	return nil, fmt.Errorf("Unknown element %q; use square, rect, disk or "+
+		"line", parts[0])
+}
+
+// A plane holds the values of an image for processing.
+type plane struct {
+	pix           []int32
+	width, height int
+	bpp           int
+}
+
+func newPlane(src SippImage) *plane {
+	size := src.Bounds().Size()
+	p := &plane{make([]int32, 0, size.X*size.Y), size.X, size.Y, src.Bpp()}
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			p.pix = append(p.pix, src.IntVal(x, y))
+		}
+	}
+	return p
+}
+
+// maxVal returns the largest value at the plane's depth.
+func (p *plane) maxVal() int32 {
+	return int32(1)<<uint(p.bpp) - 1
+}
+
+// complement replaces each value by its difference from the largest value,
+// which turns dilation into erosion.
+func (p *plane) complement() *plane {
+	maxVal := p.maxVal()
+	for i, val := range p.pix {
+		p.pix[i] = maxVal - val
+	}
+	return p
+}
+
+// image returns the plane as an image of its depth.
+func (p *plane) image() SippImage {
+	rect := image.Rect(0, 0, p.width, p.height)
+	if p.bpp == 8 {
+		dst := new(SippGray)
+		dst.Gray = image.NewGray(rect)
+		for i, val := range p.pix {
+			dst.Gray.Pix[i] = uint8(val)
+		}
+		return dst
+	}
+	dst := new(SippGray16)
+	dst.Gray16 = image.NewGray16(rect)
+	for i, val := range p.pix {
+		dst.Gray16.Pix[2*i] = uint8(val >> 8)
+		dst.Gray16.Pix[2*i+1] = uint8(val)
+	}
+	return dst
+}
+
+// erode returns the erosion of the plane by the element, in which each pixel
+// is the minimum of the pixels covered by the element placed with its origin
+// there.
+func erode(src *plane, el *Element) *plane {
+	if el.rect {
+		return erodeRect(src, el.Width/2, el.Height/2)
+	}
+	rx, ry := el.Width/2, el.Height/2
+	var offsets []image.Point
+	for i, in := range el.Mask {
+		if in {
+			offsets = append(offsets, image.Pt(i%el.Width-rx, i/el.Width-ry))
+		}
+	}
+	res := &plane{make([]int32, len(src.pix)), src.width, src.height, src.bpp}
+	for y := 0; y < src.height; y++ {
+		for x := 0; x < src.width; x++ {
+			min := int32(math.MaxInt32)
+			for _, off := range offsets {
+				sx, sy := x+off.X, y+off.Y
+				if sx < 0 || sy < 0 || sx >= src.width || sy >= src.height {
+					continue
+				}
+				if val := src.pix[sy*src.width+sx]; val < min {
+					min = val
+				}
+			}
+			// With an element that doesn't contain its origin, a pixel may
+			// cover nothing in the image.
+			if min == math.MaxInt32 {
+				min = src.maxVal()
+			}
+			res.pix[y*src.width+x] = min
+		}
+	}
+	return res
+}
+
+// dilate returns the dilation of the plane by the element, in which each
+// pixel is the maximum of the pixels covered by the reflected element placed
+// with its origin there. It is the complement of the erosion of the
+// complement by the reflected element.
+func dilate(src *plane, el *Element) *plane {
+	comp := &plane{append([]int32(nil), src.pix...), src.width, src.height, src.bpp}
+	return erode(comp.complement(), el.reflect()).complement()
+}
+
+// erodeRect erodes the plane by the rectangle extending rx pixels left and
+// right and ry pixels up and down, as a pass along the rows followed by a pass
+// along the columns.
+func erodeRect(src *plane, rx, ry int) *plane {
+	res := &plane{append([]int32(nil), src.pix...), src.width, src.height, src.bpp}
+	if rx > 0 {
+		run := newMinRun(src.width, rx)
+		for y := 0; y < src.height; y++ {
+			run.apply(res.pix[y*src.width:], 1)
+		}
+	}
+	if ry > 0 {
+		run := newMinRun(src.height, ry)
+		for x := 0; x < src.width; x++ {
+			run.apply(res.pix[x:], src.width)
+		}
+	}
+	return res
+}
+
+// A minRun computes running minima over windows of 2 radius + 1 values along
+// lines of n values by the van Herk/Gil-Werman algorithm. The line is padded
+// by radius values on each side, and up to a whole number of windows, with
+// the largest int32, so that values beyond the ends are ignored. The padded
+// line is divided into blocks of the window size. Each window then spans the
+// end of one block and the start of the next, so its minimum is the lesser of
+// the minimum from its start to the end of the first block and the minimum
+// from the start of the second block to its end, which are precomputed.
+type minRun struct {
+	n, radius      int
+	line, pre, suf []int32
+}
+
+func newMinRun(n, radius int) *minRun {
+	k := 2*radius + 1
+	length := (n + 2*radius + k - 1) / k * k
+	return &minRun{n, radius, make([]int32, length), make([]int32, length),
+		make([]int32, length)}
+}
+
+// apply replaces the n values of vals at the given stride by their running
+// minima.
+func (run *minRun) apply(vals []int32, stride int) {
+	k := 2*run.radius + 1
+	line, pre, suf := run.line, run.pre, run.suf
+	for i := range line {
+		line[i] = math.MaxInt32
+	}
+	for i := 0; i < run.n; i++ {
+		line[i+run.radius] = vals[i*stride]
+	}
+	for i, val := range line {
+		if i%k == 0 || val < pre[i-1] {
+			pre[i] = val
+		} else {
+			pre[i] = pre[i-1]
+		}
+	}
+	for i := len(line) - 1; i >= 0; i-- {
+		if i%k == k-1 || line[i] < suf[i+1] {
+			suf[i] = line[i]
+		} else {
+			suf[i] = suf[i+1]
+		}
+	}
+	for i := 0; i < run.n; i++ {
+		min := suf[i]
+		if pre[i+k-1] < min {
+			min = pre[i+k-1]
+		}
+		vals[i*stride] = min
+	}
+}
+
+// Erode returns the erosion of the image by the element, in which each pixel
+// is the minimum of the pixels covered by the element placed with its origin
+// there.
+func Erode(src SippImage, el *Element) SippImage {
+	return erode(newPlane(src), el).image()
+}
+
+// Dilate returns the dilation of the image by the element, in which each
+// pixel is the maximum of the pixels covered by the element reflected through
+// its origin and placed with its origin there.
+func Dilate(src SippImage, el *Element) SippImage {
+	return dilate(newPlane(src), el).image()
+}
+
+// Open returns the opening of the image by the element, its erosion followed
+// by dilation, which removes bright details that the element doesn't fit in.
+func Open(src SippImage, el *Element) SippImage {
+	return dilate(erode(newPlane(src), el), el).image()
+}
+
+// Close returns the closing of the image by the element, its dilation
+// followed by erosion, which removes dark details that the element doesn't
+// fit in.
+func Close(src SippImage, el *Element) SippImage {
+	return erode(dilate(newPlane(src), el), el).image()
+}
+
+// TopHat returns the white top-hat of the image, the difference between the
+// image and its opening, which keeps the bright details that the opening
+// removes.
+func TopHat(src SippImage, el *Element) SippImage {
+	p := newPlane(src)
+	open := dilate(erode(p, el), el)
+	for i, val := range p.pix {
+		open.pix[i] = val - open.pix[i]
+	}
+	return open.image()
+}
+
+// BlackTopHat returns the black top-hat of the image, the difference between
+// its closing and the image, which keeps the dark details that the closing
+// removes.
+func BlackTopHat(src SippImage, el *Element) SippImage {
+	p := newPlane(src)
+	closed := erode(dilate(p, el), el)
+	for i, val := range p.pix {
+		closed.pix[i] -= val
+	}
+	return closed.image()
+}
+
+// Gradient returns the morphological gradient of the image, the difference
+// between its dilation and its erosion. For a binary image, it is the pixels
+// within the element of the boundary of each region.
+func Gradient(src SippImage, el *Element) SippImage {
+	p := newPlane(src)
+	grad := dilate(p, el)
+	eroded := erode(p, el)
+	for i, val := range eroded.pix {
+		grad.pix[i] -= val
+	}
+	return grad.image()
+}
+
+// Reconstruct returns the morphological reconstruction by dilation of the
+// marker image under the mask image, the result of dilating the marker by
+// the 3x3 square repeatedly, limited each time by the mask, until it no longer
+// changes. The marker is first limited by the mask. For binary images, it
+// keeps the 8-connected regions of the mask that the marker touches. The
+// images must be the same size, and the result has the depth of the mask.
+//
+// It uses the hybrid algorithm of Vincent, "Morphological grayscale
+// reconstruction in image analysis: applications and efficient algorithms",
+// IEEE Transactions on Image Processing 2(2), 1993: a forward and backward
+// raster scan followed by propagation from a queue of the pixels that can
+// still change.
+func Reconstruct(marker, mask SippImage) SippImage {
+	if marker.Bounds().Size() != mask.Bounds().Size() {
+		panic("Marker and mask sizes differ!")
+	}
+	lim := newPlane(mask)
+	rec := newPlane(marker)
+	rec.bpp = lim.bpp
+	for i, val := range lim.pix {
+		if rec.pix[i] > val {
+			rec.pix[i] = val
+		}
+	}
+	reconstruct(rec, lim)
+	return rec.image()
+}
+
+// ReconstructByErosion returns the morphological reconstruction by erosion of
+// the marker image over the mask image, the dual of Reconstruct: the marker
+// is first limited to be no less than the mask, and then eroded repeatedly,
+// limited each time by the mask. The images must be the same size, and the
+// result has the depth of the mask.
+func ReconstructByErosion(marker, mask SippImage) SippImage {
+	if marker.Bounds().Size() != mask.Bounds().Size() {
+		panic("Marker and mask sizes differ!")
+	}
+	lim := newPlane(mask)
+	rec := newPlane(marker)
+	rec.bpp = lim.bpp
+	maxVal := lim.maxVal()
+	for i, val := range lim.pix {
+		if rec.pix[i] < val {
+			rec.pix[i] = val
+		} else if rec.pix[i] > maxVal {
+			rec.pix[i] = maxVal
+		}
+	}
+	reconstruct(rec.complement(), lim.complement())
+	return rec.complement().image()
+}
+
+// FillHoles fills the holes in the image, the dark regions that aren't
+// connected to its edges, by reconstruction by erosion from the edges. For a
+// binary mask, it includes every pixel enclosed by the mask.
+func FillHoles(src SippImage) SippImage {
+	lim := newPlane(src)
+	rec := &plane{make([]int32, len(lim.pix)), lim.width, lim.height, lim.bpp}
+	maxVal := lim.maxVal()
+	for y := 0; y < lim.height; y++ {
+		for x := 0; x < lim.width; x++ {
+			i := y*lim.width + x
+			if x == 0 || y == 0 || x == lim.width-1 || y == lim.height-1 {
+				rec.pix[i] = lim.pix[i]
+			} else {
+				rec.pix[i] = maxVal
+			}
+		}
+	}
+	reconstruct(rec.complement(), lim.complement())
+	return rec.complement().image()
+}
+
+// reconstruct reconstructs the marker under the mask, in place. The marker
+// must be no greater than the mask.
+func reconstruct(rec, lim *plane) {
+	width, height := rec.width, rec.height
+	// The neighbours visited before each pixel in a forward raster scan; the
+	// backward scan visits the opposite ones first.
+	before := [4]image.Point{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}}
+	inside := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < width && y < height
+	}
+	scan := func(x, y, sign int) {
+		i := y*width + x
+		val := rec.pix[i]
+		for _, off := range before {
+			nx, ny := x+sign*off.X, y+sign*off.Y
+			if inside(nx, ny) && rec.pix[ny*width+nx] > val {
+				val = rec.pix[ny*width+nx]
+			}
+		}
+		if val > lim.pix[i] {
+			val = lim.pix[i]
+		}
+		rec.pix[i] = val
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			scan(x, y, 1)
+		}
+	}
+	var queue []int
+	for y := height - 1; y >= 0; y-- {
+		for x := width - 1; x >= 0; x-- {
+			scan(x, y, -1)
+			i := y*width + x
+			for _, off := range before {
+				nx, ny := x-off.X, y-off.Y
+				j := ny*width + nx
+				if inside(nx, ny) && rec.pix[j] < rec.pix[i] &&
+					rec.pix[j] < lim.pix[j] {
+					queue = append(queue, i)
+					break
+				}
+			}
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		x, y := i%width, i/width
+		for ny := y - 1; ny <= y+1; ny++ {
+			for nx := x - 1; nx <= x+1; nx++ {
+				if !inside(nx, ny) {
+					continue
+				}
+				j := ny*width + nx
+				if rec.pix[j] < rec.pix[i] && rec.pix[j] != lim.pix[j] {
+					rec.pix[j] = rec.pix[i]
+					if rec.pix[j] > lim.pix[j] {
+						rec.pix[j] = lim.pix[j]
+					}
+					queue = append(queue, j)
+				}
+			}
+		}
+	}
+}
+
+// Binary returns an 8-bit binary image that is 255 where the image is
+// non-zero and 0 elsewhere, such as for converting any mask for use with the
+// operators above.
+func Binary(src SippImage) SippImage {
+	p := newPlane(src)
+	p.bpp = 8
+	for i, val := range p.pix {
+		if val != 0 {
+			p.pix[i] = 255
+		}
+	}
+	return p.image()
+}
+
+// An Op is a morphological operation on an image.
+type Op func(src SippImage) SippImage
+
+// ParseOps parses a sequence of operations separated by +, to be applied in
+// turn, and returns the operation that applies them all. Each operation is
+// either fill, for FillHoles, or op:element, where op is one of erode,
+// dilate, open, close, tophat, blacktophat or gradient, and element is as for
+// ParseElement, such as open:disk:3+fill.
+func ParseOps(spec string) (Op, error) {
+	var ops []Op
+	for _, opSpec := range strings.Split(spec, "+") {
+		if opSpec == "fill" {
+			ops = append(ops, FillHoles)
+			continue
+		}
+		parts := strings.SplitN(opSpec, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Operation %q must be fill or "+
+				"op:element", opSpec)
+		}
+		var op func(SippImage, *Element) SippImage
+		switch parts[0] {
+		case "erode":
+			op = Erode
+		case "dilate":
+			op = Dilate
+		case "open":
+			op = Open
+		case "close":
+			op = Close
+		case "tophat":
+			op = TopHat
+		case "blacktophat":
+			op = BlackTopHat
+		case "gradient":
+			op = Gradient
+		default:
+			return nil, fmt.Errorf("Unknown operation %q; use erode, dilate, "+
+				"open, close, tophat, blacktophat, gradient or fill", parts[0])
+		}
+		el, err := ParseElement(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, func(src SippImage) SippImage {
+			return op(src, el)
+		})
+	}
+	return func(src SippImage) SippImage {
+		for _, op := range ops {
+			src = op(src)
+		}
+		return src
+	}, nil
+}
@@ -0,0 +1,310 @@
+// Copyright Raul Vera 2021
+
+// Tests for package smorph.
+
+package smorph
+
+import (
+	"image"
+	"testing"
+)
+
+import (
+	. "github.com/Causticity/sipp/simage"
+	. "github.com/Causticity/sipp/sipptesting"
+)
+
+// binaryImage returns an 8-bit image from rows of '#' for 255 and '.' for 0.
+func binaryImage(rows ...string) SippImage {
+	im := new(SippGray)
+	im.Gray = image.NewGray(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				im.Gray.Pix[y*len(row)+x] = 255
+			}
+		}
+	}
+	return im
+}
+
+// sameImage reports the first pixel at which the images differ.
+func sameImage(t *testing.T, name string, got, want SippImage) {
+	t.Helper()
+	if got.Bounds() != want.Bounds() || got.Bpp() != want.Bpp() {
+		t.Fatalf("Error: %s is %v at %d bits, expected %v at %d bits", name,
+			got.Bounds(), got.Bpp(), want.Bounds(), want.Bpp())
+	}
+	size := want.Bounds().Size()
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if got.IntVal(x, y) != want.IntVal(x, y) {
+				t.Fatalf("Error: %s pixel %d,%d is %d, expected %d", name, x, y,
+					got.IntVal(x, y), want.IntVal(x, y))
+			}
+		}
+	}
+}
+
+func TestElements(t *testing.T) {
+	disk := Disk(1)
+	if disk.rect || disk.Width != 3 ||
+		disk.Mask[0] || !disk.Mask[1] || !disk.Mask[4] || disk.Mask[8] {
+		t.Errorf("Error: disk of radius 1 is %v", disk.Mask)
+	}
+	if !Square(2).rect {
+		t.Error("Error: square not detected as a rectangle")
+	}
+	if horiz := Line(5, 0); !horiz.rect || horiz.Width != 5 || horiz.Height != 1 {
+		t.Errorf("Error: horizontal line is %dx%d", horiz.Width, horiz.Height)
+	}
+	if vert := Line(5, 90); !vert.rect || vert.Width != 1 || vert.Height != 5 {
+		t.Errorf("Error: vertical line is %dx%d", vert.Width, vert.Height)
+	}
+	diag := Line(3, 45)
+	want := []bool{true, false, false, false, true, false, false, false, true}
+	for i, in := range want {
+		if diag.Mask[i] != in {
+			t.Fatalf("Error: diagonal line is %v, expected %v", diag.Mask, want)
+		}
+	}
+	if el, err := ParseElement("rect:3,5"); err != nil || el.Width != 3 || el.Height != 5 {
+		t.Errorf("Error: parsed rect:3,5 as %v, %v", el, err)
+	}
+	for _, spec := range []string{"disk", "disk:1.5", "square:-1", "rect:2,3",
+		"line:3", "star:3"} {
+		if _, err := ParseElement(spec); err == nil {
+			t.Errorf("Error: parsing %q didn't fail", spec)
+		}
+	}
+}
+
+func TestErodeDilate(t *testing.T) {
+	// The fast path for rectangles gives the same result as the general one,
+	// for 8 and 16-bit images, including rectangles larger than the image.
+	for _, src := range []SippImage{SgrayCosxCosyTiny, Sgray16} {
+		p := newPlane(src)
+		for _, size := range [][2]int{{1, 1}, {3, 3}, {5, 1}, {1, 7}, {3, 9}, {41, 3}} {
+			el := Rectangle(size[0], size[1])
+			slow := &Element{el.Width, el.Height, el.Mask, false}
+			sameImage(t, "fast erosion", erode(p, el).image(), erode(p, slow).image())
+			sameImage(t, "fast dilation", dilate(p, el).image(), dilate(p, slow).image())
+		}
+	}
+
+	// Dilation uses the reflected element, here a single pixel to the right,
+	// so that it shifts the image to the right, and erosion to the left.
+	right := NewElement(3, 1, []bool{false, false, true})
+	eroded := Erode(Sgray, right)
+	dilated := Dilate(Sgray, right)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 3; x++ {
+			if eroded.IntVal(x, y) != Sgray.IntVal(x+1, y) {
+				t.Fatalf("Error: eroded pixel %d,%d is %d, expected %d", x, y,
+					eroded.IntVal(x, y), Sgray.IntVal(x+1, y))
+			}
+			if dilated.IntVal(x+1, y) != Sgray.IntVal(x, y) {
+				t.Fatalf("Error: dilated pixel %d,%d is %d, expected %d", x+1, y,
+					dilated.IntVal(x+1, y), Sgray.IntVal(x, y))
+			}
+		}
+	}
+
+	src := binaryImage(
+		"........",
+		".###....",
+		".###..#.",
+		".###....",
+		"........",
+	)
+	sameImage(t, "binary erosion", Erode(src, Square(1)), binaryImage(
+		"........",
+		"........",
+		"..#.....",
+		"........",
+		"........",
+	))
+	sameImage(t, "binary dilation", Dilate(src, Disk(1)), binaryImage(
+		".###....",
+		"#####.#.",
+		"########",
+		"#####.#.",
+		".###....",
+	))
+	// Opening removes the isolated pixel and keeps the square.
+	sameImage(t, "binary opening", Open(src, Square(1)), binaryImage(
+		"........",
+		".###....",
+		".###....",
+		".###....",
+		"........",
+	))
+	sameImage(t, "binary gradient", Gradient(src, Square(1)), binaryImage(
+		"#####...",
+		"########",
+		"##.#####",
+		"########",
+		"#####...",
+	))
+	// Closing fills the hole. The region is kept away from the edges, beyond
+	// which the dilation isn't eroded back.
+	sameImage(t, "binary closing", Close(binaryImage(
+		".........",
+		".........",
+		"..#####..",
+		"..##.##..",
+		"..#####..",
+		".........",
+		".........",
+	), Square(1)), binaryImage(
+		".........",
+		".........",
+		"..#####..",
+		"..#####..",
+		"..#####..",
+		".........",
+		".........",
+	))
+}
+
+func TestOpenClose(t *testing.T) {
+	for _, el := range []*Element{Square(1), Disk(2), Line(5, 30)} {
+		for _, src := range []SippImage{SgrayCosxCosyTiny, Sgray16} {
+			open := Open(src, el)
+			closed := Close(src, el)
+			// Opening is anti-extensive and closing extensive, and both are
+			// idempotent.
+			sameImage(t, "opened opening", Open(open, el), open)
+			sameImage(t, "closed closing", Close(closed, el), closed)
+			top := TopHat(src, el)
+			black := BlackTopHat(src, el)
+			size := src.Bounds().Size()
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					val := src.IntVal(x, y)
+					if open.IntVal(x, y) > val || closed.IntVal(x, y) < val {
+						t.Fatalf("Error: opening %d and closing %d of pixel "+
+							"%d,%d don't bound %d", open.IntVal(x, y),
+							closed.IntVal(x, y), x, y, val)
+					}
+					if top.IntVal(x, y) != val-open.IntVal(x, y) ||
+						black.IntVal(x, y) != closed.IntVal(x, y)-val {
+						t.Fatalf("Error: top-hats of pixel %d,%d are %d and %d",
+							x, y, top.IntVal(x, y), black.IntVal(x, y))
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	mask := binaryImage(
+		"##....#",
+		"#..#..#",
+		"..#...#",
+		".#..###",
+		".......",
+	)
+	marker := binaryImage(
+		".......",
+		".......",
+		"..#....",
+		".......",
+		"......#",
+	)
+	// The marker pixel outside the mask is dropped, and the diagonally
+	// connected region it touches is kept.
+	sameImage(t, "binary reconstruction", Reconstruct(marker, mask), binaryImage(
+		".......",
+		"...#...",
+		"..#....",
+		".#.....",
+		".......",
+	))
+
+	// Greyscale reconstruction matches repeated geodesic dilation.
+	src := SgrayCosxCosyTiny
+	lim := newPlane(src)
+	geo := &plane{make([]int32, len(lim.pix)), lim.width, lim.height, lim.bpp}
+	geo.pix[10*lim.width+10] = lim.pix[10*lim.width+10]
+	for changed := true; changed; {
+		next := dilate(geo, Square(1))
+		changed = false
+		for i, val := range next.pix {
+			if val > lim.pix[i] {
+				val = lim.pix[i]
+			}
+			if val != geo.pix[i] {
+				changed = true
+			}
+			next.pix[i] = val
+		}
+		geo = next
+	}
+	seed := &plane{make([]int32, len(lim.pix)), lim.width, lim.height, lim.bpp}
+	seed.pix[10*lim.width+10] = 255
+	sameImage(t, "greyscale reconstruction", Reconstruct(seed.image(), src),
+		geo.image())
+
+	// Reconstruction by erosion is the dual.
+	comp := func(im SippImage) SippImage {
+		return newPlane(im).complement().image()
+	}
+	sameImage(t, "reconstruction by erosion",
+		ReconstructByErosion(comp(seed.image()), src),
+		comp(Reconstruct(seed.image(), comp(src))))
+
+	ring := binaryImage(
+		"........",
+		".####...",
+		".#..#.#.",
+		".####.#.",
+		"......#.",
+	)
+	sameImage(t, "filled holes", FillHoles(ring), binaryImage(
+		"........",
+		".####...",
+		".####.#.",
+		".####.#.",
+		"......#.",
+	))
+}
+
+func TestParseOps(t *testing.T) {
+	// Filling the hole first lets the opening keep the block while removing
+	// the isolated pixel.
+	op, err := ParseOps("fill+open:square:1")
+	if err != nil {
+		t.Fatal("Error parsing operations:", err)
+	}
+	src := binaryImage(
+		"#......",
+		".#####.",
+		".#####.",
+		".##.##.",
+		".#####.",
+		".#####.",
+		".......",
+	)
+	sameImage(t, "parsed operations", op(src), binaryImage(
+		".......",
+		".#####.",
+		".#####.",
+		".#####.",
+		".#####.",
+		".#####.",
+		".......",
+	))
+	for _, spec := range []string{"open", "fill:disk:1", "shrink:disk:1",
+		"erode:disk:x"} {
+		if _, err := ParseOps(spec); err == nil {
+			t.Errorf("Error: parsing %q didn't fail", spec)
+		}
+	}
+	bin := Binary(Sgray16)
+	if bin.Bpp() != 8 || bin.IntVal(0, 0) != 255 {
+		t.Errorf("Error: binary image is %d-bit with first pixel %d", bin.Bpp(),
+			bin.IntVal(0, 0))
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright Raul Vera 2015-2021
+
+// Package sscale provides functions for computing delentropy at multiple
+// scales, either in a Gaussian scale space, where the image is smoothed by
+// increasing amounts at its original resolution, or in a Gaussian pyramid,
+// where it is also halved in size at each level.
+//
+// A single delentropy value at the original resolution is sensitive to noise
+// and to the sampling of the image. The curve of delentropy against scale
+// shows at which scales the structure of the image lies.
+//
+// The smoothed images are rounded back to the bit depth of the source, so
+// that the gradients are binned in the same way as those of the source.
+package sscale
+
+import (
+	"image"
+	"math"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	. "github.com/Causticity/sipp/sentropy"
+	. "github.com/Causticity/sipp/sgrad"
+	. "github.com/Causticity/sipp/shist"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// A Level holds the image at one scale and the results computed from it.
+type Level struct {
+	// The standard deviation, in pixels of the source image, of the Gaussian
+	// that the source was smoothed with to produce this level.
+	Sigma float64
+	// The factor by which the source was reduced in size, 1 for a scale
+	// space and a power of 2 for a pyramid.
+	Factor int
+	// The smoothed image.
+	Image SippImage
+	// Its gradient, histogram and delentropy.
+	Grad       SippComplexImage
+	Hist       SippHist
+	Delentropy *SippDelentropy
+}
+
+// newLevel computes the gradient, histogram and delentropy for an image.
+func newLevel(im SippImage, sigma float64, factor int) *Level {
+	lev := &Level{Sigma: sigma, Factor: factor, Image: im}
+	lev.Grad = Fdgrad(im)
+	lev.Hist = Hist(lev.Grad)
+	lev.Delentropy = Delentropy(lev.Hist)
+	return lev
+}
+
+// ScaleSpace smooths the image with a Gaussian of each of the given standard
+// deviations, in pixels, and computes the delentropy of each result. A sigma
+// of 0 gives the unsmoothed image. The edges are handled as by
+// simage.FloatImage.Smooth.
+func ScaleSpace(src SippImage, sigmas []float64) []*Level {
+	flt := ToFloat(src)
+	levels := make([]*Level, len(sigmas))
+	for i, sigma := range sigmas {
+		levels[i] = newLevel(flt.GaussianSmooth(sigma).Quantise(src.Bpp()), sigma, 1)
+	}
+	return levels
+}
+
+// OctaveSigmas returns standard deviations for a scale space, starting with
+// 0 for the unsmoothed image, followed by the given number of octaves, each
+// doubling the standard deviation, divided into the given number of steps,
+// starting from a standard deviation of 1 pixel. For example, 2 octaves of 2
+// steps gives 0, 1, sqrt(2), 2, 2 sqrt(2).
+func OctaveSigmas(octaves, steps int) []float64 {
+	if octaves < 0 || steps < 1 {
+		panic("Invalid number of octaves or steps!")
+	}
+	sigmas := []float64{0}
+	for i := 0; i < octaves*steps; i++ {
+		sigmas = append(sigmas, math.Pow(2, float64(i)/float64(steps)))
+	}
+	return sigmas
+}
+
+// pyramidSigma is the standard deviation, in pixels of the level being
+// reduced, of the Gaussian applied before halving the size of an image.
+const pyramidSigma = 1.0
+
+// minPyramidSize is the smallest width or height of a pyramid level.
+const minPyramidSize = 3
+
+// Pyramid computes the delentropy of the image and of the given number of
+// further levels of a Gaussian pyramid. Each level is smoothed with a
+// Gaussian of standard deviation 1 pixel and then every other pixel is taken
+// in each direction. Reduction stops early if a level would be smaller than
+// 3x3 pixels, so that every level has a gradient of at least 2x2 pixels.
+func Pyramid(src SippImage, levels int) []*Level {
+	if levels < 0 {
+		panic("Invalid number of pyramid levels!")
+	}
+	res := []*Level{newLevel(src, 0, 1)}
+	flt := ToFloat(src)
+	sigma := 0.0
+	factor := 1
+	kern := GaussianKernel(pyramidSigma)
+	for i := 0; i < levels; i++ {
+		width, height := flt.Rect.Dx()/2, flt.Rect.Dy()/2
+		if width < minPyramidSize || height < minPyramidSize {
+			break
+		}
+		// The smoothing is in pixels of the current level, which are factor
+		// source pixels wide, and adds in quadrature to the smoothing so far.
+		sigma = math.Hypot(sigma, pyramidSigma*float64(factor))
+		factor *= 2
+		flt = decimate(flt.Smooth(kern), width, height)
+		res = append(res, newLevel(flt.Quantise(src.Bpp()), sigma, factor))
+	}
+	return res
+}
+
+// decimate returns an image of the given size made of every other pixel of
+// the given image in each direction.
+func decimate(flt *FloatImage, width, height int) *FloatImage {
+	res := NewFloatImage(image.Rect(0, 0, width, height))
+	stride := flt.Rect.Dx()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			res.Pix[y*width+x] = flt.Pix[2*y*stride+2*x]
+		}
+	}
+	res.SetScaling()
+	return res
+}
+
+// Curve returns the standard deviations and the delentropies of the given
+// levels, with the delentropies normalised as given. See
+// sentropy.Normalisation.
+func Curve(levels []*Level, norm Normalisation) (sigmas, delentropies []float64) {
+	sigmas = make([]float64, len(levels))
+	delentropies = make([]float64, len(levels))
+	for i, lev := range levels {
+		sigmas[i] = lev.Sigma
+		delentropies[i] = lev.Delentropy.Normalised(norm)
+	}
+	return
+}
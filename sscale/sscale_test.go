@@ -0,0 +1,112 @@
+// Copyright Raul Vera 2021
+
+// Tests for package sscale.
+
+package sscale
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+import (
+	. "github.com/Causticity/sipp/sentropy"
+	. "github.com/Causticity/sipp/sgrad"
+	. "github.com/Causticity/sipp/shist"
+	. "github.com/Causticity/sipp/simage"
+	. "github.com/Causticity/sipp/sipptesting"
+)
+
+func TestOctaveSigmas(t *testing.T) {
+	sigmas := OctaveSigmas(2, 2)
+	expected := []float64{0, 1, math.Sqrt2, 2, 2 * math.Sqrt2}
+	for i := range expected {
+		if math.Abs(sigmas[i]-expected[i]) > 1e-12 {
+			t.Errorf("Error: octave sigmas %v, expected %v", sigmas, expected)
+			break
+		}
+	}
+	if len(sigmas) != len(expected) {
+		t.Errorf("Error: %d octave sigmas, expected %d", len(sigmas), len(expected))
+	}
+	if sigmas = OctaveSigmas(0, 3); !reflect.DeepEqual(sigmas, []float64{0}) {
+		t.Errorf("Error: no octaves gave %v", sigmas)
+	}
+}
+
+func TestScaleSpace(t *testing.T) {
+	levels := ScaleSpace(SgrayCosxCosyTiny, []float64{0, 1, 3})
+	if len(levels) != 3 {
+		t.Fatalf("Error: %d scale-space levels, expected 3", len(levels))
+	}
+	// The unsmoothed level is the source image.
+	exp := Delentropy(Hist(Fdgrad(SgrayCosxCosyTiny))).Delentropy
+	if levels[0].Delentropy.Delentropy != exp {
+		t.Errorf("Error: unsmoothed delentropy %v, expected %v",
+			levels[0].Delentropy.Delentropy, exp)
+	}
+	if !reflect.DeepEqual(levels[0].Image.Pix(), SgrayCosxCosyTiny.Pix()) {
+		t.Error("Error: unsmoothed level differs from the source")
+	}
+	for i, lev := range levels {
+		if lev.Factor != 1 || lev.Image.Bounds() != SgrayCosxCosyTiny.Bounds() ||
+			lev.Image.Bpp() != 8 {
+			t.Errorf("Error: scale-space level %d has factor %d, bounds %v, "+
+				"depth %d", i, lev.Factor, lev.Image.Bounds(), lev.Image.Bpp())
+		}
+	}
+	// Heavier smoothing of the same image gives a different level.
+	if reflect.DeepEqual(levels[1].Image.Pix(), levels[2].Image.Pix()) {
+		t.Error("Error: scale-space levels with different sigmas are the same")
+	}
+
+	sigmas, delentropies := Curve(levels, DefaultNormalisation())
+	if !reflect.DeepEqual(sigmas, []float64{0, 1, 3}) {
+		t.Errorf("Error: curve sigmas %v", sigmas)
+	}
+	if delentropies[0] != exp/2 {
+		t.Errorf("Error: curve delentropy %v, expected %v", delentropies[0], exp/2)
+	}
+}
+
+func TestPyramid(t *testing.T) {
+	// The 20x20 image halves to 10x10, 5x5, and then stops, as 2x2 is too
+	// small.
+	levels := Pyramid(SgrayCosxCosyTiny, 5)
+	expectedSizes := []int{20, 10, 5}
+	expectedSigmas := []float64{0, 1, math.Sqrt(5)}
+	if len(levels) != len(expectedSizes) {
+		t.Fatalf("Error: %d pyramid levels, expected %d", len(levels),
+			len(expectedSizes))
+	}
+	for i, lev := range levels {
+		size := lev.Image.Bounds().Size()
+		if size.X != expectedSizes[i] || size.Y != expectedSizes[i] {
+			t.Errorf("Error: pyramid level %d is %v", i, size)
+		}
+		if lev.Factor != 1<<uint(i) {
+			t.Errorf("Error: pyramid level %d factor %d", i, lev.Factor)
+		}
+		if math.Abs(lev.Sigma-expectedSigmas[i]) > 1e-12 {
+			t.Errorf("Error: pyramid level %d sigma %v, expected %v", i,
+				lev.Sigma, expectedSigmas[i])
+		}
+		if lev.Grad.Bounds().Dx() != size.X-1 {
+			t.Errorf("Error: pyramid level %d gradient has bounds %v", i,
+				lev.Grad.Bounds())
+		}
+	}
+	// A constant image stays constant, with zero delentropy.
+	vals := make([]float64, 36)
+	for i := range vals {
+		vals[i] = 1000
+	}
+	flat := FromFloatArray(vals, 6).Quantise(16)
+	for _, lev := range Pyramid(flat, 1) {
+		if lev.Delentropy.Delentropy != 0 {
+			t.Errorf("Error: constant pyramid level delentropy %v",
+				lev.Delentropy.Delentropy)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright Raul Vera 2015-2021
+
+// Package stensor provides functions for analysing the local and global
+// anisotropy of a gradient image, using the structure tensor (the matrix of
+// second moments of the gradient components) and the principal axes of the
+// distribution of gradient values.
+//
+// The gradient is taken as a vector whose components are its real and
+// imaginary parts. For the default sgrad kernel these are diagonal
+// differences, so orientations are measured in that frame, anticlockwise from
+// the positive real axis, in radians.
+package stensor
+
+import (
+	"math"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	. "github.com/Causticity/sipp/simage"
+)
+
+// A Tensor is a 2x2 symmetric structure tensor, the averaged outer product of
+// gradient vectors with themselves.
+type Tensor struct {
+	Jxx, Jxy, Jyy float64
+}
+
+// Eigenvalues returns the eigenvalues of the tensor, largest first. Both are
+// non-negative for a structure tensor.
+func (t Tensor) Eigenvalues() (l1, l2 float64) {
+	mean := (t.Jxx + t.Jyy) / 2
+	diff := math.Hypot((t.Jxx-t.Jyy)/2, t.Jxy)
+	return mean + diff, mean - diff
+}
+
+// Orientation returns the orientation of the dominant gradient direction, the
+// eigenvector of the largest eigenvalue, in the range (-pi/2, pi/2]. Edges and
+// stripes run perpendicular to this direction.
+func (t Tensor) Orientation() float64 {
+	theta := 0.5 * math.Atan2(2*t.Jxy, t.Jxx-t.Jyy)
+	if theta <= -math.Pi/2 {
+		theta += math.Pi
+	}
+	return theta
+}
+
+// Coherence returns (l1 - l2) / (l1 + l2), which is 0 where the gradient
+// directions are isotropic and 1 where they all lie along one line. It is 0
+// where there is no gradient at all.
+func (t Tensor) Coherence() float64 {
+	l1, l2 := t.Eigenvalues()
+	if l1+l2 <= 0 {
+		return 0
+	}
+	return (l1 - l2) / (l1 + l2)
+}
+
+// Anisotropy returns 1 - l2 / l1, which is 0 where the gradient directions are
+// isotropic and 1 where they all lie along one line. It is more sensitive
+// than the coherence to small amounts of anisotropy. It is 0 where there is
+// no gradient at all.
+func (t Tensor) Anisotropy() float64 {
+	l1, l2 := t.Eigenvalues()
+	if l1 <= 0 {
+		return 0
+	}
+	return 1 - l2/l1
+}
+
+// GlobalTensor returns the structure tensor of the whole gradient image, the
+// mean of the outer products of all the gradient vectors.
+func GlobalTensor(grad SippComplexImage) (t Tensor) {
+	vals := grad.Values()
+	for _, v := range vals {
+		re, im := real(v), imag(v)
+		t.Jxx += re * re
+		t.Jxy += re * im
+		t.Jyy += im * im
+	}
+	n := float64(len(vals))
+	t.Jxx /= n
+	t.Jxy /= n
+	t.Jyy /= n
+	return
+}
+
+// A TensorField holds the structure tensor at each pixel of a gradient image.
+type TensorField struct {
+	// The tensor components, in the same layout as the gradient pixels.
+	Jxx, Jxy, Jyy *FloatImage
+}
+
+// StructureTensor computes the structure tensor at each pixel of the gradient
+// image, averaging the outer products of the gradient vectors with a Gaussian
+// window of the given standard deviation in pixels. A sigma of 0 uses the
+// single pixel, which makes the coherence and anisotropy 1 wherever the
+// gradient is non-zero. Near the edges the window is truncated and the
+// weights renormalised, rather than extending the image.
+func StructureTensor(grad SippComplexImage, sigma float64) *TensorField {
+	if sigma < 0 {
+		panic("Structure tensor window must have non-negative sigma!")
+	}
+	rect := grad.Bounds()
+	field := &TensorField{NewFloatImage(rect), NewFloatImage(rect), NewFloatImage(rect)}
+	for i, v := range grad.Values() {
+		re, im := real(v), imag(v)
+		field.Jxx.Pix[i] = re * re
+		field.Jxy.Pix[i] = re * im
+		field.Jyy.Pix[i] = im * im
+	}
+	if sigma > 0 {
+		kern := GaussianKernel(sigma)
+		field.Jxx = field.Jxx.Smooth(kern)
+		field.Jxy = field.Jxy.Smooth(kern)
+		field.Jyy = field.Jyy.Smooth(kern)
+	} else {
+		for _, comp := range []*FloatImage{field.Jxx, field.Jxy, field.Jyy} {
+			comp.SetScaling()
+		}
+	}
+	return field
+}
+
+// At returns the tensor at pixel x, y.
+func (field *TensorField) At(x, y int) Tensor {
+	i := y*field.Jxx.Rect.Dx() + x
+	return Tensor{field.Jxx.Pix[i], field.Jxy.Pix[i], field.Jyy.Pix[i]}
+}
+
+// Orientation returns an image of the orientation of the tensor at each pixel.
+// See Tensor.Orientation. The Min and Max of the image are set to -pi/2 and
+// pi/2, so that renderings of different images are comparable.
+func (field *TensorField) Orientation() *FloatImage {
+	return field.measure(Tensor.Orientation, -math.Pi/2, math.Pi/2)
+}
+
+// Coherence returns an image of the coherence of the tensor at each pixel.
+// See Tensor.Coherence. The Min and Max of the image are set to 0 and 1.
+func (field *TensorField) Coherence() *FloatImage {
+	return field.measure(Tensor.Coherence, 0, 1)
+}
+
+// Anisotropy returns an image of the anisotropy of the tensor at each pixel.
+// See Tensor.Anisotropy. The Min and Max of the image are set to 0 and 1.
+func (field *TensorField) Anisotropy() *FloatImage {
+	return field.measure(Tensor.Anisotropy, 0, 1)
+}
+
+// measure returns an image of the given measure of the tensor at each pixel,
+// with the given range.
+func (field *TensorField) measure(m func(Tensor) float64, min, max float64) *FloatImage {
+	res := NewFloatImage(field.Jxx.Rect)
+	for i := range res.Pix {
+		res.Pix[i] = m(Tensor{field.Jxx.Pix[i], field.Jxy.Pix[i], field.Jyy.Pix[i]})
+	}
+	res.Min = min
+	res.Max = max
+	return res
+}
+
+// PrincipalAxes describes the shape of the distribution of gradient values,
+// i.e. of the 2D gradient histogram, by its mean and the principal axes of its
+// covariance.
+type PrincipalAxes struct {
+	// The mean gradient value.
+	Mean complex128
+	// The covariance matrix of the gradient values about the mean.
+	Covariance Tensor
+	// The orientation of the major axis, in the range (-pi/2, pi/2].
+	Angle float64
+	// The standard deviations along the major and minor axes.
+	Major, Minor float64
+}
+
+// GradientMoments returns the principal axes of the distribution of gradient
+// values. For a histogram with unit bins at the gradient values, these are
+// the principal axes of its second moments about its centroid.
+func GradientMoments(grad SippComplexImage) (axes PrincipalAxes) {
+	vals := grad.Values()
+	n := float64(len(vals))
+	var sum complex128
+	for _, v := range vals {
+		sum += v
+	}
+	axes.Mean = sum / complex(n, 0)
+	cov := &axes.Covariance
+	for _, v := range vals {
+		re, im := real(v)-real(axes.Mean), imag(v)-imag(axes.Mean)
+		cov.Jxx += re * re
+		cov.Jxy += re * im
+		cov.Jyy += im * im
+	}
+	cov.Jxx /= n
+	cov.Jxy /= n
+	cov.Jyy /= n
+	l1, l2 := cov.Eigenvalues()
+	axes.Angle = cov.Orientation()
+	axes.Major = math.Sqrt(l1)
+	axes.Minor = math.Sqrt(math.Max(l2, 0))
+	return
+}
@@ -0,0 +1,189 @@
+// Copyright Raul Vera 2021
+
+// Tests for package stensor.
+
+package stensor
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+import (
+	. "github.com/Causticity/sipp/scomplex"
+	. "github.com/Causticity/sipp/simage"
+)
+
+const tolerance = 1e-9
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < tolerance
+}
+
+// stripes returns a gradient image whose values all lie along the given
+// angle, with magnitudes cycling through 1 to 4.
+func stripes(width, height int, angle float64) *ComplexImage {
+	grad := &ComplexImage{Rect: image.Rect(0, 0, width, height)}
+	grad.Pix = make([]complex128, width*height)
+	dir := complex(math.Cos(angle), math.Sin(angle))
+	for i := range grad.Pix {
+		grad.Pix[i] = complex(float64(i%4+1), 0) * dir
+	}
+	return grad
+}
+
+func TestTensor(t *testing.T) {
+	iso := Tensor{2, 0, 2}
+	if l1, l2 := iso.Eigenvalues(); !near(l1, 2) || !near(l2, 2) {
+		t.Errorf("Error: isotropic eigenvalues %v, %v, expected 2, 2", l1, l2)
+	}
+	if iso.Coherence() != 0 || iso.Anisotropy() != 0 {
+		t.Errorf("Error: isotropic tensor has coherence %v, anisotropy %v",
+			iso.Coherence(), iso.Anisotropy())
+	}
+	var zero Tensor
+	if zero.Coherence() != 0 || zero.Anisotropy() != 0 {
+		t.Error("Error: zero tensor has non-zero coherence or anisotropy")
+	}
+
+	// A tensor with eigenvalues 3 and 1 along 30 degrees.
+	theta := math.Pi / 6
+	c, s := math.Cos(theta), math.Sin(theta)
+	ten := Tensor{3*c*c + s*s, 2 * c * s, 3*s*s + c*c}
+	if l1, l2 := ten.Eigenvalues(); !near(l1, 3) || !near(l2, 1) {
+		t.Errorf("Error: eigenvalues %v, %v, expected 3, 1", l1, l2)
+	}
+	if !near(ten.Orientation(), theta) {
+		t.Errorf("Error: orientation %v, expected %v", ten.Orientation(), theta)
+	}
+	if !near(ten.Coherence(), 0.5) {
+		t.Errorf("Error: coherence %v, expected 0.5", ten.Coherence())
+	}
+	if !near(ten.Anisotropy(), 2.0/3.0) {
+		t.Errorf("Error: anisotropy %v, expected 2/3", ten.Anisotropy())
+	}
+	// Vertical is at pi/2, not -pi/2.
+	if vert := (Tensor{0, 0, 1}); !near(vert.Orientation(), math.Pi/2) {
+		t.Errorf("Error: vertical orientation %v, expected pi/2", vert.Orientation())
+	}
+}
+
+func TestGlobalTensor(t *testing.T) {
+	angle := -math.Pi / 3
+	grad := stripes(8, 6, angle)
+	gt := GlobalTensor(grad)
+	if !near(gt.Orientation(), angle) {
+		t.Errorf("Error: global orientation %v, expected %v", gt.Orientation(), angle)
+	}
+	if !near(gt.Coherence(), 1) || !near(gt.Anisotropy(), 1) {
+		t.Errorf("Error: global coherence %v, anisotropy %v, expected 1, 1",
+			gt.Coherence(), gt.Anisotropy())
+	}
+	// The integer gradient gives the same answer.
+	igrad := &ComplexInt32Image{Rect: image.Rect(0, 0, 2, 2)}
+	igrad.Pix = []ComplexInt32{{1, 1}, {2, 2}, {-1, -1}, {3, 3}}
+	fgrad := &ComplexImage{Rect: image.Rect(0, 0, 2, 2)}
+	fgrad.Pix = []complex128{1 + 1i, 2 + 2i, -1 - 1i, 3 + 3i}
+	if GlobalTensor(igrad) != GlobalTensor(fgrad) {
+		t.Errorf("Error: integer global tensor %v, expected %v",
+			GlobalTensor(igrad), GlobalTensor(fgrad))
+	}
+}
+
+func TestStructureTensor(t *testing.T) {
+	angle := math.Pi / 4
+	grad := stripes(7, 5, angle)
+	// Every pixel sees gradients along one line, so the field is uniformly
+	// coherent, with or without smoothing.
+	for _, sigma := range []float64{0, 1.5} {
+		field := StructureTensor(grad, sigma)
+		orient := field.Orientation()
+		coher := field.Coherence()
+		aniso := field.Anisotropy()
+		for i := range orient.Pix {
+			if !near(orient.Pix[i], angle) || !near(coher.Pix[i], 1) ||
+				!near(aniso.Pix[i], 1) {
+				t.Errorf("Error: sigma %v pixel %d orientation %v, coherence %v, "+
+					"anisotropy %v", sigma, i, orient.Pix[i], coher.Pix[i], aniso.Pix[i])
+				break
+			}
+		}
+		if coher.Min != 0 || coher.Max != 1 {
+			t.Errorf("Error: coherence range %v to %v, expected 0 to 1",
+				coher.Min, coher.Max)
+		}
+	}
+
+	// Alternating horizontal and vertical gradients are isotropic once
+	// smoothed, apart from near the edges, where the window is truncated.
+	grad = &ComplexImage{Rect: image.Rect(0, 0, 21, 21)}
+	grad.Pix = make([]complex128, 21*21)
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 21; x++ {
+			if (x+y)%2 == 0 {
+				grad.Pix[y*21+x] = 1
+			} else {
+				grad.Pix[y*21+x] = 1i
+			}
+		}
+	}
+	field := StructureTensor(grad, 2)
+	centre := field.At(10, 10)
+	if math.Abs(centre.Coherence()) > 0.01 {
+		t.Errorf("Error: checkerboard coherence %v, expected about 0",
+			centre.Coherence())
+	}
+	unsmoothed := StructureTensor(grad, 0).At(10, 10)
+	if !near(unsmoothed.Coherence(), 1) {
+		t.Errorf("Error: unsmoothed coherence %v, expected 1", unsmoothed.Coherence())
+	}
+
+	// Smoothing preserves a constant field, including at the edges.
+	kern := GaussianKernel(1.3)
+	var sum float64
+	for _, w := range kern {
+		sum += w
+	}
+	if !near(sum, 1) || len(kern) != 9 {
+		t.Errorf("Error: kernel of length %d sums to %v", len(kern), sum)
+	}
+	flat := &ComplexImage{Rect: image.Rect(0, 0, 3, 3)}
+	flat.Pix = make([]complex128, 9)
+	for i := range flat.Pix {
+		flat.Pix[i] = 2 + 1i
+	}
+	smoothed := StructureTensor(flat, 5)
+	for i := range flat.Pix {
+		if !near(smoothed.Jxx.Pix[i], 4) || !near(smoothed.Jxy.Pix[i], 2) ||
+			!near(smoothed.Jyy.Pix[i], 1) {
+			t.Errorf("Error: smoothed constant field pixel %d is %v, %v, %v",
+				i, smoothed.Jxx.Pix[i], smoothed.Jxy.Pix[i], smoothed.Jyy.Pix[i])
+		}
+	}
+}
+
+func TestGradientMoments(t *testing.T) {
+	// Points spread along 30 degrees with a standard deviation of 2, and
+	// across it with a standard deviation of 1, about a mean of 5+3i.
+	theta := math.Pi / 6
+	dir := complex(math.Cos(theta), math.Sin(theta))
+	perp := dir * 1i
+	mean := complex(5, 3)
+	grad := &ComplexImage{Rect: image.Rect(0, 0, 4, 1)}
+	grad.Pix = []complex128{
+		mean + 2*dir, mean - 2*dir, mean + perp, mean - perp,
+	}
+	axes := GradientMoments(grad)
+	if math.Abs(real(axes.Mean)-5) > tolerance || math.Abs(imag(axes.Mean)-3) > tolerance {
+		t.Errorf("Error: mean %v, expected %v", axes.Mean, mean)
+	}
+	if !near(axes.Angle, theta) {
+		t.Errorf("Error: angle %v, expected %v", axes.Angle, theta)
+	}
+	// Variances along the axes are (4+4)/4 and (1+1)/4.
+	if !near(axes.Major, math.Sqrt(2)) || !near(axes.Minor, math.Sqrt(0.5)) {
+		t.Errorf("Error: major, minor %v, %v, expected %v, %v", axes.Major,
+			axes.Minor, math.Sqrt(2), math.Sqrt(0.5))
+	}
+}
@@ -0,0 +1,470 @@
+// Copyright Raul Vera 2015-2021
+
+// Package swavelet provides functions for the sipp package to compute a
+// multi-level 2D discrete wavelet transform and its inverse, the energy and
+// entropy of the resulting subbands, and a rendering of the subbands as a
+// grey-scale mosaic.
+//
+// The transforms treat the image as periodic, so that no coefficients are
+// lost at the edges and reconstruction is exact apart from rounding error.
+// This requires the width and height to be divisible by 2 at every level.
+package swavelet
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+import (
+	. "github.com/Causticity/sipp/simage"
+)
+
+// A Wavelet is a 1D discrete wavelet transform, applied to the rows and
+// columns of an image.
+type Wavelet interface {
+	// Name returns the name of the wavelet, as accepted by ByName.
+	Name() string
+	// minLength returns the shortest signal the transform can be applied to.
+	minLength() int
+	// analyse transforms the signal in place into its approximation
+	// coefficients followed by its detail coefficients. The signal length
+	// must be even, and tmp must be at least as long.
+	analyse(sig, tmp []float64)
+	// synthesise is the inverse of analyse.
+	synthesise(sig, tmp []float64)
+}
+
+// An orthogonal wavelet is defined by its lowpass analysis filter. The
+// highpass filter is its quadrature mirror, and the synthesis filters are the
+// same as the analysis ones.
+type orthogonal struct {
+	name string
+	lo   []float64
+	hi   []float64
+}
+
+func newOrthogonal(name string, lo []float64) *orthogonal {
+	wav := &orthogonal{name: name, lo: lo, hi: make([]float64, len(lo))}
+	last := len(lo) - 1
+	for i := range lo {
+		wav.hi[i] = lo[last-i]
+		if i%2 == 1 {
+			wav.hi[i] = -wav.hi[i]
+		}
+	}
+	return wav
+}
+
+func (wav *orthogonal) Name() string {
+	return wav.name
+}
+
+func (wav *orthogonal) minLength() int {
+	return len(wav.lo)
+}
+
+func (wav *orthogonal) analyse(sig, tmp []float64) {
+	n := len(sig)
+	half := n / 2
+	for k := 0; k < half; k++ {
+		var a, d float64
+		for j := range wav.lo {
+			x := sig[(2*k+j)%n]
+			a += wav.lo[j] * x
+			d += wav.hi[j] * x
+		}
+		tmp[k] = a
+		tmp[half+k] = d
+	}
+	copy(sig, tmp[:n])
+}
+
+func (wav *orthogonal) synthesise(sig, tmp []float64) {
+	n := len(sig)
+	half := n / 2
+	for i := range tmp[:n] {
+		tmp[i] = 0
+	}
+	for k := 0; k < half; k++ {
+		a, d := sig[k], sig[half+k]
+		for j := range wav.lo {
+			tmp[(2*k+j)%n] += wav.lo[j]*a + wav.hi[j]*d
+		}
+	}
+	copy(sig, tmp[:n])
+}
+
+// The CDF 9/7 wavelet is biorthogonal, and is computed by lifting, using the
+// factorisation of Daubechies and Sweldens.
+type cdf97 struct{}
+
+const (
+	cdf97Alpha = -1.586134342059924
+	cdf97Beta  = -0.052980118572961
+	cdf97Gamma = 0.882911075530934
+	cdf97Delta = 0.443506852043971
+	cdf97K     = 1.149604398860241
+)
+
+func (wav cdf97) Name() string {
+	return "cdf97"
+}
+
+func (wav cdf97) minLength() int {
+	return 2
+}
+
+// lift adds coef times the sum of each element of from and its neighbour to
+// each element of to. If next is true the neighbour is the following element,
+// otherwise the preceding one. The signal is periodic.
+func lift(to, from []float64, coef float64, next bool) {
+	half := len(to)
+	for i := range to {
+		j := i - 1
+		if next {
+			j = i + 1
+		}
+		j = (j + half) % half
+		to[i] += coef * (from[i] + from[j])
+	}
+}
+
+func (wav cdf97) analyse(sig, tmp []float64) {
+	n := len(sig)
+	half := n / 2
+	s, d := tmp[:half], tmp[half:n]
+	for i := 0; i < half; i++ {
+		s[i] = sig[2*i]
+		d[i] = sig[2*i+1]
+	}
+	lift(d, s, cdf97Alpha, true)
+	lift(s, d, cdf97Beta, false)
+	lift(d, s, cdf97Gamma, true)
+	lift(s, d, cdf97Delta, false)
+	for i := 0; i < half; i++ {
+		s[i] *= cdf97K
+		d[i] /= cdf97K
+	}
+	copy(sig, tmp[:n])
+}
+
+func (wav cdf97) synthesise(sig, tmp []float64) {
+	n := len(sig)
+	half := n / 2
+	s, d := sig[:half], sig[half:n]
+	for i := 0; i < half; i++ {
+		s[i] /= cdf97K
+		d[i] *= cdf97K
+	}
+	lift(s, d, -cdf97Delta, false)
+	lift(d, s, -cdf97Gamma, true)
+	lift(s, d, -cdf97Beta, false)
+	lift(d, s, -cdf97Alpha, true)
+	for i := 0; i < half; i++ {
+		tmp[2*i] = s[i]
+		tmp[2*i+1] = d[i]
+	}
+	copy(sig, tmp[:n])
+}
+
+// The supported wavelets. Daubechies4 and Daubechies8 are named by the number
+// of filter taps, so Haar is also Daubechies2.
+var (
+	Haar = Wavelet(newOrthogonal("haar", []float64{
+		1 / math.Sqrt2, 1 / math.Sqrt2,
+	}))
+	Daubechies4 = Wavelet(newOrthogonal("db4", []float64{
+		(1 + math.Sqrt(3)) / (4 * math.Sqrt2),
+		(3 + math.Sqrt(3)) / (4 * math.Sqrt2),
+		(3 - math.Sqrt(3)) / (4 * math.Sqrt2),
+		(1 - math.Sqrt(3)) / (4 * math.Sqrt2),
+	}))
+	Daubechies8 = Wavelet(newOrthogonal("db8", []float64{
+		0.23037781330885523, 0.7148465705525415,
+		0.6308807679295904, -0.02798376941698385,
+		-0.18703481171888114, 0.030841381835986965,
+		0.032883011666982945, -0.010597401784997278,
+	}))
+	CDF97 = Wavelet(cdf97{})
+)
+
+var wavelets = []Wavelet{Haar, Daubechies4, Daubechies8, CDF97}
+
+// ByName returns the wavelet with the given name: haar, db4, db8 or cdf97.
+func ByName(name string) (Wavelet, error) {
+	for _, wav := range wavelets {
+		if wav.Name() == name {
+			return wav, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown wavelet %q; use haar, db4, db8 or cdf97", name)
+}
+
+// MaxLevels returns the largest number of levels to which an image of the
+// given size can be decomposed with the given wavelet.
+func MaxLevels(wav Wavelet, width, height int) (levels int) {
+	for width%2 == 0 && height%2 == 0 &&
+		width >= wav.minLength() && height >= wav.minLength() {
+		levels++
+		width /= 2
+		height /= 2
+	}
+	return
+}
+
+// A Band identifies one of the four subbands produced at each level.
+type Band int
+
+const (
+	// LL is the approximation, lowpass in both directions. Only the LL band
+	// of the last level is kept; the others are decomposed further.
+	LL Band = iota
+	// HL is highpass horizontally and lowpass vertically, so it responds to
+	// vertical edges.
+	HL
+	// LH is lowpass horizontally and highpass vertically, so it responds to
+	// horizontal edges.
+	LH
+	// HH is highpass in both directions, responding to diagonal detail.
+	HH
+)
+
+// A Decomposition holds the wavelet coefficients of an image, in the usual
+// Mallat layout: the LL band of the last level is in the top-left corner, and
+// the detail bands of each level surround the bands of the coarser levels, so
+// that the coefficients take the same space as the image.
+type Decomposition struct {
+	// The wavelet used.
+	Wavelet Wavelet
+	// The number of levels. Level 1 is the finest, at half the resolution of
+	// the image.
+	Levels int
+	// The coefficients.
+	Coeffs *FloatImage
+}
+
+// Decompose computes the wavelet decomposition of the image to the given
+// number of levels. Returns an error if the image can't be decomposed that
+// far. See MaxLevels.
+func Decompose(src SippImage, wav Wavelet, levels int) (*Decomposition, error) {
+	return DecomposeFloat(ToFloat(src), wav, levels)
+}
+
+// DecomposeFloat is the same as Decompose for a FloatImage, which is left
+// unchanged.
+func DecomposeFloat(src *FloatImage, wav Wavelet, levels int) (*Decomposition, error) {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	if levels < 1 || levels > MaxLevels(wav, width, height) {
+		return nil, errors.New("Image can't be decomposed to that many levels!")
+	}
+	dec := &Decomposition{Wavelet: wav, Levels: levels}
+	dec.Coeffs = FromFloatArray(append([]float64(nil), src.Pix...), width)
+	for lev := 1; lev <= levels; lev++ {
+		dec.transform(width>>uint(lev-1), height>>uint(lev-1), wav.analyse)
+	}
+	dec.Coeffs.SetScaling()
+	return dec, nil
+}
+
+// Reconstruct computes the inverse transform, returning the image.
+func (dec *Decomposition) Reconstruct() *FloatImage {
+	width, height := dec.Coeffs.Rect.Dx(), dec.Coeffs.Rect.Dy()
+	res := &Decomposition{Wavelet: dec.Wavelet, Levels: dec.Levels}
+	res.Coeffs = FromFloatArray(append([]float64(nil), dec.Coeffs.Pix...), width)
+	for lev := dec.Levels; lev >= 1; lev-- {
+		res.transform(width>>uint(lev-1), height>>uint(lev-1), dec.Wavelet.synthesise)
+	}
+	res.Coeffs.SetScaling()
+	return res.Coeffs
+}
+
+// transform applies the given 1D transform to the rows and then the columns
+// of the top-left width x height region of the coefficients. The order is
+// irrelevant for the separable transforms used, as long as the inverse is
+// applied to the same region.
+func (dec *Decomposition) transform(width, height int, xform func(sig, tmp []float64)) {
+	pix := dec.Coeffs.Pix
+	stride := dec.Coeffs.Rect.Dx()
+	maxLen := width
+	if height > maxLen {
+		maxLen = height
+	}
+	line := make([]float64, maxLen)
+	tmp := make([]float64, maxLen)
+	for y := 0; y < height; y++ {
+		row := pix[y*stride : y*stride+width]
+		xform(row, tmp)
+	}
+	col := line[:height]
+	for x := 0; x < width; x++ {
+		for y := range col {
+			col[y] = pix[y*stride+x]
+		}
+		xform(col, tmp)
+		for y, val := range col {
+			pix[y*stride+x] = val
+		}
+	}
+}
+
+// Subband returns the rectangle of the coefficients for the given band at the
+// given level, from 1 to Levels. The LL band is only available at the last
+// level.
+func (dec *Decomposition) Subband(level int, band Band) image.Rectangle {
+	if level < 1 || level > dec.Levels || (band == LL && level != dec.Levels) {
+		panic("No such wavelet subband!")
+	}
+	w := dec.Coeffs.Rect.Dx() >> uint(level)
+	h := dec.Coeffs.Rect.Dy() >> uint(level)
+	switch band {
+	case LL:
+		return image.Rect(0, 0, w, h)
+	case HL:
+		return image.Rect(w, 0, 2*w, h)
+	case LH:
+		return image.Rect(0, h, w, 2*h)
+	case HH:
+		return image.Rect(w, h, 2*w, 2*h)
+	}
+	panic("No such wavelet subband!")
+}
+
+// subbandVals returns the coefficients in the given rectangle.
+func (dec *Decomposition) subbandVals(r image.Rectangle) []float64 {
+	vals := make([]float64, 0, r.Dx()*r.Dy())
+	stride := dec.Coeffs.Rect.Dx()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		vals = append(vals, dec.Coeffs.Pix[y*stride+r.Min.X:y*stride+r.Max.X]...)
+	}
+	return vals
+}
+
+// SubbandValues returns a copy of the coefficients of the given subband, in
+// row-major order. See Subband.
+func (dec *Decomposition) SubbandValues(level int, band Band) []float64 {
+	return dec.subbandVals(dec.Subband(level, band))
+}
+
+// LevelStats holds the energy and entropy of the detail subbands of one level
+// of a decomposition.
+type LevelStats struct {
+	// The level, from 1 for the finest.
+	Level int
+	// The energy, the sum of the squared coefficients, of the HL, LH and HH
+	// subbands, indexed by Band-1.
+	BandEnergy [3]float64
+	// The total energy of the detail subbands of the level.
+	Energy float64
+	// The fraction of the total energy of the decomposition in the level.
+	RelativeEnergy float64
+	// The Shannon entropy, in bits, of the distribution of the energy of the
+	// level over its detail coefficients. It is low when the energy is
+	// concentrated in a few coefficients, as for sparse edges, and high when
+	// it is spread evenly, as for noise or fine texture.
+	Entropy float64
+}
+
+// WaveletStats holds the energy and entropy of a decomposition.
+type WaveletStats struct {
+	// The statistics of each level, from the finest.
+	Levels []LevelStats
+	// The energy of the approximation and of the whole decomposition.
+	ApproxEnergy, TotalEnergy float64
+	// The relative wavelet entropy, in bits, of the distribution of the total
+	// energy over the detail levels and the approximation.
+	Entropy float64
+}
+
+// Stats computes the energy and entropy of the decomposition. For the
+// orthogonal wavelets the total energy is that of the image; for CDF 9/7 it is
+// only approximately so.
+func (dec *Decomposition) Stats() *WaveletStats {
+	stats := &WaveletStats{Levels: make([]LevelStats, dec.Levels)}
+	for lev := 1; lev <= dec.Levels; lev++ {
+		ls := &stats.Levels[lev-1]
+		ls.Level = lev
+		var sq []float64
+		for band := HL; band <= HH; band++ {
+			for _, c := range dec.SubbandValues(lev, band) {
+				sq = append(sq, c*c)
+				ls.BandEnergy[band-1] += c * c
+			}
+		}
+		ls.Energy = ls.BandEnergy[0] + ls.BandEnergy[1] + ls.BandEnergy[2]
+		ls.Entropy = entropy(sq, ls.Energy)
+		stats.TotalEnergy += ls.Energy
+	}
+	for _, c := range dec.SubbandValues(dec.Levels, LL) {
+		stats.ApproxEnergy += c * c
+	}
+	stats.TotalEnergy += stats.ApproxEnergy
+	energies := []float64{stats.ApproxEnergy}
+	for i := range stats.Levels {
+		if stats.TotalEnergy > 0 {
+			stats.Levels[i].RelativeEnergy = stats.Levels[i].Energy / stats.TotalEnergy
+		}
+		energies = append(energies, stats.Levels[i].Energy)
+	}
+	stats.Entropy = entropy(energies, stats.TotalEnergy)
+	return stats
+}
+
+// entropy returns the Shannon entropy, in bits, of the given non-negative
+// weights, which sum to total. It is 0 if the total is 0.
+func entropy(weights []float64, total float64) (ent float64) {
+	if total <= 0 {
+		return 0
+	}
+	for _, w := range weights {
+		if w > 0 {
+			p := w / total
+			ent -= p * math.Log2(p)
+		}
+	}
+	return
+}
+
+// Mosaic renders the coefficients as an 8-bit grey-scale image in the Mallat
+// layout. Each subband is scaled separately so that all are visible: the
+// approximation from its minimum to its maximum, and the detail subbands
+// symmetrically about mid-grey, so that zero coefficients are grey.
+func (dec *Decomposition) Mosaic() SippImage {
+	stride := dec.Coeffs.Rect.Dx()
+	out := NewFloatImage(dec.Coeffs.Rect)
+	render := func(r image.Rectangle, scale func(float64) float64) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				out.Pix[y*stride+x] = scale(dec.Coeffs.Pix[y*stride+x])
+			}
+		}
+	}
+	for lev := 1; lev <= dec.Levels; lev++ {
+		for band := HL; band <= HH; band++ {
+			var maxAbs float64
+			for _, c := range dec.SubbandValues(lev, band) {
+				maxAbs = math.Max(maxAbs, math.Abs(c))
+			}
+			render(dec.Subband(lev, band), func(c float64) float64 {
+				if maxAbs == 0 {
+					return 128
+				}
+				return 127.5 + 127.5*c/maxAbs
+			})
+		}
+	}
+	approx := dec.SubbandValues(dec.Levels, LL)
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, c := range approx {
+		lo = math.Min(lo, c)
+		hi = math.Max(hi, c)
+	}
+	render(dec.Subband(dec.Levels, LL), func(c float64) float64 {
+		if hi == lo {
+			return 128
+		}
+		return 255 * (c - lo) / (hi - lo)
+	})
+	return out.Quantise(8)
+}
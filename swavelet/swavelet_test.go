@@ -0,0 +1,124 @@
+// Copyright Raul Vera 2021
+
+// Tests for package swavelet.
+
+package swavelet
+
+import (
+	"math"
+	"testing"
+)
+
+import (
+	. "github.com/Causticity/sipp/simage"
+	. "github.com/Causticity/sipp/sipptesting"
+)
+
+const tolerance = 1e-9
+
+func TestRoundTrip(t *testing.T) {
+	src := ToFloat(SgrayCosxCosyTiny)
+	var srcEnergy float64
+	for _, v := range src.Pix {
+		srcEnergy += v * v
+	}
+	for _, wav := range wavelets {
+		levels := MaxLevels(wav, 20, 20)
+		if levels < 1 {
+			t.Errorf("Error: %s can't decompose a 20x20 image", wav.Name())
+			continue
+		}
+		dec, err := Decompose(SgrayCosxCosyTiny, wav, levels)
+		if err != nil {
+			t.Errorf("Error: %s decomposition failed: %v", wav.Name(), err)
+			continue
+		}
+		res := dec.Reconstruct()
+		for i, v := range res.Pix {
+			if math.Abs(v-src.Pix[i]) > tolerance {
+				t.Errorf("Error: %s reconstructed pixel %d is %v, expected %v",
+					wav.Name(), i, v, src.Pix[i])
+				break
+			}
+		}
+		// Orthogonal transforms preserve energy.
+		stats := dec.Stats()
+		if wav != CDF97 && math.Abs(stats.TotalEnergy-srcEnergy) > 1e-6*srcEnergy {
+			t.Errorf("Error: %s energy %v, expected %v", wav.Name(),
+				stats.TotalEnergy, srcEnergy)
+		}
+	}
+	if _, err := Decompose(SgrayCosxCosyTiny, Haar, 3); err == nil {
+		t.Error("Error: decomposing 20x20 to 3 levels didn't fail")
+	}
+	if MaxLevels(Daubechies8, 16, 16) != 2 || MaxLevels(Haar, 16, 16) != 4 {
+		t.Errorf("Error: max levels %d and %d, expected 2 and 4",
+			MaxLevels(Daubechies8, 16, 16), MaxLevels(Haar, 16, 16))
+	}
+	if wav, err := ByName("db4"); err != nil || wav != Daubechies4 {
+		t.Errorf("Error: ByName(\"db4\") returned %v, %v", wav, err)
+	}
+	if _, err := ByName("db6"); err == nil {
+		t.Error("Error: ByName(\"db6\") didn't fail")
+	}
+}
+
+func TestHaar(t *testing.T) {
+	// Vertical stripes put all the detail in the HL band of level 1.
+	pix := []float64{
+		1, 3, 1, 3,
+		1, 3, 1, 3,
+		1, 3, 1, 3,
+		1, 3, 1, 3,
+	}
+	dec, err := DecomposeFloat(FromFloatArray(pix, 4), Haar, 1)
+	if err != nil {
+		t.Fatalf("Error: decomposition failed: %v", err)
+	}
+	for _, c := range dec.SubbandValues(1, LL) {
+		if math.Abs(c-4) > tolerance {
+			t.Errorf("Error: LL coefficient %v, expected 4", c)
+		}
+	}
+	for _, c := range dec.SubbandValues(1, HL) {
+		if math.Abs(c+2) > tolerance {
+			t.Errorf("Error: HL coefficient %v, expected -2", c)
+		}
+	}
+	stats := dec.Stats()
+	lev := stats.Levels[0]
+	if math.Abs(lev.BandEnergy[0]-16) > tolerance || lev.BandEnergy[1] != 0 ||
+		lev.BandEnergy[2] != 0 || math.Abs(lev.Energy-16) > tolerance {
+		t.Errorf("Error: band energies %v, total %v", lev.BandEnergy, lev.Energy)
+	}
+	// Four equal coefficients give 2 bits. The approximation has energy 64,
+	// so the level has a fifth of the total.
+	waveEnt := -0.2*math.Log2(0.2) - 0.8*math.Log2(0.8)
+	if math.Abs(lev.Entropy-2) > tolerance || math.Abs(stats.Entropy-waveEnt) > tolerance {
+		t.Errorf("Error: level entropy %v, wavelet entropy %v", lev.Entropy, stats.Entropy)
+	}
+	if math.Abs(lev.RelativeEnergy-0.2) > tolerance {
+		t.Errorf("Error: relative energy %v, expected 0.2", lev.RelativeEnergy)
+	}
+
+	mos := dec.Mosaic()
+	if mos.Bounds() != dec.Coeffs.Rect || mos.Bpp() != 8 {
+		t.Errorf("Error: mosaic bounds %v, depth %d", mos.Bounds(), mos.Bpp())
+	}
+	// The constant LL band is mid-grey, the HL band black and the others,
+	// which are zero, mid-grey.
+	expected := []int32{
+		128, 128, 0, 0,
+		128, 128, 0, 0,
+		128, 128, 128, 128,
+		128, 128, 128, 128,
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if mos.IntVal(x, y) != expected[y*4+x] {
+				t.Errorf("Error: mosaic at %d,%d is %d, expected %d", x, y,
+					mos.IntVal(x, y), expected[y*4+x])
+			}
+		}
+	}
+}